@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+// goTypes are the Go types of the declared field types. Timestamps are strings, like the timestamps maintained by the
+// server, and are stored as timestamptz.
+var goTypes = map[model.FieldType]string{
+	model.FieldTypeString:    "string",
+	model.FieldTypeInt:       "int64",
+	model.FieldTypeFloat:     "float64",
+	model.FieldTypeBoolean:   "bool",
+	model.FieldTypeTimestamp: "string",
+}
+
+type field struct {
+	GoName string
+	GoType string
+	Tag    string
+	// JSONName is empty for fields that are not exposed
+	JSONName string
+}
+
+type constant struct {
+	Name  string
+	Value string
+}
+
+var objectTemplate = template.Must(template.New("object").Parse(`// Code generated by widget-gen from {{.Source}}. DO NOT EDIT.
+
+package generated
+
+type Object struct {
+	// the table is chosen by the store, see store.PgStore.Table
+	tableName struct{} ` + "`" + `sql:"?object_table"` + "`" + `
+
+{{range .Fields}}	{{.GoName}} {{.GoType}} ` + "`" + `{{.Tag}}` + "`" + `
+{{end}}}
+
+// JSON names of the fields of Object, as used by list filters, sort orders and update actions.
+const (
+{{range .Fields}}{{if .JSONName}}	Field{{.GoName}} = "{{.JSONName}}"
+{{end}}{{end}})
+{{if .Actions}}
+// Names of the update actions.
+const (
+{{range .Actions}}	Action{{.Name}} = "{{.Value}}"
+{{end}})
+{{end}}`))
+
+// Generate returns the source of generated/model.go for the fields declared in the API definition. source names the
+// API definition in the header of the file.
+func Generate(api model.API, source string) ([]byte, error) {
+	if len(api.Fields) == 0 {
+		return nil, errors.New("the api definition declares no fields")
+	}
+
+	fields := []field{
+		field{GoName: "ID", GoType: "string", Tag: `json:"id" sql:"type:uuid,default:gen_random_uuid()"`, JSONName: "id"},
+		field{GoName: "CreatedBy", GoType: "string", Tag: `json:"createdBy"`, JSONName: "createdBy"},
+		field{GoName: "TenantID", GoType: "string", Tag: `json:"-"`},
+	}
+	for _, f := range api.Fields {
+		tag := `json:"` + f.Name + `"`
+		if f.Type == model.FieldTypeTimestamp {
+			tag += ` sql:"type:timestamptz"`
+		}
+		fields = append(fields, field{GoName: exported(f.Name), GoType: goTypes[f.Type], Tag: tag, JSONName: f.Name})
+	}
+	fields = append(fields,
+		field{GoName: "CreatedAt", GoType: "string", Tag: `json:"createdAt" sql:"default:now()"`, JSONName: "createdAt"},
+		field{GoName: "Version", GoType: "int64", Tag: `json:"version" sql:",notnull,default:1"`, JSONName: "version"},
+		field{GoName: "UpdatedAt", GoType: "string", Tag: `json:"updatedAt" sql:"default:now()"`, JSONName: "updatedAt"},
+		field{GoName: "UpdatedBy", GoType: "string", Tag: `json:"updatedBy"`, JSONName: "updatedBy"},
+		field{GoName: "DeletedAt", GoType: "string", Tag: `json:"-" sql:"type:timestamptz"`},
+	)
+
+	var actions []constant
+	seen := map[string]string{}
+	if api.Operations != nil && api.Operations.Update != nil {
+		for _, action := range api.Operations.Update.Actions {
+			name := exported(action.Name)
+			if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+				return nil, errors.Errorf("action %q has no valid Go name", action.Name)
+			}
+			if other, ok := seen[name]; ok {
+				return nil, errors.Errorf("actions %q and %q have the same Go name %s", other, action.Name, name)
+			}
+			seen[name] = action.Name
+			actions = append(actions, constant{Name: name, Value: action.Name})
+		}
+	}
+
+	var buf bytes.Buffer
+	err := objectTemplate.Execute(&buf, struct {
+		Source  string
+		Fields  []field
+		Actions []constant
+	}{source, fields, actions})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute template")
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to format generated source")
+	}
+	return src, nil
+}
+
+// exported returns the exported Go name of a JSON field or action name: the name with its first letter upper-cased,
+// and with each letter or digit following a character that is not a letter or digit upper-cased in its place. Field
+// names keep their Go name in line with the column go-pg derives from it, which is the column the stores use.
+func exported(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gracew/widget-proxy/config"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestGenerate compares the file generated from each API definition in testdata with its golden file.
+func TestGenerate(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, paths)
+	for _, path := range paths {
+		api, err := config.API(path)
+		assert.NoError(t, err)
+		src, err := Generate(*api, filepath.Base(path))
+		assert.NoError(t, err, path)
+
+		golden := strings.TrimSuffix(path, ".json") + ".golden"
+		if *update {
+			assert.NoError(t, ioutil.WriteFile(golden, src, 0644))
+		}
+		expected, err := ioutil.ReadFile(golden)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), string(src), golden)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	_, err := Generate(model.API{}, "api.json")
+	assert.EqualError(t, err, "the api definition declares no fields")
+
+	fields := []model.FieldDefinition{model.FieldDefinition{Name: "test", Type: model.FieldTypeString}}
+	actions := func(names ...string) *model.OperationDefinition {
+		var defs []model.ActionDefinition
+		for _, name := range names {
+			defs = append(defs, model.ActionDefinition{Name: name})
+		}
+		return &model.OperationDefinition{Update: &model.UpdateDefinition{Actions: defs}}
+	}
+	_, err = Generate(model.API{Fields: fields, Operations: actions("mark-done", "markDone")}, "api.json")
+	assert.EqualError(t, err, `actions "mark-done" and "markDone" have the same Go name MarkDone`)
+	_, err = Generate(model.API{Fields: fields, Operations: actions("2fa")}, "api.json")
+	assert.EqualError(t, err, `action "2fa" has no valid Go name`)
+}
+
+func TestExported(t *testing.T) {
+	assert.Equal(t, "PageCount", exported("pageCount"))
+	assert.Equal(t, "MarkPublished", exported("mark-published"))
+	assert.Equal(t, "V2", exported("v2"))
+}
@@ -0,0 +1,49 @@
+// Command widget-gen generates generated/model.go from an API definition that declares the fields of its objects.
+//
+//	widget-gen -api /app/api.json -out generated/model.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gracew/widget-proxy/config"
+	"github.com/gracew/widget-proxy/model"
+)
+
+var (
+	apiPath = flag.String("api", "api.json", "path of the API definition")
+	outPath = flag.String("out", "", "path of the generated file, or standard output if empty")
+)
+
+func main() {
+	flag.Parse()
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "widget-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	api, err := config.API(*apiPath)
+	if err != nil {
+		return err
+	}
+	err = config.ValidateDefinitions(*api, model.Auth{}, model.AllCustomLogic{})
+	if err != nil {
+		return err
+	}
+	src, err := Generate(*api, filepath.Base(*apiPath))
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(*outPath, src, 0644)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+// apiNamePattern restricts API names to those usable as a path segment, a metric namespace and a table name prefix.
+var apiNamePattern = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+// reservedAPINames would collide with the other paths the server handles.
+var reservedAPINames = map[string]bool{"metrics": true}
+
+// APIDefinitions are the definitions of an API hosted by the server.
+type APIDefinitions struct {
+	// Name is the path prefix, metric namespace and table name prefix of the API. It is empty if the server hosts a
+	// single API.
+	Name        string
+	API         model.API
+	Auth        model.Auth
+	CustomLogic model.AllCustomLogic
+	// AuthPath and CustomLogicPath are the files the auth and custom logic definitions are reloaded from.
+	AuthPath        string
+	CustomLogicPath string
+}
+
+// LoadDefinitions reads the API, auth and custom logic definitions from the given files, and validates them.
+func LoadDefinitions(name string, apiPath string, authPath string, customLogicPath string) (*APIDefinitions, error) {
+	api, err := API(apiPath)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := Auth(authPath)
+	if err != nil {
+		return nil, err
+	}
+	customLogic, err := CustomLogic(customLogicPath)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateDefinitions(*api, *auth, *customLogic)
+	if err != nil {
+		return nil, err
+	}
+	return &APIDefinitions{
+		Name:            name,
+		API:             *api,
+		Auth:            *auth,
+		CustomLogic:     *customLogic,
+		AuthPath:        authPath,
+		CustomLogicPath: customLogicPath,
+	}, nil
+}
+
+// APIs returns the definitions of the APIs hosted by the server. If APIsDir is set, each of its subdirectories holds
+// the api.json, auth.json and customLogic.json of an API named after the subdirectory. Otherwise the server hosts the
+// single unnamed API defined by APIPath, AuthPath and CustomLogicPath.
+func (c Config) APIs() ([]APIDefinitions, error) {
+	if c.APIsDir == "" {
+		defs, err := LoadDefinitions("", c.APIPath, c.AuthPath, c.CustomLogicPath)
+		if err != nil {
+			return nil, err
+		}
+		return []APIDefinitions{*defs}, nil
+	}
+
+	entries, err := ioutil.ReadDir(c.APIsDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read apis dir '%s'", c.APIsDir)
+	}
+	var apis []APIDefinitions
+	for _, entry := range entries {
+		// hidden directories, such as those of a mounted Kubernetes ConfigMap, are not APIs
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := entry.Name()
+		if !apiNamePattern.MatchString(name) || reservedAPINames[name] {
+			return nil, errors.Errorf("invalid api name '%s': must match %s and must not be reserved", name, apiNamePattern)
+		}
+		dir := filepath.Join(c.APIsDir, name)
+		defs, err := LoadDefinitions(name, filepath.Join(dir, apiFile), filepath.Join(dir, authFile),
+			filepath.Join(dir, customLogicFile))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid api '%s'", name)
+		}
+		apis = append(apis, *defs)
+	}
+	if len(apis) == 0 {
+		return nil, errors.Errorf("no apis in apis dir '%s'", c.APIsDir)
+	}
+	return apis, nil
+}
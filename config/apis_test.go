@@ -0,0 +1,64 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIs(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "apis-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeAPI(t, dir, "widgets", `{"id": "widgets"}`)
+	writeAPI(t, dir, "gadgets", `{"id": "gadgets"}`)
+	// hidden directories are skipped
+	writeAPI(t, dir, "..data", `not json`)
+
+	apis, err := Config{APIsDir: dir}.APIs()
+	assert.NoError(t, err)
+	assert.Len(t, apis, 2)
+	assert.Equal(t, "gadgets", apis[0].Name)
+	assert.Equal(t, "gadgets", apis[0].API.ID)
+	assert.Equal(t, filepath.Join(dir, "gadgets", "auth.json"), apis[0].AuthPath)
+	assert.Equal(t, "widgets", apis[1].Name)
+
+	c := Config{APIPath: filepath.Join(dir, "widgets", apiFile), AuthPath: filepath.Join(dir, "widgets", authFile),
+		CustomLogicPath: filepath.Join(dir, "widgets", customLogicFile)}
+	apis, err = c.APIs()
+	assert.NoError(t, err)
+	assert.Len(t, apis, 1)
+	assert.Equal(t, "", apis[0].Name)
+	assert.Equal(t, "widgets", apis[0].API.ID)
+}
+
+func TestAPIsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "apis-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = Config{APIsDir: dir}.APIs()
+	assert.EqualError(t, err, "no apis in apis dir '"+dir+"'")
+
+	writeAPI(t, dir, "metrics", `{}`)
+	_, err = Config{APIsDir: dir}.APIs()
+	assert.Contains(t, err.Error(), "invalid api name 'metrics'")
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "metrics")))
+	writeAPI(t, dir, "widgets", `{"id": "widgets"}`)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "widgets", authFile), []byte(`{"apiID": "gadgets"}`), 0644))
+	_, err = Config{APIsDir: dir}.APIs()
+	assert.Contains(t, err.Error(), "invalid api 'widgets': invalid definitions:\nauth.json: apiID")
+}
+
+// writeAPI writes the definitions of an API, with empty auth and custom logic definitions.
+func writeAPI(t *testing.T, dir string, name string, api string) {
+	apiDir := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(apiDir, 0755))
+	for file, content := range map[string]string{apiFile: api, authFile: `{}`, customLogicFile: `{}`} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(apiDir, file), []byte(content), 0644))
+	}
+}
@@ -9,19 +9,9 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	ParseURL        = "http://parse:1337/parse/"
-	PostgresAddress = "api-postgres:5432"
-	CustomLogicURL  = "http://custom-logic:8080/"
-
-	APIPath         = "/app/api.json"
-	AuthPath        = "/app/auth.json"
-	CustomLogicPath = "/app/customLogic.json"
-)
-
-var (
-	APIName = os.Getenv("API_NAME")
-)
+// APIName namespaces the metrics. Unlike the other settings it is read only from the environment, because metrics
+// are registered when the program starts.
+var APIName = os.Getenv("API_NAME")
 
 // API reads the API specification from the given file.
 func API(path string) (*model.API, error) {
@@ -67,3 +57,18 @@ func CustomLogic(path string) (*model.AllCustomLogic, error) {
 
 	return &customLogic, nil
 }
+
+// APIKeys reads the API keys accepted for service-to-service callers from the given file.
+func APIKeys(path string) (*model.APIKeys, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read api keys file '%s'", path)
+	}
+	var apiKeys model.APIKeys
+	err = json.Unmarshal(bytes, &apiKeys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal api keys file '%s'", path)
+	}
+
+	return &apiKeys, nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gracew/widget-proxy/model"
 	"github.com/pkg/errors"
@@ -18,7 +19,12 @@ func TestAuth(t *testing.T) {
 	}
 	input := model.Auth{
 		APIID: "apiID",
-		Read:  &createdByAuthPolicy,
+		Read: &model.AuthPolicy{
+			Type:     model.AuthPolicyTypeAnyOf,
+			Policies: []*model.AuthPolicy{&createdByAuthPolicy, &model.AuthPolicy{Type: model.AuthPolicyTypeRole}},
+		},
+		Delete: &createdByAuthPolicy,
+		Roles:  map[string][]string{"admin": []string{"read", "delete"}},
 	}
 
 	path, err := writeTmpFile(input, "auth-")
@@ -48,6 +54,21 @@ func TestCustomLogic(t *testing.T) {
 	assert.Equal(t, input, *output)
 }
 
+func TestAPIKeys(t *testing.T) {
+	expiresAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	input := model.APIKeys{Keys: []model.APIKey{
+		model.APIKey{Hash: "hash1", Principal: "billing", Scopes: []string{"read", "list"}, ExpiresAt: &expiresAt},
+		model.APIKey{Hash: "hash2", Principal: "reports"},
+	}}
+
+	path, err := writeTmpFile(input, "api-keys-")
+	assert.NoError(t, err)
+
+	output, err := APIKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, input, *output)
+}
+
 func writeTmpFile(input interface{}, prefix string) (string, error) {
 	file, err := ioutil.TempFile(os.TempDir(), prefix)
 	if err != nil {
@@ -0,0 +1,428 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConfigFileEnv is the environment variable naming the configuration file, if the --config flag is not set.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// redacted replaces secrets when printing the configuration.
+const redacted = "REDACTED"
+
+// Config is the configuration of the API server.
+type Config struct {
+	Port string
+	// GRPCPort is the port of the gRPC server. gRPC is not served if it is empty.
+	GRPCPort string
+	// ParseURL is the URL of the Parse server that authenticates users.
+	ParseURL string
+	// CustomLogicURL is the URL of the runtime executing custom logic.
+	CustomLogicURL string
+
+	APIPath         string
+	AuthPath        string
+	CustomLogicPath string
+	// APIKeysPath is optional: API keys are not accepted if the file does not exist.
+	APIKeysPath string
+	// APIsDir hosts several APIs instead of the single API of APIPath, AuthPath and CustomLogicPath. See APIs.
+	APIsDir string
+
+	// MultiTenant enables tenant isolation, scoping every object to the tenant of the user that created it.
+	MultiTenant bool
+	// SQLitePath is the path of an embedded SQLite database to use instead of Postgres, for local development.
+	SQLitePath string
+	Database   Database
+	// ReplicaAddresses are the addresses of read-only Postgres replicas.
+	ReplicaAddresses []string
+	// ReadYourWritesWindow is how long after writing a user's reads are served by the primary rather than replicas.
+	ReadYourWritesWindow time.Duration
+	// AllowDestructiveMigrations allows migrations that may lose data, such as dropping columns.
+	AllowDestructiveMigrations bool
+	// ReloadInterval is how often the auth and custom logic definitions are checked for changes. If 0, they are only
+	// reloaded on SIGHUP.
+	ReloadInterval time.Duration
+	// MaxBulkItems is the most items a bulk request may hold.
+	MaxBulkItems int
+}
+
+// Default returns the configuration used for settings that are not set by flags, the environment or the config file.
+func Default() Config {
+	return Config{
+		Port:                 "8080",
+		ParseURL:             "http://parse:1337/parse/",
+		CustomLogicURL:       "http://custom-logic:8080/",
+		APIPath:              "/app/api.json",
+		AuthPath:             "/app/auth.json",
+		CustomLogicPath:      "/app/customLogic.json",
+		APIKeysPath:          "/app/apiKeys.json",
+		Database:             Database{URL: DefaultDatabaseURL, StartupTimeout: time.Minute},
+		ReadYourWritesWindow: 5 * time.Second,
+		ReloadInterval:       10 * time.Second,
+		MaxBulkItems:         1000,
+	}
+}
+
+// setting is a configuration value that can be set by a flag, an environment variable and a config file key.
+type setting struct {
+	// name is the name of the flag and of the config file key
+	name   string
+	env    string
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+	// get is nil for settings that are not printed. It redacts secrets.
+	get func(c *Config) string
+}
+
+var settings = []setting{
+	stringSetting("port", "PORT", "port to listen on", func(c *Config) *string { return &c.Port }),
+	stringSetting("grpc-port", "GRPC_PORT", "port to serve gRPC on, or empty not to serve gRPC", func(c *Config) *string { return &c.GRPCPort }),
+	stringSetting("parse-url", "PARSE_URL", "URL of the Parse server", func(c *Config) *string { return &c.ParseURL }),
+	stringSetting("custom-logic-url", "CUSTOM_LOGIC_URL", "URL of the custom logic runtime", func(c *Config) *string { return &c.CustomLogicURL }),
+	stringSetting("api-path", "API_PATH", "path of the API definition", func(c *Config) *string { return &c.APIPath }),
+	stringSetting("auth-path", "AUTH_PATH", "path of the auth definition", func(c *Config) *string { return &c.AuthPath }),
+	stringSetting("custom-logic-path", "CUSTOM_LOGIC_PATH", "path of the custom logic definition", func(c *Config) *string { return &c.CustomLogicPath }),
+	stringSetting("apis-dir", "APIS_DIR", "directory with a subdirectory of definitions for each API to host, instead of api-path, auth-path and custom-logic-path", func(c *Config) *string { return &c.APIsDir }),
+	stringSetting("api-keys-path", "API_KEYS_PATH", "path of the optional API keys file", func(c *Config) *string { return &c.APIKeysPath }),
+	boolSetting("multi-tenant", "MULTI_TENANT", "isolate objects by tenant", func(c *Config) *bool { return &c.MultiTenant }),
+	stringSetting("sqlite-path", "SQLITE_PATH", "path of a SQLite database to use instead of Postgres", func(c *Config) *string { return &c.SQLitePath }),
+	secretURLSetting("database-url", "DATABASE_URL", "Postgres connection URL", func(c *Config) *string { return &c.Database.URL }),
+	{
+		name:  "database-url-file",
+		env:   "DATABASE_URL_FILE",
+		usage: "file to read the Postgres connection URL from, overriding database-url",
+		set: func(c *Config, path string) error {
+			bytes, err := ioutil.ReadFile(path)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read database url file '%s'", path)
+			}
+			c.Database.URL = strings.TrimSpace(string(bytes))
+			return nil
+		},
+	},
+	stringSetting("database-tls-mode", "DATABASE_TLS_MODE", "disable, require, verify-ca or verify-full; overrides the sslmode of the URL", func(c *Config) *string { return &c.Database.TLSMode }),
+	stringSetting("database-tls-root-cert-file", "DATABASE_TLS_ROOT_CERT_FILE", "PEM file of the CAs trusted to sign the database certificate", func(c *Config) *string { return &c.Database.TLSRootCertFile }),
+	intSetting("database-pool-size", "DATABASE_POOL_SIZE", "maximum number of connections to each database server", func(c *Config) *int { return &c.Database.PoolSize }),
+	intSetting("database-min-idle-conns", "DATABASE_MIN_IDLE_CONNS", "number of idle connections kept open to each database server", func(c *Config) *int { return &c.Database.MinIdleConns }),
+	durationSetting("database-statement-timeout", "DATABASE_STATEMENT_TIMEOUT", "duration after which statements are aborted", func(c *Config) *time.Duration { return &c.Database.StatementTimeout }),
+	durationSetting("database-startup-timeout", "DATABASE_STARTUP_TIMEOUT", "how long to wait at startup for the database", func(c *Config) *time.Duration { return &c.Database.StartupTimeout }),
+	listSetting("postgres-replica-addresses", "POSTGRES_REPLICA_ADDRESSES", "comma-separated addresses of read-only Postgres replicas", func(c *Config) *[]string { return &c.ReplicaAddresses }),
+	durationSetting("read-your-writes-window", "READ_YOUR_WRITES_WINDOW", "how long after writing a user reads from the primary", func(c *Config) *time.Duration { return &c.ReadYourWritesWindow }),
+	boolSetting("allow-destructive-migrations", "ALLOW_DESTRUCTIVE_MIGRATIONS", "apply schema migrations that may lose data", func(c *Config) *bool { return &c.AllowDestructiveMigrations }),
+	durationSetting("reload-interval", "RELOAD_INTERVAL", "how often to check the auth and custom logic definitions for changes, or 0 to reload only on SIGHUP", func(c *Config) *time.Duration { return &c.ReloadInterval }),
+	intSetting("max-bulk-items", "MAX_BULK_ITEMS", "most items a bulk request may hold", func(c *Config) *int { return &c.MaxBulkItems }),
+}
+
+func stringSetting(name string, env string, usage string, field func(c *Config) *string) setting {
+	return setting{
+		name:  name,
+		env:   env,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+		get: func(c *Config) string { return *field(c) },
+	}
+}
+
+// secretURLSetting is a URL setting whose password is redacted when printed.
+func secretURLSetting(name string, env string, usage string, field func(c *Config) *string) setting {
+	s := stringSetting(name, env, usage, field)
+	s.get = func(c *Config) string {
+		u, err := url.Parse(*field(c))
+		if err != nil {
+			return redacted
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		return u.String()
+	}
+	return s
+}
+
+func boolSetting(name string, env string, usage string, field func(c *Config) *bool) setting {
+	return setting{
+		name:   name,
+		env:    env,
+		usage:  usage,
+		isBool: true,
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			*field(c) = b
+			return err
+		},
+		get: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func intSetting(name string, env string, usage string, field func(c *Config) *int) setting {
+	return setting{
+		name:  name,
+		env:   env,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			i, err := strconv.Atoi(value)
+			*field(c) = i
+			return err
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func durationSetting(name string, env string, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{
+		name:  name,
+		env:   env,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			*field(c) = d
+			return err
+		},
+		get: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func listSetting(name string, env string, usage string, field func(c *Config) *[]string) setting {
+	return setting{
+		name:  name,
+		env:   env,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			*field(c) = split(value)
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(*field(c), ",") },
+	}
+}
+
+// split splits a comma-separated list, ignoring empty elements.
+func split(list string) []string {
+	var res []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Flags are the configuration flags registered on a flag set, read by Load once the flag set has been parsed.
+type Flags struct {
+	file   *string
+	values map[string]*flagValue
+}
+
+// flagValue records the value of a flag, if it is set.
+type flagValue struct {
+	isBool bool
+	value  *string
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = &value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// RegisterFlags registers a flag for each setting, and a --config flag naming the config file, on the flag set.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{
+		file:   fs.String("config", "", fmt.Sprintf("path of a JSON configuration file (env %s)", ConfigFileEnv)),
+		values: map[string]*flagValue{},
+	}
+	for _, s := range settings {
+		value := &flagValue{isBool: s.isBool}
+		fs.Var(value, s.name, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+		flags.values[s.name] = value
+	}
+	return flags
+}
+
+// Load returns the validated configuration. Flags take precedence over environment variables, which take precedence
+// over the config file, which takes precedence over the defaults. The config file is a JSON object keyed by flag name.
+func Load(flags *Flags, getenv func(string) string) (*Config, error) {
+	c := Default()
+
+	path := *flags.file
+	if path == "" {
+		path = getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = apply(&c, fmt.Sprintf("config file '%s'", path), func(s setting) (string, bool) {
+			value, ok := file[s.name]
+			delete(file, s.name)
+			return value, ok
+		})
+		if err != nil {
+			return nil, err
+		}
+		for key := range file {
+			return nil, errors.Errorf("unknown setting '%s' in config file '%s'", key, path)
+		}
+	}
+
+	err := apply(&c, "environment", func(s setting) (string, bool) {
+		value := getenv(s.env)
+		return value, value != ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = apply(&c, "flags", func(s setting) (string, bool) {
+		value := flags.values[s.name].value
+		if value == nil {
+			return "", false
+		}
+		return *value, true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// apply sets each setting for which lookup returns a value.
+func apply(c *Config, source string, lookup func(s setting) (string, bool)) error {
+	for _, s := range settings {
+		value, ok := lookup(s)
+		if !ok {
+			continue
+		}
+		err := s.set(c, value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s from %s", s.name, source)
+		}
+	}
+	return nil
+}
+
+// readConfigFile reads a JSON object of settings. Values may be strings, numbers, booleans or arrays of strings.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file '%s'", path)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	err = decoder.Decode(&raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal config file '%s'", path)
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case []interface{}:
+			var list []string
+			for _, e := range v {
+				s, ok := e.(string)
+				if !ok {
+					return nil, errors.Errorf("invalid value of '%s' in config file '%s'", key, path)
+				}
+				list = append(list, s)
+			}
+			values[key] = strings.Join(list, ",")
+		default:
+			return nil, errors.Errorf("invalid value of '%s' in config file '%s'", key, path)
+		}
+	}
+	return values, nil
+}
+
+// Validate returns an error describing every invalid setting of the configuration.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 1<<16, "port must be a number between 1 and 65535")
+	if c.GRPCPort != "" {
+		grpcPort, err := strconv.Atoi(c.GRPCPort)
+		check(err == nil && grpcPort > 0 && grpcPort < 1<<16, "grpc-port must be a number between 1 and 65535")
+		check(c.GRPCPort != c.Port, "grpc-port must differ from port")
+	}
+	for name, u := range map[string]string{"parse-url": c.ParseURL, "custom-logic-url": c.CustomLogicURL} {
+		parsed, err := url.Parse(u)
+		check(err == nil && parsed.IsAbs() && strings.HasSuffix(u, "/"), "%s must be an absolute URL ending with /", name)
+	}
+	check(c.APIPath != "", "api-path must be set")
+	check(c.AuthPath != "", "auth-path must be set")
+	check(c.CustomLogicPath != "", "custom-logic-path must be set")
+	check(c.ReadYourWritesWindow >= 0, "read-your-writes-window must not be negative")
+	check(c.ReloadInterval >= 0, "reload-interval must not be negative")
+	check(c.MaxBulkItems > 0, "max-bulk-items must be positive")
+
+	if c.SQLitePath != "" {
+		check(len(c.ReplicaAddresses) == 0, "postgres-replica-addresses cannot be used with sqlite-path")
+	} else {
+		_, err := c.Database.Options("")
+		check(err == nil, "%v", err)
+		check(c.Database.PoolSize >= 0, "database-pool-size must not be negative")
+		check(c.Database.MinIdleConns >= 0, "database-min-idle-conns must not be negative")
+		check(c.Database.StatementTimeout >= 0, "database-statement-timeout must not be negative")
+		check(c.Database.StartupTimeout > 0, "database-startup-timeout must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Print writes the configuration as a config file, with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	values := map[string]string{}
+	for _, s := range settings {
+		if s.get != nil {
+			values[s.name] = s.get(&c)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gracew/widget-proxy/generated"
+	"github.com/gracew/widget-proxy/model"
+)
+
+const (
+	apiFile         = "api.json"
+	authFile        = "auth.json"
+	customLogicFile = "customLogic.json"
+)
+
+// operations are the operations that roles may be allowed to invoke, besides update actions.
+var operations = []string{"create", "read", "list", "delete", "restore", "patch"}
+
+// storeFields are maintained by the store, which ignores them in update actions.
+var storeFields = map[string]bool{"version": true, "updatedAt": true, "updatedBy": true}
+
+// fieldNamePattern restricts declared field names to JSON names that map to column names safely.
+var fieldNamePattern = regexp.MustCompile("^[a-z][a-zA-Z0-9]*$")
+
+// DefinitionError is a problem in a definition file, located by its JSON path.
+type DefinitionError struct {
+	File    string
+	Path    string
+	Message string
+}
+
+func (e DefinitionError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Message)
+}
+
+// DefinitionErrors are the problems found in the definition files.
+type DefinitionErrors []DefinitionError
+
+func (e DefinitionErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return "invalid definitions:\n" + strings.Join(lines, "\n")
+}
+
+// ValidateDefinitions cross-checks the API, auth and custom logic definitions against each other and against the
+// fields of the objects: those declared in the API definition, or else those of generated.Object. It returns
+// DefinitionErrors listing every problem found, or nil.
+func ValidateDefinitions(api model.API, auth model.Auth, customLogic model.AllCustomLogic) error {
+	v := validator{fields: objectFields(), actions: map[string]bool{}}
+	if len(api.Fields) > 0 {
+		v.declaredFields(api.Fields)
+	}
+	v.api(api)
+	v.auth(api, auth)
+	v.customLogic(api, customLogic)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	// fields are the JSON names of the fields of generated.Object
+	fields  map[string]bool
+	actions map[string]bool
+	errs    DefinitionErrors
+}
+
+func (v *validator) errorf(file string, path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, DefinitionError{File: file, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) field(file string, path string, field string) {
+	if !v.fields[field] {
+		v.errorf(file, path, "unknown field %q", field)
+	}
+}
+
+// declaredFields checks the fields declared in the API definition, and replaces the fields of generated.Object with
+// them and the system fields.
+func (v *validator) declaredFields(fields []model.FieldDefinition) {
+	v.fields = map[string]bool{}
+	system := map[string]bool{}
+	for _, f := range model.SystemFields {
+		v.fields[f.Name] = true
+		system[f.Name] = true
+	}
+	for i, f := range fields {
+		path := fmt.Sprintf("fields[%d]", i)
+		switch {
+		case !fieldNamePattern.MatchString(f.Name):
+			v.errorf(apiFile, path+".name", "invalid field name %q, must match %s", f.Name, fieldNamePattern)
+		case system[f.Name]:
+			v.errorf(apiFile, path+".name", "field %q is maintained by the server", f.Name)
+		case v.fields[f.Name]:
+			v.errorf(apiFile, path+".name", "duplicate field %q", f.Name)
+		}
+		v.fields[f.Name] = true
+		if !f.Type.IsValid() {
+			v.errorf(apiFile, path+".type", "invalid field type %q, must be one of %v", f.Type, model.AllFieldType)
+		}
+	}
+}
+
+func (v *validator) api(api model.API) {
+	ops := api.Operations
+	if ops == nil {
+		return
+	}
+
+	if ops.List != nil {
+		for i, s := range ops.List.Sort {
+			path := fmt.Sprintf("operations.list.sort[%d]", i)
+			v.field(apiFile, path+".field", s.Field)
+			if !s.Order.IsValid() {
+				v.errorf(apiFile, path+".order", "invalid sort order %q, must be one of %v", s.Order, model.AllSortOrder)
+			}
+		}
+		for i, f := range ops.List.Filter {
+			v.field(apiFile, fmt.Sprintf("operations.list.filter[%d]", i), f)
+		}
+	}
+
+	if ops.Update != nil {
+		for i, action := range ops.Update.Actions {
+			path := fmt.Sprintf("operations.update.actions[%d]", i)
+			switch {
+			case action.Name == "":
+				v.errorf(apiFile, path+".name", "action name is required")
+			case v.actions[action.Name]:
+				v.errorf(apiFile, path+".name", "duplicate action %q", action.Name)
+			case contains(operations, action.Name):
+				v.errorf(apiFile, path+".name", "action %q has the name of an operation", action.Name)
+			}
+			v.actions[action.Name] = true
+			for j, f := range action.Fields {
+				fieldPath := fmt.Sprintf("%s.fields[%d]", path, j)
+				v.field(apiFile, fieldPath, f)
+				if storeFields[f] {
+					v.errorf(apiFile, fieldPath, "field %q is maintained by the server and cannot be updated", f)
+				}
+			}
+		}
+		if patch := ops.Update.Patch; patch != nil {
+			// the auth policy and custom logic of PATCH /{id} are those of an action named patch
+			v.actions["patch"] = true
+			for i, f := range patch.Fields {
+				fieldPath := fmt.Sprintf("operations.update.patch.fields[%d]", i)
+				v.field(apiFile, fieldPath, f)
+				if storeFields[f] || f == "id" {
+					v.errorf(apiFile, fieldPath, "field %q is maintained by the server and cannot be updated", f)
+				}
+			}
+		}
+	}
+
+	if ops.Delete != nil {
+		if ops.Delete.RetentionDays < 0 {
+			v.errorf(apiFile, "operations.delete.retentionDays", "must not be negative")
+		}
+		if ops.Delete.RetentionDays > 0 && !ops.Delete.SoftDelete {
+			v.errorf(apiFile, "operations.delete.retentionDays", "requires softDelete")
+		}
+	}
+}
+
+func (v *validator) auth(api model.API, auth model.Auth) {
+	if auth.APIID != "" && api.ID != "" && auth.APIID != api.ID {
+		v.errorf(authFile, "apiID", "%q does not match the API id %q", auth.APIID, api.ID)
+	}
+
+	v.policy("create", auth.Create)
+	v.policy("read", auth.Read)
+	for _, action := range sortedKeys(auth.Update) {
+		path := fmt.Sprintf("update.%s", action)
+		if !v.actions[action] {
+			v.errorf(authFile, path, "unknown action %q", action)
+		}
+		v.policy(path, auth.Update[action])
+	}
+	v.policy("delete", auth.Delete)
+	if auth.Restore != nil && (api.Operations == nil || api.Operations.Delete == nil || !api.Operations.Delete.SoftDelete) {
+		v.errorf(authFile, "restore", "requires softDelete in the API definition")
+	}
+	v.policy("restore", auth.Restore)
+	for _, field := range sortedKeys(auth.Fields) {
+		path := fmt.Sprintf("fields.%s", field)
+		v.field(authFile, path, field)
+		v.policy(path, auth.Fields[field])
+	}
+
+	for _, role := range sortedKeys(auth.Roles) {
+		for i, op := range auth.Roles[role] {
+			if !contains(operations, op) && !v.actions[op] {
+				v.errorf(authFile, fmt.Sprintf("roles.%s[%d]", role, i), "unknown operation or action %q", op)
+			}
+		}
+	}
+}
+
+func (v *validator) policy(path string, policy *model.AuthPolicy) {
+	if policy == nil {
+		return
+	}
+	if !policy.Type.IsValid() {
+		v.errorf(authFile, path+".type", "invalid policy type %q, must be one of %v", policy.Type, model.AllAuthPolicyType)
+		return
+	}
+
+	combinator := policy.Type == model.AuthPolicyTypeAnyOf || policy.Type == model.AuthPolicyTypeAllOf
+	if combinator && len(policy.Policies) == 0 {
+		v.errorf(authFile, path+".policies", "%s requires policies", policy.Type)
+	}
+	if !combinator && len(policy.Policies) > 0 {
+		v.errorf(authFile, path+".policies", "only ANY_OF and ALL_OF policies combine policies")
+	}
+	if policy.Type == model.AuthPolicyTypeAttributeMatch || policy.Type == model.AuthPolicyTypeCustom {
+		// not enforced by handlers/authz.go yet
+		v.errorf(authFile, path+".type", "%s policies are not supported", policy.Type)
+	}
+	if policy.Type != model.AuthPolicyTypeRole && len(policy.Roles) > 0 {
+		v.errorf(authFile, path+".roles", "only ROLE policies are restricted to roles")
+	}
+	for i, p := range policy.Policies {
+		if p == nil {
+			v.errorf(authFile, fmt.Sprintf("%s.policies[%d]", path, i), "policy is empty")
+			continue
+		}
+		v.policy(fmt.Sprintf("%s.policies[%d]", path, i), p)
+	}
+}
+
+func (v *validator) customLogic(api model.API, customLogic model.AllCustomLogic) {
+	if customLogic.APIID != "" && api.ID != "" && customLogic.APIID != api.ID {
+		v.errorf(customLogicFile, "apiID", "%q does not match the API id %q", customLogic.APIID, api.ID)
+	}
+	for _, action := range sortedKeys(customLogic.Update) {
+		if !v.actions[action] {
+			v.errorf(customLogicFile, "update."+action, "unknown action %q", action)
+		}
+	}
+}
+
+// objectFields returns the JSON names of the fields of generated.Object.
+func objectFields() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(generated.Object{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// sortedKeys returns the keys of a map with string keys, in order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
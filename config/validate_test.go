@@ -0,0 +1,119 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDefinitions(t *testing.T) {
+	api := model.API{ID: "apiID", Operations: &model.OperationDefinition{
+		List: &model.ListDefinition{
+			Sort:   []model.SortDefinition{model.SortDefinition{Field: "createdAt", Order: model.SortOrderDesc}},
+			Filter: []string{"test"},
+		},
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename", Fields: []string{"test"}}},
+			Patch:   &model.PatchDefinition{Fields: []string{"test"}},
+		},
+		Delete: &model.DeleteDefinition{SoftDelete: true, RetentionDays: 30},
+	}}
+	auth := model.Auth{
+		APIID: "apiID",
+		Read: &model.AuthPolicy{Type: model.AuthPolicyTypeAnyOf, Policies: []*model.AuthPolicy{
+			&model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy},
+			&model.AuthPolicy{Type: model.AuthPolicyTypeRole},
+		}},
+		Update: map[string]*model.AuthPolicy{
+			"rename": &model.AuthPolicy{Type: model.AuthPolicyTypeRole},
+			"patch":  &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy},
+		},
+		Restore: &model.AuthPolicy{Type: model.AuthPolicyTypeRole, Roles: []string{"admin"}},
+		Fields:  map[string]*model.AuthPolicy{"createdBy": &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		Roles:   map[string][]string{"admin": []string{"delete", "rename"}},
+	}
+	before := "before"
+	customLogic := model.AllCustomLogic{APIID: "apiID", Update: map[string]*model.CustomLogic{"rename": &model.CustomLogic{Before: &before}}}
+
+	assert.NoError(t, ValidateDefinitions(api, auth, customLogic))
+	assert.NoError(t, ValidateDefinitions(model.API{}, model.Auth{}, model.AllCustomLogic{}))
+}
+
+func TestValidateDefinitionsErrors(t *testing.T) {
+	api := model.API{ID: "apiID", Operations: &model.OperationDefinition{
+		List: &model.ListDefinition{
+			Sort:   []model.SortDefinition{model.SortDefinition{Field: "test", Order: "DOWN"}},
+			Filter: []string{"tset"},
+		},
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{
+				model.ActionDefinition{Name: "rename", Fields: []string{"test", "version"}},
+				model.ActionDefinition{Name: "rename"},
+				model.ActionDefinition{Name: "delete"},
+				model.ActionDefinition{Name: "patch"},
+			},
+			Patch: &model.PatchDefinition{Fields: []string{"tset", "updatedAt"}},
+		},
+		Delete: &model.DeleteDefinition{RetentionDays: 30},
+	}}
+	auth := model.Auth{
+		APIID: "otherAPI",
+		Read: &model.AuthPolicy{Type: model.AuthPolicyTypeAllOf, Policies: []*model.AuthPolicy{
+			&model.AuthPolicy{Type: model.AuthPolicyTypeAttributeMatch},
+			&model.AuthPolicy{Type: "OWNER"},
+			&model.AuthPolicy{Type: model.AuthPolicyTypeCustom},
+		}},
+		Update:  map[string]*model.AuthPolicy{"renam": &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		Restore: &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy, Roles: []string{"admin"}},
+		Fields:  map[string]*model.AuthPolicy{"secret": &model.AuthPolicy{Type: model.AuthPolicyTypeAnyOf}},
+		Roles:   map[string][]string{"admin": []string{"read", "archive"}},
+	}
+	customLogic := model.AllCustomLogic{Update: map[string]*model.CustomLogic{"archive": &model.CustomLogic{}}}
+
+	err := ValidateDefinitions(api, auth, customLogic)
+	assert.Equal(t, DefinitionErrors{
+		DefinitionError{apiFile, "operations.list.sort[0].order", `invalid sort order "DOWN", must be one of [ASC DESC]`},
+		DefinitionError{apiFile, "operations.list.filter[0]", `unknown field "tset"`},
+		DefinitionError{apiFile, "operations.update.actions[0].fields[1]", `field "version" is maintained by the server and cannot be updated`},
+		DefinitionError{apiFile, "operations.update.actions[1].name", `duplicate action "rename"`},
+		DefinitionError{apiFile, "operations.update.actions[2].name", `action "delete" has the name of an operation`},
+		DefinitionError{apiFile, "operations.update.actions[3].name", `action "patch" has the name of an operation`},
+		DefinitionError{apiFile, "operations.update.patch.fields[0]", `unknown field "tset"`},
+		DefinitionError{apiFile, "operations.update.patch.fields[1]", `field "updatedAt" is maintained by the server and cannot be updated`},
+		DefinitionError{apiFile, "operations.delete.retentionDays", "requires softDelete"},
+		DefinitionError{authFile, "apiID", `"otherAPI" does not match the API id "apiID"`},
+		DefinitionError{authFile, "read.policies[0].type", "ATTRIBUTE_MATCH policies are not supported"},
+		DefinitionError{authFile, "read.policies[1].type", `invalid policy type "OWNER", must be one of [CREATED_BY ATTRIBUTE_MATCH CUSTOM ROLE ANY_OF ALL_OF]`},
+		DefinitionError{authFile, "read.policies[2].type", "CUSTOM policies are not supported"},
+		DefinitionError{authFile, "update.renam", `unknown action "renam"`},
+		DefinitionError{authFile, "restore", "requires softDelete in the API definition"},
+		DefinitionError{authFile, "restore.roles", "only ROLE policies are restricted to roles"},
+		DefinitionError{authFile, "fields.secret", `unknown field "secret"`},
+		DefinitionError{authFile, "fields.secret.policies", "ANY_OF requires policies"},
+		DefinitionError{authFile, "roles.admin[1]", `unknown operation or action "archive"`},
+		DefinitionError{customLogicFile, "update.archive", `unknown action "archive"`},
+	}, err)
+	assert.Contains(t, err.Error(), "auth.json: update.renam: unknown action \"renam\"\n")
+}
+
+func TestValidateDeclaredFields(t *testing.T) {
+	api := model.API{
+		Fields: []model.FieldDefinition{
+			model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+			model.FieldDefinition{Name: "page_count", Type: model.FieldTypeInt},
+			model.FieldDefinition{Name: "version", Type: model.FieldTypeInt},
+			model.FieldDefinition{Name: "title", Type: "TEXT"},
+		},
+		Operations: &model.OperationDefinition{List: &model.ListDefinition{Filter: []string{"title", "test"}}},
+	}
+
+	assert.Equal(t, DefinitionErrors{
+		DefinitionError{apiFile, "fields[1].name", `invalid field name "page_count", must match ^[a-z][a-zA-Z0-9]*$`},
+		DefinitionError{apiFile, "fields[2].name", `field "version" is maintained by the server`},
+		DefinitionError{apiFile, "fields[3].name", `duplicate field "title"`},
+		DefinitionError{apiFile, "fields[3].type", `invalid field type "TEXT", must be one of [STRING INT FLOAT BOOLEAN TIMESTAMP]`},
+		// the fields of generated.Object do not apply
+		DefinitionError{apiFile, "operations.list.filter[1]", `unknown field "test"`},
+	}, ValidateDefinitions(api, model.Auth{}, model.AllCustomLogic{}))
+}
@@ -22,6 +22,11 @@ files.forEach(file => {
     res.setHeader("Content-Type", "application/json");
     res.end(JSON.stringify(output));
   });
+  app.post("/batch/" + fileNoExt, (req, res) => {
+    const output = req.body.map(input => customLogic(input));
+    res.setHeader("Content-Type", "application/json");
+    res.end(JSON.stringify(output));
+  });
 });
 
 app.get("/ping", (req, res) => res.end("pong"));
@@ -9,18 +9,29 @@ app = Flask(__name__)
 files = list(filter(lambda file: file.endswith(".py"), os.listdir("./customLogic")))
 print("found files %s" % files)
 
-def getHandler(fileNoExt):
+def getCustomLogic(fileNoExt):
     module = importlib.import_module("." + fileNoExt, package="customLogic")
     attrs = map(lambda v: getattr(module, v), filter(lambda v: not v.startswith("__"), vars(module)))
-    customLogic = next(filter(lambda attr: callable(attr), attrs))
+    return next(filter(lambda attr: callable(attr), attrs))
+
+def getHandler(fileNoExt):
+    customLogic = getCustomLogic(fileNoExt)
     def handler():
         output = customLogic(request.get_json())
         return jsonify(output)
     return handler
 
+def getBatchHandler(fileNoExt):
+    customLogic = getCustomLogic(fileNoExt)
+    def batchHandler():
+        output = [customLogic(input) for input in request.get_json()]
+        return jsonify(output)
+    return batchHandler
+
 for file in files:
     fileNoExt = file[:-3]
     app.add_url_rule("/" + fileNoExt, fileNoExt, getHandler(fileNoExt), methods=["POST"])
+    app.add_url_rule("/batch/" + fileNoExt, "batch" + fileNoExt, getBatchHandler(fileNoExt), methods=["POST"])
 
 
 @app.route("/ping")
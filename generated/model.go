@@ -3,9 +3,16 @@
 package generated
 
 type Object struct {
+	// the table is chosen by the store, see store.PgStore.Table
+	tableName struct{} `sql:"?object_table"`
+
 	ID        string `json:"id" sql:"type:uuid,default:gen_random_uuid()"`
 	CreatedBy string `json:"createdBy"`
+	TenantID  string `json:"-"`
 	Test      string `json:"test"`
 	CreatedAt string `json:"createdAt" sql:"default:now()"`
-	// UpdatedAt string `json:"updatedAt"`
+	Version   int64  `json:"version" sql:",notnull,default:1"`
+	UpdatedAt string `json:"updatedAt" sql:"default:now()"`
+	UpdatedBy string `json:"updatedBy"`
+	DeletedAt string `json:"-" sql:"type:timestamptz"`
 }
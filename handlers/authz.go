@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+)
+
+// authorized returns whether the policy allows the user to perform the operation on the object. A nil policy allows
+// any authenticated user.
+func (h Handlers) authorized(u *user.User, policy *model.AuthPolicy, operation string, obj store.Record) bool {
+	if obj == nil {
+		return h.allowed(u, policy, operation, nil)
+	}
+	createdBy := obj.CreatedBy()
+	return h.allowed(u, policy, operation, &createdBy)
+}
+
+// allowed returns whether the policy allows the user to perform the operation on an object created by createdBy, which
+// is nil if there is no object. Policy types that are not implemented deny.
+func (h Handlers) allowed(u *user.User, policy *model.AuthPolicy, operation string, createdBy *string) bool {
+	if policy == nil {
+		return true
+	}
+
+	switch policy.Type {
+	case model.AuthPolicyTypeCreatedBy:
+		return createdBy != nil && u.ID == *createdBy
+	case model.AuthPolicyTypeRole:
+		if len(policy.Roles) > 0 {
+			return hasAnyRole(u, policy.Roles)
+		}
+		return h.roleAllows(u, operation)
+	case model.AuthPolicyTypeAnyOf:
+		for _, p := range policy.Policies {
+			if h.allowed(u, p, operation, createdBy) {
+				return true
+			}
+		}
+		return false
+	case model.AuthPolicyTypeAllOf:
+		for _, p := range policy.Policies {
+			if !h.allowed(u, p, operation, createdBy) {
+				return false
+			}
+		}
+		return true
+	}
+	// ATTRIBUTE_MATCH and CUSTOM policies are not enforced yet, so they allow no one rather than everyone
+	return false
+}
+
+// roleAllows returns whether any of the user's roles is allowed to invoke the operation.
+func (h Handlers) roleAllows(u *user.User, operation string) bool {
+	for _, role := range u.Roles {
+		for _, allowed := range h.Auth.Roles[role] {
+			if allowed == operation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasAnyRole(u *user.User, roles []string) bool {
+	for _, role := range u.Roles {
+		for _, r := range roles {
+			if role == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redact returns a copy of the object without the fields the user may not see. If no field policies are defined, the
+// object is returned as is.
+func (h Handlers) redact(u *user.User, obj store.Record) store.Record {
+	if len(h.Auth.Fields) == 0 || obj == nil {
+		return obj
+	}
+
+	redacted := store.Record{}
+	for field, value := range obj {
+		redacted[field] = value
+	}
+	for field, policy := range h.Auth.Fields {
+		if !h.authorized(u, policy, metrics.READ, obj) {
+			delete(redacted, field)
+		}
+	}
+	return redacted
+}
+
+// redactAll applies redact to each of the objects.
+func (h Handlers) redactAll(u *user.User, objs []store.Record) []store.Record {
+	var res []store.Record
+	for _, obj := range objs {
+		res = append(res, h.redact(u, obj))
+	}
+	return res
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/pkg/errors"
+)
+
+// bulkModePartial is the value of the mode query parameter that applies the items of a bulk request that succeed, even
+// if other items fail. By default a bulk request applies none of its items if any item fails.
+const bulkModePartial = "partial"
+
+// defaultMaxBulkItems is the most items a bulk request may hold if Handlers.MaxBulkItems is not set.
+const defaultMaxBulkItems = 1000
+
+// bulkResult is the outcome of a single item of a bulk request.
+type bulkResult struct {
+	Status  int         `json:"status"`
+	Object  interface{} `json:"object,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+type bulkResponse struct {
+	Results []bulkResult `json:"results"`
+}
+
+// BulkCreateHandler creates each of the objects in the request body.
+func (h Handlers) BulkCreateHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	u := h.authenticate(w, r, metrics.CREATE)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
+
+	objs, results, ok := h.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	for i, obj := range objs {
+		if obj != nil && !h.authorized(u, h.Auth.Create, metrics.CREATE, store.Record{"createdBy": u.ID}) {
+			results[i] = bulkResult{Status: http.StatusForbidden, Message: "unauthorized"}
+			objs[i] = nil
+		}
+	}
+	indexes, ok := pending(r, objs, results)
+	if !ok {
+		h.bulkResponse(w, results)
+		return
+	}
+
+	input, err := h.applyBeforeBulkCustomLogic(collect(objs, indexes), h.CustomLogic.Create, metrics.CREATE)
+	if err != nil {
+		panic(err)
+	}
+
+	// delegate to db
+	for _, obj := range input {
+		obj["createdBy"] = u.ID
+	}
+	var res []store.Record
+	if partial(r) {
+		indexes, res = h.writeEach(u, metrics.BULK_CREATE, results, indexes, func(j int) (store.Record, error) {
+			return s.CreateObject(input[j])
+		})
+	} else {
+		res, err = s.CreateObjects(input)
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(metrics.BULK_CREATE, u.TenantID).Inc()
+			panic(err)
+		}
+	}
+
+	output, err := h.applyAfterBulkCustomLogic(u, res, h.CustomLogic.Create, metrics.CREATE)
+	if err != nil {
+		panic(err)
+	}
+	for j, i := range indexes {
+		results[i] = bulkResult{Status: http.StatusCreated, Object: output[j]}
+	}
+	h.bulkResponse(w, results)
+}
+
+// BulkUpdateHandler applies an update action to each of the objects in the request body. The version of each object,
+// if set, is the version the object is expected to have.
+func (h Handlers) BulkUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	actionName := mux.Vars(r)["action"]
+	u := h.authenticate(w, r, actionName)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
+
+	objs, results, ok := h.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	h.checkBulkItems(s, u, objs, results, h.Auth.Update[actionName], actionName)
+	indexes, ok := pending(r, objs, results)
+	if !ok {
+		h.bulkResponse(w, results)
+		return
+	}
+
+	input, err := h.applyBeforeBulkCustomLogic(collect(objs, indexes), h.CustomLogic.Update[actionName], actionName)
+	if err != nil {
+		panic(err)
+	}
+
+	// delegate to db
+	for j, i := range indexes {
+		input[j]["id"] = objs[i].ID()
+		input[j]["version"] = objs[i].Version()
+	}
+	var res []store.Record
+	if partial(r) {
+		indexes, res = h.writeEach(u, metrics.BULK_UPDATE, results, indexes, func(j int) (store.Record, error) {
+			return s.UpdateObject(input[j], actionName, input[j].Version())
+		})
+	} else {
+		res, err = s.UpdateObjects(input, actionName)
+		if h.bulkConflict(w, err, results, indexes) {
+			return
+		}
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(metrics.BULK_UPDATE, u.TenantID).Inc()
+			panic(err)
+		}
+	}
+
+	output, err := h.applyAfterBulkCustomLogic(u, res, h.CustomLogic.Update[actionName], actionName)
+	if err != nil {
+		panic(err)
+	}
+	for j, i := range indexes {
+		results[i] = bulkResult{Status: http.StatusOK, Object: output[j]}
+	}
+	h.bulkResponse(w, results)
+}
+
+// BulkDeleteHandler deletes each of the objects identified in the request body. The version of each object, if set, is
+// the version the object is expected to have.
+func (h Handlers) BulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	u := h.authenticate(w, r, metrics.DELETE)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
+
+	objs, results, ok := h.decodeBulk(w, r)
+	if !ok {
+		return
+	}
+	existing := h.checkBulkItems(s, u, objs, results, h.Auth.Delete, metrics.DELETE)
+	indexes, ok := pending(r, objs, results)
+	if !ok {
+		h.bulkResponse(w, results)
+		return
+	}
+
+	deleted := collect(existing, indexes)
+	_, err := h.applyBeforeBulkCustomLogic(deleted, h.CustomLogic.Delete, metrics.DELETE)
+	if err != nil {
+		panic(err)
+	}
+
+	if partial(r) {
+		var written []int
+		written, _ = h.writeEach(u, metrics.BULK_DELETE, results, indexes, func(j int) (store.Record, error) {
+			obj := objs[indexes[j]]
+			return nil, s.DeleteObject(obj.ID(), obj.Version())
+		})
+		indexes = written
+		deleted = collect(existing, indexes)
+	} else {
+		err = s.DeleteObjects(collect(objs, indexes))
+		if h.bulkConflict(w, err, results, indexes) {
+			return
+		}
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(metrics.BULK_DELETE, u.TenantID).Inc()
+			panic(err)
+		}
+	}
+
+	output, err := h.applyAfterBulkCustomLogic(u, deleted, h.CustomLogic.Delete, metrics.DELETE)
+	if err != nil {
+		panic(err)
+	}
+	for j, i := range indexes {
+		if output == nil {
+			results[i] = bulkResult{Status: http.StatusNoContent}
+		} else {
+			results[i] = bulkResult{Status: http.StatusOK, Object: output[j]}
+		}
+	}
+	h.bulkResponse(w, results)
+}
+
+// decodeBulk reads the array of objects in the request body. Items that are not valid objects are nil, and are marked
+// as failed in the results. If the body is not an array, it writes an error response and returns false.
+func (h Handlers) decodeBulk(w http.ResponseWriter, r *http.Request) ([]store.Record, []bulkResult, bool) {
+	var items []json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&items)
+	if err != nil {
+		h.badRequestResponse(w, "request body must be an array of objects")
+		return nil, nil, false
+	}
+	if len(items) > h.maxBulkItems() {
+		h.badRequestResponse(w, fmt.Sprintf("a bulk request may hold at most %d items", h.maxBulkItems()))
+		return nil, nil, false
+	}
+
+	objs := make([]store.Record, len(items))
+	results := make([]bulkResult, len(items))
+	for i, item := range items {
+		obj, err := h.schema().Decode(bytes.NewReader(item))
+		if err != nil {
+			results[i] = bulkResult{Status: http.StatusBadRequest, Message: "invalid object: " + err.Error()}
+			continue
+		}
+		// the store ignores the id and version of decoded objects, but they identify the object to update or delete
+		var key struct {
+			ID      string `json:"id"`
+			Version int64  `json:"version"`
+		}
+		err = json.Unmarshal(item, &key)
+		if err != nil {
+			results[i] = bulkResult{Status: http.StatusBadRequest, Message: "invalid object: " + err.Error()}
+			continue
+		}
+		if key.ID != "" {
+			obj["id"] = key.ID
+		}
+		if key.Version != store.AnyVersion {
+			obj["version"] = key.Version
+		}
+		objs[i] = obj
+	}
+	return objs, results, true
+}
+
+// checkBulkItems fetches the object identified by each item, and enforces authz and the expected version. Items that
+// fail are set to nil and marked as failed in the results. It returns the fetched objects.
+func (h Handlers) checkBulkItems(s store.Store, u *user.User, objs []store.Record, results []bulkResult, policy *model.AuthPolicy, operation string) []store.Record {
+	existing := make([]store.Record, len(objs))
+	for i, obj := range objs {
+		if obj == nil {
+			continue
+		}
+		if obj.ID() == "" {
+			results[i] = bulkResult{Status: http.StatusBadRequest, Message: "missing id"}
+			objs[i] = nil
+			continue
+		}
+
+		res, err := s.GetObject(obj.ID())
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
+			panic(err)
+		}
+		if res == nil {
+			results[i] = bulkResult{Status: http.StatusNotFound, Message: "not found"}
+			objs[i] = nil
+		} else if !h.authorized(u, policy, operation, res) {
+			results[i] = bulkResult{Status: http.StatusForbidden, Message: "unauthorized"}
+			objs[i] = nil
+		} else if obj.Version() != store.AnyVersion && obj.Version() != res.Version() {
+			results[i] = bulkResult{Status: http.StatusPreconditionFailed, Message: "object has been modified"}
+			objs[i] = nil
+		}
+		existing[i] = res
+	}
+	return existing
+}
+
+// pending returns the indexes of the items that have not failed. It returns false if there is nothing to apply: either
+// no item is left, or an item failed and the request is not in partial mode. In the latter case, the remaining items
+// are marked as not applied.
+func pending(r *http.Request, objs []store.Record, results []bulkResult) ([]int, bool) {
+	var indexes []int
+	for i, obj := range objs {
+		if obj != nil {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, false
+	}
+	if len(indexes) < len(objs) && !partial(r) {
+		notApplied(results, indexes)
+		return nil, false
+	}
+	return indexes, true
+}
+
+// partial returns whether the request is in partial mode.
+func partial(r *http.Request) bool {
+	return r.URL.Query().Get("mode") == bulkModePartial
+}
+
+// writeEach writes each pending item with its own call to the store in partial mode, so that an item that fails to be
+// written does not prevent the others from being written. write is called with the position of the item among the
+// pending items. Each item that is not written is marked as failed in the results. It returns the indexes of the items
+// written and the objects write returned for them.
+func (h Handlers) writeEach(u *user.User, operation string, results []bulkResult, indexes []int, write func(j int) (store.Record, error)) ([]int, []store.Record) {
+	var written []int
+	var res []store.Record
+	for j, i := range indexes {
+		obj, err := write(j)
+		if errors.Is(err, store.ErrVersionConflict) {
+			results[i] = bulkResult{Status: http.StatusPreconditionFailed, Message: "object has been modified"}
+			continue
+		}
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(operation, u.TenantID).Inc()
+			log.Printf("failed to write item %d of %s: %v", i, operation, err)
+			results[i] = bulkResult{Status: http.StatusInternalServerError, Message: "failed to write object"}
+			continue
+		}
+		written = append(written, i)
+		res = append(res, obj)
+	}
+	return written, res
+}
+
+func (h Handlers) maxBulkItems() int {
+	if h.MaxBulkItems > 0 {
+		return h.MaxBulkItems
+	}
+	return defaultMaxBulkItems
+}
+
+func notApplied(results []bulkResult, indexes []int) {
+	for _, i := range indexes {
+		results[i] = bulkResult{Status: http.StatusFailedDependency, Message: "not applied"}
+	}
+}
+
+func collect(objs []store.Record, indexes []int) []store.Record {
+	res := make([]store.Record, len(indexes))
+	for j, i := range indexes {
+		res[j] = objs[i]
+	}
+	return res
+}
+
+// bulkConflict writes the results of a bulk write that was rejected because an object was modified concurrently. It
+// returns false if the error is not a version conflict.
+func (h Handlers) bulkConflict(w http.ResponseWriter, err error, results []bulkResult, indexes []int) bool {
+	var itemErr *store.ItemError
+	if !errors.As(err, &itemErr) || !errors.Is(itemErr, store.ErrVersionConflict) {
+		return false
+	}
+	notApplied(results, indexes)
+	results[indexes[itemErr.Index]] = bulkResult{Status: http.StatusPreconditionFailed, Message: "object has been modified"}
+	h.bulkResponse(w, results)
+	return true
+}
+
+// bulkResponse writes the results of a bulk request, with status 207 if any item did not succeed.
+func (h Handlers) bulkResponse(w http.ResponseWriter, results []bulkResult) {
+	for _, res := range results {
+		if res.Status >= http.StatusMultipleChoices {
+			w.WriteHeader(http.StatusMultiStatus)
+			break
+		}
+	}
+	json.NewEncoder(w).Encode(&bulkResponse{Results: results})
+}
+
+// applyBeforeBulkCustomLogic passes the objects through the before custom logic with a single request, if it is
+// defined.
+func (h Handlers) applyBeforeBulkCustomLogic(objs []store.Record, customLogic *model.CustomLogic, operation string) ([]store.Record, error) {
+	if customLogic == nil || customLogic.Before == nil {
+		return objs, nil
+	}
+
+	inputBytes, err := json.Marshal(objs)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal custom logic input")
+	}
+	res, err := h.CustomLogicExecutor.ExecuteBatch(bytes.NewReader(inputBytes), "before", operation)
+	if err != nil {
+		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
+	}
+
+	var bodies []json.RawMessage
+	err = json.NewDecoder(res.Body).Decode(&bodies)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read custom logic response body")
+	}
+	if len(bodies) != len(objs) {
+		return nil, errors.Errorf("custom logic returned %d objects for %d inputs", len(bodies), len(objs))
+	}
+	output := make([]store.Record, len(bodies))
+	for i, body := range bodies {
+		output[i], err = h.schema().Decode(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Errorf("invalid custom logic response: %v", err)
+		}
+	}
+	return output, nil
+}
+
+// applyAfterBulkCustomLogic returns the response for each of the objects, passing them through the after custom logic
+// with a single request if it is defined. Fields the user may not see are removed first. Without after custom logic,
+// deletes have no response and nil is returned.
+func (h Handlers) applyAfterBulkCustomLogic(u *user.User, objs []store.Record, customLogic *model.CustomLogic, operation string) ([]interface{}, error) {
+	input := make([]interface{}, len(objs))
+	for i, obj := range objs {
+		input[i] = h.redact(u, obj)
+	}
+
+	if customLogic == nil || customLogic.After == nil || len(objs) == 0 {
+		if operation == metrics.DELETE {
+			return nil, nil
+		}
+		return input, nil
+	}
+
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal custom logic input")
+	}
+	res, err := h.CustomLogicExecutor.ExecuteBatch(bytes.NewReader(inputBytes), "after", operation)
+	if err != nil {
+		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
+	}
+
+	var bodies []json.RawMessage
+	err = json.NewDecoder(res.Body).Decode(&bodies)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read custom logic response body")
+	}
+	if len(bodies) != len(objs) {
+		return nil, errors.Errorf("custom logic returned %d objects for %d inputs", len(bodies), len(objs))
+	}
+	output := make([]interface{}, len(bodies))
+	for i, body := range bodies {
+		output[i] = body
+	}
+	return output, nil
+}
@@ -0,0 +1,221 @@
+//go:build test
+// +build test
+
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *HandlersTestSuite) TestBulkCreate() {
+	storeInput := []store.Record{
+		store.Record{"test": "a", "createdBy": "userID"},
+		store.Record{"test": "b", "createdBy": "userID"},
+	}
+	storeOutput := []store.Record{
+		store.Record{"id": "1", "test": "a", "createdBy": "userID"},
+		store.Record{"id": "2", "test": "b", "createdBy": "userID"},
+	}
+	suite.executor.EXPECT().ExecuteBatch(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	suite.store.EXPECT().CreateObjects(storeInput).Return(storeOutput, nil)
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("", `[{"test": "a"}, {"test": "b"}]`))
+
+	assert.Equal(suite.T(), http.StatusOK, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusCreated, res.Results[0].Status)
+	assert.Equal(suite.T(), map[string]interface{}{"id": "1", "test": "a"}, pick(res.Results[0].Object, "id", "test"))
+	assert.Equal(suite.T(), http.StatusCreated, res.Results[1].Status)
+}
+
+func (suite *HandlersTestSuite) TestBulkCreateInvalidItem() {
+	suite.store.EXPECT().CreateObjects(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("", `[{"test": "a"}, 5]`))
+
+	assert.Equal(suite.T(), http.StatusMultiStatus, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusFailedDependency, res.Results[0].Status)
+	assert.Equal(suite.T(), http.StatusBadRequest, res.Results[1].Status)
+}
+
+func (suite *HandlersTestSuite) TestBulkCreatePartial() {
+	// each item is written on its own, so that an item failing to be written does not prevent the others
+	suite.store.EXPECT().CreateObjects(gomock.Any()).Times(0)
+	suite.store.EXPECT().CreateObject(store.Record{"test": "a", "createdBy": "userID"}).
+		Return(store.Record{"id": "1", "test": "a", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().CreateObject(store.Record{"test": "b", "createdBy": "userID"}).
+		Return(nil, errors.New("duplicate key"))
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("mode=partial", `[{"test": "a"}, 5, {"test": "b"}]`))
+
+	assert.Equal(suite.T(), http.StatusMultiStatus, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusCreated, res.Results[0].Status)
+	assert.Equal(suite.T(), map[string]interface{}{"id": "1", "test": "a"}, pick(res.Results[0].Object, "id", "test"))
+	assert.Equal(suite.T(), http.StatusBadRequest, res.Results[1].Status)
+	assert.Equal(suite.T(), bulkResult{Status: http.StatusInternalServerError, Message: "failed to write object"},
+		res.Results[2])
+}
+
+func (suite *HandlersTestSuite) TestBulkCreateTooManyItems() {
+	h.MaxBulkItems = 2
+	suite.store.EXPECT().CreateObjects(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("", `[{"test": "a"}, {"test": "b"}, {"test": "c"}]`))
+
+	assert.Equal(suite.T(), http.StatusBadRequest, rr.Result().StatusCode)
+	assert.Contains(suite.T(), rr.Body.String(), "a bulk request may hold at most 2 items")
+}
+
+func (suite *HandlersTestSuite) TestBulkCreateNotArray() {
+	suite.store.EXPECT().CreateObjects(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("", `{"test": "a"}`))
+
+	assert.Equal(suite.T(), http.StatusBadRequest, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestBulkCreateCustomLogic() {
+	customLogic := "something"
+	h.CustomLogic = model.AllCustomLogic{Create: &model.CustomLogic{Before: &customLogic}}
+
+	storeInput := []store.Record{
+		store.Record{"test": "A", "createdBy": "userID"},
+		store.Record{"test": "B", "createdBy": "userID"},
+	}
+	suite.executor.EXPECT().ExecuteBatch(gomock.Any(), "before", metrics.CREATE).
+		Times(1).
+		Return(&http.Response{Body: ioutil.NopCloser(strings.NewReader(`[{"test": "A"}, {"test": "B"}]`))}, nil)
+	suite.store.EXPECT().CreateObjects(storeInput).Return(storeInput, nil)
+
+	rr := httptest.NewRecorder()
+	h.BulkCreateHandler(rr, suite.bulkRequest("", `[{"test": "a"}, {"test": "b"}]`))
+
+	assert.Equal(suite.T(), http.StatusOK, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestBulkUpdate() {
+	suite.store.EXPECT().GetObject("1").Return(store.Record{"id": "1", "createdBy": "userID", "version": int64(2)}, nil)
+	suite.store.EXPECT().GetObject("2").Return(store.Record{"id": "2", "createdBy": "anotherUserID"}, nil)
+	suite.store.EXPECT().GetObject("3").Return(nil, nil)
+	suite.store.EXPECT().GetObject("4").Return(store.Record{"id": "4", "createdBy": "userID", "version": int64(3)}, nil)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "1", "test": "a", "version": int64(2)}, "action", int64(2)).
+		Return(store.Record{"id": "1", "test": "a", "version": int64(3)}, nil)
+
+	rr := httptest.NewRecorder()
+	body := `[{"id": "1", "test": "a", "version": 2}, {"id": "2"}, {"id": "3"}, {"id": "4", "version": 2}, {}]`
+	h.BulkUpdateHandler(rr, mux.SetURLVars(suite.bulkRequest("mode=partial", body), map[string]string{"action": "action"}))
+
+	res := suite.decodeBulk(rr.Body)
+	var statuses []int
+	for _, r := range res.Results {
+		statuses = append(statuses, r.Status)
+	}
+	assert.Equal(suite.T(), []int{
+		http.StatusOK,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusPreconditionFailed,
+		http.StatusBadRequest,
+	}, statuses)
+}
+
+func (suite *HandlersTestSuite) TestBulkUpdatePartialVersionConflict() {
+	suite.store.EXPECT().GetObject("1").Return(store.Record{"id": "1", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().GetObject("2").Return(store.Record{"id": "2", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "1", "version": int64(0)}, "action", store.AnyVersion).
+		Return(nil, store.ErrVersionConflict)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "2", "version": int64(0)}, "action", store.AnyVersion).
+		Return(store.Record{"id": "2", "version": int64(2)}, nil)
+
+	rr := httptest.NewRecorder()
+	body := `[{"id": "1"}, {"id": "2"}]`
+	h.BulkUpdateHandler(rr, mux.SetURLVars(suite.bulkRequest("mode=partial", body), map[string]string{"action": "action"}))
+
+	assert.Equal(suite.T(), http.StatusMultiStatus, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, res.Results[0].Status)
+	assert.Equal(suite.T(), http.StatusOK, res.Results[1].Status)
+}
+
+func (suite *HandlersTestSuite) TestBulkUpdateVersionConflict() {
+	suite.store.EXPECT().GetObject("1").Return(store.Record{"id": "1", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().GetObject("2").Return(store.Record{"id": "2", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().UpdateObjects(gomock.Any(), "action").
+		Return(nil, &store.ItemError{Index: 1, Err: store.ErrVersionConflict})
+
+	rr := httptest.NewRecorder()
+	body := `[{"id": "1"}, {"id": "2"}]`
+	h.BulkUpdateHandler(rr, mux.SetURLVars(suite.bulkRequest("", body), map[string]string{"action": "action"}))
+
+	assert.Equal(suite.T(), http.StatusMultiStatus, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusFailedDependency, res.Results[0].Status)
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, res.Results[1].Status)
+}
+
+func (suite *HandlersTestSuite) TestBulkDelete() {
+	suite.store.EXPECT().GetObject("1").Return(store.Record{"id": "1", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().DeleteObjects([]store.Record{store.Record{"id": "1"}}).Return(nil)
+
+	rr := httptest.NewRecorder()
+	h.BulkDeleteHandler(rr, suite.bulkRequest("", `[{"id": "1"}]`))
+
+	assert.Equal(suite.T(), http.StatusOK, rr.Result().StatusCode)
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), []bulkResult{bulkResult{Status: http.StatusNoContent}}, res.Results)
+}
+
+func (suite *HandlersTestSuite) TestBulkDeleteUnauthorized() {
+	suite.store.EXPECT().GetObject("1").Return(store.Record{"id": "1", "createdBy": "userID"}, nil)
+	suite.store.EXPECT().GetObject("2").Return(store.Record{"id": "2", "createdBy": "anotherUserID"}, nil)
+	suite.store.EXPECT().DeleteObjects(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.BulkDeleteHandler(rr, suite.bulkRequest("", `[{"id": "1"}, {"id": "2"}]`))
+
+	res := suite.decodeBulk(rr.Body)
+	assert.Equal(suite.T(), http.StatusFailedDependency, res.Results[0].Status)
+	assert.Equal(suite.T(), http.StatusForbidden, res.Results[1].Status)
+}
+
+func (suite *HandlersTestSuite) bulkRequest(query string, body string) *http.Request {
+	req, err := http.NewRequest("POST", "/?"+query, strings.NewReader(body))
+	assert.NoError(suite.T(), err)
+	return req
+}
+
+func (suite *HandlersTestSuite) decodeBulk(body io.Reader) bulkResponse {
+	var res bulkResponse
+	err := json.NewDecoder(body).Decode(&res)
+	assert.NoError(suite.T(), err)
+	return res
+}
+
+// pick returns the given fields of a decoded JSON object.
+func pick(obj interface{}, fields ...string) map[string]interface{} {
+	res := map[string]interface{}{}
+	for _, f := range fields {
+		res[f] = obj.(map[string]interface{})[f]
+	}
+	return res
+}
@@ -13,20 +13,37 @@ import (
 
 type CustomLogicExecutor interface {
 	Execute(reader io.Reader, when string, operation string) (*http.Response, error)
+	// ExecuteBatch applies the custom logic to each element of a JSON array with a single request, and responds with
+	// the array of results.
+	ExecuteBatch(reader io.Reader, when string, operation string) (*http.Response, error)
 }
 
 type RemoteCustomLogicExecutor struct {
 	URL string
+	// Metrics records the custom logic requests. If nil, metrics.Default is used.
+	Metrics *metrics.Metrics
 }
 
 func (c RemoteCustomLogicExecutor) Execute(reader io.Reader, when string, operation string) (*http.Response, error) {
 	start := time.Now()
 	res, err := http.Post(c.URL+when+operation, "application/json", reader)
 	if err != nil {
-		metrics.CustomLogicErrors.WithLabelValues(operation, when).Inc()
+		metrics.Or(c.Metrics).CustomLogicErrors.WithLabelValues(operation, when).Inc()
 		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
 	}
 	end := time.Now()
-	metrics.CustomLogicSummary.WithLabelValues(operation, when).Observe(end.Sub(start).Seconds())
+	metrics.Or(c.Metrics).CustomLogicSummary.WithLabelValues(operation, when).Observe(end.Sub(start).Seconds())
+	return res, nil
+}
+
+func (c RemoteCustomLogicExecutor) ExecuteBatch(reader io.Reader, when string, operation string) (*http.Response, error) {
+	start := time.Now()
+	res, err := http.Post(c.URL+"batch/"+when+operation, "application/json", reader)
+	if err != nil {
+		metrics.Or(c.Metrics).CustomLogicErrors.WithLabelValues(operation, when).Inc()
+		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
+	}
+	end := time.Now()
+	metrics.Or(c.Metrics).CustomLogicSummary.WithLabelValues(operation, when).Observe(end.Sub(start).Seconds())
 	return res, nil
 }
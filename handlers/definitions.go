@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gracew/widget-proxy/config"
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+)
+
+// Definitions are the definitions that can be reloaded while the server is running.
+type Definitions struct {
+	Auth        model.Auth
+	CustomLogic model.AllCustomLogic
+}
+
+// LiveDefinitions holds the current Definitions. It is safe for concurrent use.
+type LiveDefinitions struct {
+	v atomic.Value
+}
+
+// NewLiveDefinitions returns LiveDefinitions holding the given definitions.
+func NewLiveDefinitions(d Definitions) *LiveDefinitions {
+	l := &LiveDefinitions{}
+	l.Store(d)
+	return l
+}
+
+// Load returns the current definitions.
+func (l *LiveDefinitions) Load() Definitions {
+	return l.v.Load().(Definitions)
+}
+
+// Store replaces the current definitions. Requests in flight keep the definitions they started with.
+func (l *LiveDefinitions) Store(d Definitions) {
+	l.v.Store(d)
+}
+
+// current returns the handlers with the current definitions, if they are live. A request uses the same definitions
+// throughout, even if they are replaced while it is in flight.
+func (h Handlers) current() Handlers {
+	if h.Definitions != nil {
+		d := h.Definitions.Load()
+		h.Auth = d.Auth
+		h.CustomLogic = d.CustomLogic
+	}
+	return h
+}
+
+// Reloader re-reads the auth and custom logic definitions, and replaces the live definitions if the new ones are valid.
+type Reloader struct {
+	// API is the API definition the reloaded definitions are validated against. It is not reloaded.
+	API             model.API
+	AuthPath        string
+	CustomLogicPath string
+	Definitions     *LiveDefinitions
+	// Metrics counts failed reloads. If nil, metrics.Default is used.
+	Metrics *metrics.Metrics
+}
+
+// Reload re-reads and validates the definitions. If they cannot be read or are invalid, the live definitions are kept,
+// the reload error metric is incremented and the error is returned.
+func (r Reloader) Reload() error {
+	err := r.reload()
+	if err != nil {
+		metrics.Or(r.Metrics).DefinitionReloadErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+func (r Reloader) reload() error {
+	auth, err := config.Auth(r.AuthPath)
+	if err != nil {
+		return err
+	}
+	customLogic, err := config.CustomLogic(r.CustomLogicPath)
+	if err != nil {
+		return err
+	}
+	err = config.ValidateDefinitions(r.API, *auth, *customLogic)
+	if err != nil {
+		return err
+	}
+	r.Definitions.Store(Definitions{Auth: *auth, CustomLogic: *customLogic})
+	return nil
+}
+
+// Watch reloads the definitions whenever a signal is received, and whenever the content of the files changes, which
+// is checked at the given interval. An interval of 0 disables checking the files. Watch returns when done is closed.
+func (r Reloader) Watch(interval time.Duration, signals <-chan os.Signal, done <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	last := r.fingerprint()
+	reload := func(reason string) {
+		err := r.Reload()
+		if err != nil {
+			log.Printf("failed to reload definitions after %s, keeping the previous definitions: %v", reason, err)
+			return
+		}
+		log.Printf("reloaded definitions after %s", reason)
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-signals:
+			last = r.fingerprint()
+			reload(sig.String())
+		case <-tick:
+			// files that cannot be read are reported once by the reload that follows their change
+			if f := r.fingerprint(); f != last {
+				last = f
+				reload("file change")
+			}
+		}
+	}
+}
+
+// fingerprint returns a hash of the content of the definition files, or a zero hash if either cannot be read.
+func (r Reloader) fingerprint() [sha256.Size]byte {
+	hash := sha256.New()
+	for _, path := range []string{r.AuthPath, r.CustomLogicPath} {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return [sha256.Size]byte{}
+		}
+		hash.Write(bytes)
+		// separate the files, so that moving content from one to the other is a change
+		hash.Write([]byte{0})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrentDefinitions(t *testing.T) {
+	h := Handlers{Auth: model.Auth{APIID: "static"}}
+	assert.Equal(t, "static", h.current().Auth.APIID)
+
+	h.Definitions = NewLiveDefinitions(Definitions{Auth: model.Auth{APIID: "live"}})
+	assert.Equal(t, "live", h.current().Auth.APIID)
+	h.Definitions.Store(Definitions{Auth: model.Auth{APIID: "reloaded"}})
+	assert.Equal(t, "reloaded", h.current().Auth.APIID)
+}
+
+func TestReload(t *testing.T) {
+	r := newReloader(t)
+	write(t, r.AuthPath, `{"delete": {"type": "CREATED_BY"}}`)
+	assert.NoError(t, r.Reload())
+	assert.Equal(t, model.AuthPolicyTypeCreatedBy, r.Definitions.Load().Auth.Delete.Type)
+
+	errors := testutil.ToFloat64(metrics.Default.DefinitionReloadErrors)
+	write(t, r.AuthPath, `{"delete": {"type": "OWNER"}}`)
+	assert.Error(t, r.Reload())
+	assert.Equal(t, model.AuthPolicyTypeCreatedBy, r.Definitions.Load().Auth.Delete.Type)
+	assert.Equal(t, errors+1, testutil.ToFloat64(metrics.Default.DefinitionReloadErrors))
+
+	write(t, r.CustomLogicPath, `not json`)
+	assert.Error(t, r.Reload())
+	assert.Equal(t, errors+2, testutil.ToFloat64(metrics.Default.DefinitionReloadErrors))
+}
+
+func TestWatch(t *testing.T) {
+	r := newReloader(t)
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	defer close(done)
+	go r.Watch(10*time.Millisecond, signals, done)
+
+	// the signal forces a reload even though the files are unchanged
+	r.Definitions.Store(Definitions{Auth: model.Auth{APIID: "stale"}})
+	signals <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return r.Definitions.Load().Auth.APIID == "" }, time.Second, 10*time.Millisecond)
+
+	write(t, r.AuthPath, `{"apiID": "changed"}`)
+	assert.Eventually(t, func() bool { return r.Definitions.Load().Auth.APIID == "changed" }, time.Second, 10*time.Millisecond)
+}
+
+func newReloader(t *testing.T) Reloader {
+	dir, err := ioutil.TempDir(os.TempDir(), "definitions-")
+	assert.NoError(t, err)
+	r := Reloader{
+		AuthPath:        filepath.Join(dir, "auth.json"),
+		CustomLogicPath: filepath.Join(dir, "customLogic.json"),
+		Definitions:     NewLiveDefinitions(Definitions{}),
+	}
+	write(t, r.AuthPath, `{}`)
+	write(t, r.CustomLogicPath, `{}`)
+	return r
+}
+
+func write(t *testing.T, path string, content string) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
@@ -0,0 +1,740 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/openapi"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/pkg/errors"
+)
+
+// defaultGraphQLPageSize is the number of objects listed by the objects query if first is not given, as for REST lists.
+const defaultGraphQLPageSize = 100
+
+// maxGraphQLPageSize is the largest number of objects the objects query lists.
+const maxGraphQLPageSize = 1000
+
+var (
+	errGraphQLUnauthorized = errors.New("unauthorized")
+	errGraphQLNotFound     = errors.New("not found")
+	errGraphQLModified     = errors.New("object has been modified")
+	errGraphQLInternal     = errors.New("internal error")
+)
+
+// graphQLNamePattern matches the names GraphQL allows.
+var graphQLNamePattern = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+
+// graphQLFieldTypes are the GraphQL types of the field types. Timestamps are RFC 3339 strings.
+var graphQLFieldTypes = map[model.FieldType]*graphql.Scalar{
+	model.FieldTypeString:    graphql.String,
+	model.FieldTypeInt:       graphql.Int,
+	model.FieldTypeFloat:     graphql.Float,
+	model.FieldTypeBoolean:   graphql.Boolean,
+	model.FieldTypeTimestamp: graphql.String,
+}
+
+// graphQLLong is a 64-bit integer, as versions are. graphql.Int is limited to 32 bits, as the GraphQL specification
+// requires. Values beyond the precision of a JSON number may be given as strings.
+var graphQLLong = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Long",
+	Description: "A 64-bit integer.",
+	Serialize: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case int:
+			return int64(v)
+		case int64:
+			return v
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v)
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+		}
+		return nil
+	},
+	ParseLiteral: func(value ast.Value) interface{} {
+		switch v := value.(type) {
+		case *ast.IntValue:
+			if i, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+				return i
+			}
+		case *ast.StringValue:
+			if i, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+				return i
+			}
+		}
+		return nil
+	},
+})
+
+// graphQLCall is the state of a GraphQL request shared by its resolvers.
+type graphQLCall struct {
+	h Handlers
+	r *http.Request
+	u *user.User
+	// err is the first error of the store or custom logic, which fails the request rather than a field
+	err error
+}
+
+type graphQLCallKey struct{}
+
+func graphQLCallFrom(ctx context.Context) *graphQLCall {
+	return ctx.Value(graphQLCallKey{}).(*graphQLCall)
+}
+
+// fail records an error of the store or custom logic, to be raised once the request has executed, as graphql-go
+// recovers panics of resolvers as errors of their fields.
+func (c *graphQLCall) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+	}
+	return errGraphQLInternal
+}
+
+// graphQLRequest is the body of a GraphQL POST request, or the query parameters of a GET request.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL returns the handler of GraphQL requests for the API: queries and mutations in POST requests with a JSON
+// body, and queries in GET requests with the query, operationName and variables query parameters. Queries and
+// mutations use the same store, auth policies and custom logic as the REST handlers, with the operation named by each
+// field: object and objects are read and list, and create, delete, restore and the mutation of each update action are
+// the operation or action. It fails if the schema of the API cannot be built, for example because two actions have the
+// same GraphQL name.
+func (h Handlers) GraphQL(api model.API) (http.HandlerFunc, error) {
+	schema, err := graphQLSchema(api)
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := h.current()
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+		if r.Method == http.MethodOptions {
+			return
+		}
+
+		u, err := h.Authenticator.GetUser(r.Header)
+		if err != nil {
+			if errors.Is(err, user.ErrNoCredentials) || errors.Is(err, user.ErrInvalidCredentials) {
+				h.unauthenticatedResponse(w)
+				return
+			}
+			panic(err)
+		}
+		metrics.SetTenant(r.Context(), u.TenantID)
+
+		req, err := readGraphQLRequest(r)
+		if err != nil {
+			writeGraphQLResponse(w, http.StatusBadRequest, graphQLError(err))
+			return
+		}
+		if r.Method == http.MethodGet {
+			if op := graphQLOperation(req); op != "" && op != ast.OperationTypeQuery {
+				w.Header().Set("Allow", http.MethodPost)
+				writeGraphQLResponse(w, http.StatusMethodNotAllowed,
+					graphQLError(errors.Errorf("%s operations must be sent in POST requests", op)))
+				return
+			}
+		}
+
+		c := &graphQLCall{h: h, r: r, u: u}
+		res := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(r.Context(), graphQLCallKey{}, c),
+		})
+		if c.err != nil {
+			panic(c.err)
+		}
+		writeGraphQLResponse(w, http.StatusOK, res)
+	}, nil
+}
+
+// readGraphQLRequest reads a GraphQL request from the query parameters of a GET request or the body of a POST request.
+func readGraphQLRequest(r *http.Request) (graphQLRequest, error) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if err != nil {
+				return req, errors.Wrap(err, "invalid variables")
+			}
+		}
+	} else {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return req, errors.Wrap(err, "invalid request body")
+		}
+	}
+	if req.Query == "" {
+		return req, errors.New("the request has no query")
+	}
+	return req, nil
+}
+
+// graphQLOperation returns the type of the operation of the request, or "" if the query cannot be parsed or has no
+// such operation, in which case executing it fails.
+func graphQLOperation(req graphQLRequest) string {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return ""
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if req.OperationName == "" || op.Name != nil && op.Name.Value == req.OperationName {
+			return op.Operation
+		}
+	}
+	return ""
+}
+
+func graphQLError(err error) *graphql.Result {
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(err)}}
+}
+
+func writeGraphQLResponse(w http.ResponseWriter, status int, res *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// graphQLSchema builds the schema of the API: an Object type with the fields of the objects, the object and objects
+// queries, and the create, delete and restore mutations and a mutation per update action.
+func graphQLSchema(api model.API) (graphql.Schema, error) {
+	system := map[string]bool{}
+	for _, f := range model.SystemFields {
+		system[f.Name] = true
+	}
+	fieldTypes := map[string]graphql.Input{}
+	objectFields := graphql.Fields{}
+	inputFields := graphql.InputObjectConfigFieldMap{}
+	for _, f := range openapi.Fields(api) {
+		t := graphQLFieldTypes[f.Type]
+		if f.Name == "id" {
+			t = graphql.ID
+		}
+		if f.Name == "version" {
+			t = graphQLLong
+		}
+		fieldTypes[f.Name] = t
+		objectFields[f.Name] = &graphql.Field{Type: t}
+		if !system[f.Name] {
+			inputFields[f.Name] = &graphql.InputObjectFieldConfig{Type: t}
+		}
+	}
+	object := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Object",
+		Description: "An object of the API. Fields the user may not see are null.",
+		Fields:      objectFields,
+	})
+	nonNullObject := graphql.NewNonNull(object)
+	idArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}
+	versionArg := &graphql.ArgumentConfig{
+		Description: "The version the object is expected to have. If omitted, any version is changed.",
+		Type:        graphQLLong,
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"object": &graphql.Field{
+			Description: "The object with the id, or null if there is no such object.",
+			Type:        object,
+			Args:        graphql.FieldConfigArgument{"id": idArg},
+			Resolve:     resolveObject,
+		},
+		"objects": listField(api, object, fieldTypes),
+	}})
+
+	create := &graphql.Field{Type: nonNullObject, Resolve: resolveCreate}
+	if len(inputFields) > 0 {
+		input := graphql.NewInputObject(graphql.InputObjectConfig{Name: "ObjectInput", Fields: inputFields})
+		create.Args = graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)}}
+	}
+	mutations := graphql.Fields{
+		metrics.CREATE: create,
+		metrics.DELETE: &graphql.Field{
+			Description: "Deletes the object, and returns it as it was before deletion.",
+			Type:        nonNullObject,
+			Args:        graphql.FieldConfigArgument{"id": idArg, "version": versionArg},
+			Resolve:     resolveDelete,
+		},
+	}
+	ops := api.Operations
+	if ops != nil && ops.Delete != nil && ops.Delete.SoftDelete {
+		mutations[metrics.RESTORE] = &graphql.Field{
+			Type:    nonNullObject,
+			Args:    graphql.FieldConfigArgument{"id": idArg},
+			Resolve: resolveRestore,
+		}
+	}
+	if ops != nil && ops.Update != nil {
+		actions := map[string]string{}
+		for _, action := range ops.Update.Actions {
+			name := graphQLName(action.Name)
+			if !graphQLNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+				return graphql.Schema{}, errors.Errorf("action %q has no valid GraphQL name", action.Name)
+			}
+			if other, ok := actions[name]; ok {
+				return graphql.Schema{}, errors.Errorf("actions %q and %q have the same GraphQL name %s", other, action.Name, name)
+			}
+			if _, ok := mutations[name]; ok {
+				return graphql.Schema{}, errors.Errorf("action %q has the GraphQL name of the %s mutation", action.Name, name)
+			}
+			actions[name] = action.Name
+
+			actionFields := graphql.InputObjectConfigFieldMap{}
+			for _, f := range action.Fields {
+				// system fields are set by the store, even if the action lists them
+				if !system[f] {
+					actionFields[f] = &graphql.InputObjectFieldConfig{Type: fieldTypes[f]}
+				}
+			}
+			args := graphql.FieldConfigArgument{"id": idArg, "version": versionArg}
+			if len(actionFields) > 0 {
+				actionInput := graphql.NewInputObject(graphql.InputObjectConfig{
+					Name:   strings.ToUpper(name[:1]) + name[1:] + "ActionInput",
+					Fields: actionFields,
+				})
+				args["input"] = &graphql.ArgumentConfig{Type: graphql.NewNonNull(actionInput)}
+			}
+			mutations[name] = &graphql.Field{
+				Description: "Applies the " + action.Name + " action to the object, updating only the fields of the action.",
+				Type:        nonNullObject,
+				Args:        args,
+				Resolve:     resolveUpdate(action.Name),
+			}
+		}
+	}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations}),
+	})
+}
+
+// listField is the objects query, which lists a page of objects with an optional filter. Objects can only be listed in
+// their declared sort order, which is the only value the sort argument accepts.
+func listField(api model.API, object *graphql.Object, fieldTypes map[string]graphql.Input) *graphql.Field {
+	page := graphql.NewObject(graphql.ObjectConfig{Name: "ObjectPage", Fields: graphql.Fields{
+		"items": &graphql.Field{
+			Description: "The objects of the page the user may read.",
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(object))),
+		},
+		"endCursor": &graphql.Field{
+			Description: "The cursor to list the next page after, or null if this is the last page.",
+			Type:        graphql.String,
+		},
+		"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+	}})
+
+	sortFields := graphql.EnumValueConfigMap{}
+	for _, s := range listSort(api) {
+		sortFields[s.Field] = &graphql.EnumValueConfig{Value: s.Field}
+	}
+	sortInput := graphql.NewInputObject(graphql.InputObjectConfig{Name: "ObjectSort", Fields: graphql.InputObjectConfigFieldMap{
+		"field": &graphql.InputObjectFieldConfig{
+			Type: graphql.NewNonNull(graphql.NewEnum(graphql.EnumConfig{Name: "ObjectSortField", Values: sortFields})),
+		},
+		"order": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.NewEnum(graphql.EnumConfig{
+			Name: "SortOrder",
+			Values: graphql.EnumValueConfigMap{
+				string(model.SortOrderAsc):  &graphql.EnumValueConfig{Value: string(model.SortOrderAsc)},
+				string(model.SortOrderDesc): &graphql.EnumValueConfig{Value: string(model.SortOrderDesc)},
+			},
+		}))},
+	}})
+
+	field := &graphql.Field{
+		Description: "A page of the objects, in their declared sort order.",
+		Type:        graphql.NewNonNull(page),
+		Args: graphql.FieldConfigArgument{
+			"first": &graphql.ArgumentConfig{
+				Description:  fmt.Sprintf("The number of objects to list, at most %d.", maxGraphQLPageSize),
+				Type:         graphql.Int,
+				DefaultValue: defaultGraphQLPageSize,
+			},
+			"after": &graphql.ArgumentConfig{Type: graphql.String},
+			"sort": &graphql.ArgumentConfig{
+				Description: "The declared sort order. No other order is supported.",
+				Type:        graphql.NewList(graphql.NewNonNull(sortInput)),
+			},
+		},
+		Resolve: resolveList(api),
+	}
+	if api.Operations != nil && api.Operations.List != nil && len(api.Operations.List.Filter) > 0 {
+		filterFields := graphql.InputObjectConfigFieldMap{}
+		for _, f := range api.Operations.List.Filter {
+			filterFields[f] = &graphql.InputObjectFieldConfig{Type: fieldTypes[f]}
+		}
+		filter := graphql.NewInputObject(graphql.InputObjectConfig{
+			Name:        "ObjectFilter",
+			Description: "Lists the objects whose field equals the value. At most one field may be set.",
+			Fields:      filterFields,
+		})
+		field.Args["filter"] = &graphql.ArgumentConfig{Type: filter}
+	}
+	return field
+}
+
+// listSort returns the declared sort order of lists, or createdAt descending if none is declared.
+func listSort(api model.API) []model.SortDefinition {
+	if api.Operations == nil || api.Operations.List == nil || len(api.Operations.List.Sort) == 0 {
+		return []model.SortDefinition{model.SortDefinition{Field: "createdAt", Order: model.SortOrderDesc}}
+	}
+	return api.Operations.List.Sort
+}
+
+// graphQLName returns the name of the mutation of an update action: the action name, with each letter or digit
+// following a character that is not a letter or digit upper-cased in its place.
+func graphQLName(action string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range action {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			upper = b.Len() > 0
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (c *graphQLCall) store() store.Store {
+	return c.h.Store.WithCaller(caller(c.r, c.u))
+}
+
+// writeStore returns the store of a mutation, whose reads are strongly consistent.
+func (c *graphQLCall) writeStore() store.Store {
+	return c.h.Store.WithCaller(writer(c.r, c.u))
+}
+
+// output returns the fields of the object the user may see, passed through the after custom logic if it is defined.
+func (c *graphQLCall) output(obj store.Record, customLogic *model.CustomLogic, operation string) (map[string]interface{}, error) {
+	output, err := c.h.afterCustomLogicOutput(c.u, obj, customLogic, operation)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	err = decoder.Decode(&fields)
+	if err != nil {
+		return nil, errors.New("invalid custom logic response")
+	}
+	// the version keeps its 64 bits for graphQLLong, while the other scalars serialize float64s
+	for name, value := range fields {
+		if n, ok := value.(json.Number); ok && name != "version" {
+			fields[name], _ = n.Float64()
+		}
+	}
+	return fields, nil
+}
+
+// before passes the input of a mutation through the before custom logic if it is defined.
+func (c *graphQLCall) before(input interface{}, customLogic *model.CustomLogic, operation string) (store.Record, error) {
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	obj, err := c.h.applyBeforeCustomLogicTo(input, customLogic, operation)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+	return obj, nil
+}
+
+// expectedGraphQLVersion returns the version argument, or store.AnyVersion if it is not given.
+func expectedGraphQLVersion(args map[string]interface{}) int64 {
+	if version, ok := args["version"].(int64); ok {
+		return version
+	}
+	return store.AnyVersion
+}
+
+func resolveObject(p graphql.ResolveParams) (interface{}, error) {
+	c := graphQLCallFrom(p.Context)
+	if !c.u.CanInvoke(metrics.READ) {
+		return nil, errGraphQLUnauthorized
+	}
+	obj, err := c.store().GetObject(p.Args["id"].(string))
+	if err != nil {
+		c.h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	if !c.h.authorized(c.u, c.h.Auth.Read, metrics.READ, obj) {
+		return nil, errGraphQLUnauthorized
+	}
+	return c.output(obj, nil, metrics.READ)
+}
+
+func resolveList(api model.API) graphql.FieldResolveFn {
+	sort := listSort(api)
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		c := graphQLCallFrom(p.Context)
+		if !c.u.CanInvoke(metrics.LIST) {
+			return nil, errGraphQLUnauthorized
+		}
+
+		first := p.Args["first"].(int)
+		if first <= 0 || first > maxGraphQLPageSize {
+			return nil, errors.Errorf("first must be between 1 and %d", maxGraphQLPageSize)
+		}
+		var after store.Record
+		if cursor, ok := p.Args["after"].(string); ok {
+			var err error
+			after, err = store.DecodeCursor(api, c.h.schema(), cursor)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if sorts, ok := p.Args["sort"].([]interface{}); ok && !declaredSort(sort, sorts) {
+			return nil, errors.New("only the declared sort order is supported")
+		}
+		var filter *store.Filter
+		if fields, ok := p.Args["filter"].(map[string]interface{}); ok {
+			for field, value := range fields {
+				if value == nil {
+					continue
+				}
+				if filter != nil {
+					return nil, errors.New("at most one filter field may be set")
+				}
+				filter = &store.Filter{Field: field, Value: value}
+			}
+		}
+
+		// an object past the page tells whether there is a next page
+		res, err := c.store().ListObjects(first+1, filter, after)
+		if err != nil {
+			c.h.metrics().DatabaseErrors.WithLabelValues(metrics.LIST, c.u.TenantID).Inc()
+			return nil, c.fail(err)
+		}
+		hasNextPage := len(res) > first
+		if hasNextPage {
+			res = res[:first]
+		}
+		items := []interface{}{}
+		for _, obj := range res {
+			if !c.h.authorized(c.u, c.h.Auth.Read, metrics.READ, obj) {
+				continue
+			}
+			item, err := c.output(obj, nil, metrics.LIST)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		page := map[string]interface{}{"items": items, "hasNextPage": hasNextPage}
+		if hasNextPage {
+			// the cursor is the position of the last object listed, whether or not the user may read it, so that the
+			// next page starts after it even if objects are created or deleted in between
+			page["endCursor"] = store.EncodeCursor(api, res[len(res)-1])
+		}
+		return page, nil
+	}
+}
+
+// declaredSort returns whether the sort argument is the declared sort order of lists.
+func declaredSort(declared []model.SortDefinition, sorts []interface{}) bool {
+	if len(sorts) != len(declared) {
+		return false
+	}
+	for i, sort := range sorts {
+		fields := sort.(map[string]interface{})
+		if fields["field"] != declared[i].Field || fields["order"] != string(declared[i].Order) {
+			return false
+		}
+	}
+	return true
+}
+
+func resolveCreate(p graphql.ResolveParams) (interface{}, error) {
+	c := graphQLCallFrom(p.Context)
+	h := c.h
+	if !c.u.CanInvoke(metrics.CREATE) || !h.authorized(c.u, h.Auth.Create, metrics.CREATE, store.Record{"createdBy": c.u.ID}) {
+		return nil, errGraphQLUnauthorized
+	}
+
+	obj, err := c.before(p.Args["input"], h.CustomLogic.Create, metrics.CREATE)
+	if err != nil {
+		return nil, err
+	}
+	obj["createdBy"] = c.u.ID
+	res, err := c.writeStore().CreateObject(obj)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.CREATE, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	return c.output(res, h.CustomLogic.Create, metrics.CREATE)
+}
+
+func resolveUpdate(actionName string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		c := graphQLCallFrom(p.Context)
+		h := c.h
+		if !c.u.CanInvoke(actionName) {
+			return nil, errGraphQLUnauthorized
+		}
+		s := c.writeStore()
+
+		// fetch object first, and enforce authz
+		id := p.Args["id"].(string)
+		res, err := s.GetObject(id)
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+			return nil, c.fail(err)
+		}
+		if res == nil {
+			return nil, errGraphQLNotFound
+		}
+		if !h.authorized(c.u, h.Auth.Update[actionName], actionName, res) {
+			return nil, errGraphQLUnauthorized
+		}
+		version := expectedGraphQLVersion(p.Args)
+		if version != store.AnyVersion && version != res.Version() {
+			return nil, errGraphQLModified
+		}
+
+		obj, err := c.before(p.Args["input"], h.CustomLogic.Update[actionName], actionName)
+		if err != nil {
+			return nil, err
+		}
+		obj["id"] = id
+		res, err = s.UpdateObject(obj, actionName, version)
+		if errors.Is(err, store.ErrVersionConflict) {
+			return nil, errGraphQLModified
+		}
+		if err != nil {
+			h.metrics().DatabaseErrors.WithLabelValues(actionName, c.u.TenantID).Inc()
+			return nil, c.fail(err)
+		}
+		return c.output(res, h.CustomLogic.Update[actionName], actionName)
+	}
+}
+
+func resolveDelete(p graphql.ResolveParams) (interface{}, error) {
+	c := graphQLCallFrom(p.Context)
+	h := c.h
+	if !c.u.CanInvoke(metrics.DELETE) {
+		return nil, errGraphQLUnauthorized
+	}
+	s := c.writeStore()
+
+	// fetch object first, and enforce authz
+	id := p.Args["id"].(string)
+	obj, err := s.GetObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	if obj == nil {
+		return nil, errGraphQLNotFound
+	}
+	if !h.authorized(c.u, h.Auth.Delete, metrics.DELETE, obj) {
+		return nil, errGraphQLUnauthorized
+	}
+	version := expectedGraphQLVersion(p.Args)
+	if version != store.AnyVersion && version != obj.Version() {
+		return nil, errGraphQLModified
+	}
+
+	_, err = c.before(obj, h.CustomLogic.Delete, metrics.DELETE)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DeleteObject(id, version)
+	if errors.Is(err, store.ErrVersionConflict) {
+		return nil, errGraphQLModified
+	}
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.DELETE, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	return c.output(obj, h.CustomLogic.Delete, metrics.DELETE)
+}
+
+func resolveRestore(p graphql.ResolveParams) (interface{}, error) {
+	c := graphQLCallFrom(p.Context)
+	h := c.h
+	if !c.u.CanInvoke(metrics.RESTORE) {
+		return nil, errGraphQLUnauthorized
+	}
+	s := c.writeStore()
+
+	// fetch object first, and enforce authz
+	id := p.Args["id"].(string)
+	obj, err := s.GetDeletedObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	if obj == nil {
+		return nil, errGraphQLNotFound
+	}
+	policy := h.Auth.Restore
+	if policy == nil {
+		policy = h.Auth.Delete
+	}
+	if !h.authorized(c.u, policy, metrics.RESTORE, obj) {
+		return nil, errGraphQLUnauthorized
+	}
+
+	res, err := s.RestoreObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.RESTORE, c.u.TenantID).Inc()
+		return nil, c.fail(err)
+	}
+	if res == nil {
+		return nil, errGraphQLNotFound
+	}
+	return c.output(res, nil, metrics.RESTORE)
+}
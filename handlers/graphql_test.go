@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQL(t *testing.T) {
+	api := model.API{Operations: &model.OperationDefinition{
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename-test", Fields: []string{"test"}}},
+		},
+	}}
+	h := Handlers{
+		Store: store.NewMemoryStore(api, false),
+		Auth:  model.Auth{Delete: &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{
+			model.APIKey{Hash: user.HashAPIKey("owner"), Principal: "owner"},
+			model.APIKey{Hash: user.HashAPIKey("other"), Principal: "other"},
+		}},
+	}
+	handler, err := h.GraphQL(api)
+	assert.NoError(t, err)
+
+	serve := func(key string, query string, variables map[string]interface{}) map[string]interface{} {
+		body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+		assert.NoError(t, err)
+		req.Header.Set(user.APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res map[string]interface{}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		return res
+	}
+
+	res := serve("owner", `mutation { create(input: {test: "a"}) { id test createdBy version } }`, nil)
+	created := res["data"].(map[string]interface{})["create"].(map[string]interface{})
+	assert.Equal(t, "a", created["test"])
+	assert.Equal(t, "owner", created["createdBy"])
+	assert.Equal(t, float64(1), created["version"])
+	id := created["id"].(string)
+	serve("owner", `mutation { create(input: {test: "b"}) { id } }`, nil)
+
+	res = serve("other", `query($id: ID!) { object(id: $id) { test } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, map[string]interface{}{"object": map[string]interface{}{"test": "a"}}, res["data"])
+
+	res = serve("other", `{ objects(first: 1) { items { test } endCursor hasNextPage } }`, nil)
+	page := res["data"].(map[string]interface{})["objects"].(map[string]interface{})
+	assert.Equal(t, []interface{}{map[string]interface{}{"test": "b"}}, page["items"])
+	assert.Equal(t, true, page["hasNextPage"])
+	res = serve("other", `query($after: String) { objects(first: 1, after: $after) { items { test } hasNextPage } }`,
+		map[string]interface{}{"after": page["endCursor"]})
+	assert.Equal(t, map[string]interface{}{"objects": map[string]interface{}{
+		"items":       []interface{}{map[string]interface{}{"test": "a"}},
+		"hasNextPage": false,
+	}}, res["data"])
+
+	res = serve("other", `{ objects(sort: [{field: createdAt, order: ASC}]) { hasNextPage } }`, nil)
+	assert.Equal(t, "only the declared sort order is supported", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+
+	res = serve("other", `mutation($id: ID!) { renameTest(id: $id, input: {test: "c"}, version: 1) { test version } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, map[string]interface{}{"renameTest": map[string]interface{}{"test": "c", "version": float64(2)}}, res["data"])
+
+	res = serve("other", `mutation($id: ID!) { renameTest(id: $id, input: {test: "d"}, version: 1) { test } }`, map[string]interface{}{"id": id})
+	assert.Nil(t, res["data"])
+	assert.Equal(t, "object has been modified", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+
+	// versions are 64-bit
+	res = serve("other", `mutation($id: ID!) { renameTest(id: $id, input: {test: "d"}, version: 4294967296) { test } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, "object has been modified", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+
+	res = serve("other", `mutation($id: ID!) { delete(id: $id) { id } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, "unauthorized", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+
+	res = serve("owner", `mutation($id: ID!) { delete(id: $id) { test } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, map[string]interface{}{"delete": map[string]interface{}{"test": "c"}}, res["data"])
+
+	res = serve("owner", `query($id: ID!) { object(id: $id) { test } }`, map[string]interface{}{"id": id})
+	assert.Equal(t, map[string]interface{}{"object": nil}, res["data"])
+
+	req, err := http.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ objects { hasNextPage } }"}`))
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestGraphQLPagination(t *testing.T) {
+	api := model.API{
+		Fields: []model.FieldDefinition{
+			model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+			model.FieldDefinition{Name: "rank", Type: model.FieldTypeInt},
+		},
+		Operations: &model.OperationDefinition{List: &model.ListDefinition{
+			Sort: []model.SortDefinition{model.SortDefinition{Field: "rank", Order: model.SortOrderAsc}},
+		}},
+	}
+	s := store.NewMemoryStore(api, false)
+	h := Handlers{
+		API:           api,
+		Store:         s,
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{model.APIKey{Hash: user.HashAPIKey("key"), Principal: "user"}}},
+	}
+	handler, err := h.GraphQL(api)
+	assert.NoError(t, err)
+
+	serve := func(first int, after interface{}) map[string]interface{} {
+		body, err := json.Marshal(map[string]interface{}{
+			"query":     `query($first: Int, $after: String) { objects(first: $first, after: $after) { items { title } endCursor hasNextPage } }`,
+			"variables": map[string]interface{}{"first": first, "after": after},
+		})
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+		assert.NoError(t, err)
+		req.Header.Set(user.APIKeyHeader, "key")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		var res map[string]interface{}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		return res
+	}
+	create := func(title string, rank interface{}) {
+		_, err := s.CreateObject(store.Record{"title": title, "rank": rank})
+		assert.NoError(t, err)
+	}
+	create("a", int64(10))
+	// b and c are split between the first and second pages
+	create("b", int64(20))
+	create("c", int64(20))
+	create("d", int64(30))
+	create("none", nil)
+
+	var titles []string
+	var after interface{}
+	for i := 0; ; i++ {
+		page := serve(2, after)["data"].(map[string]interface{})["objects"].(map[string]interface{})
+		for _, item := range page["items"].([]interface{}) {
+			titles = append(titles, item.(map[string]interface{})["title"].(string))
+		}
+		if i == 0 {
+			// objects created before the cursor are not listed, while those after it are, without repeating or
+			// skipping the objects already listed
+			create("before", int64(0))
+			create("between", int64(25))
+			create("after", int64(40))
+		}
+		if page["hasNextPage"] == false {
+			assert.Nil(t, page["endCursor"])
+			break
+		}
+		after = page["endCursor"]
+	}
+	assert.Equal(t, "a", titles[0])
+	assert.ElementsMatch(t, []string{"a", "b", "c", "between", "d", "after", "none"}, titles)
+	assert.Equal(t, []string{"d", "after", "none"}, titles[4:])
+
+	for _, first := range []int{0, -1, maxGraphQLPageSize + 1} {
+		res := serve(first, nil)
+		assert.Nil(t, res["data"])
+		assert.Equal(t, "first must be between 1 and 1000", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+	}
+	res := serve(1, "invalid")
+	assert.Equal(t, "invalid cursor", res["errors"].([]interface{})[0].(map[string]interface{})["message"])
+}
+
+func TestGraphQLSchemaErrors(t *testing.T) {
+	api := model.API{Operations: &model.OperationDefinition{
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{
+				model.ActionDefinition{Name: "rename-test", Fields: []string{"test"}},
+				model.ActionDefinition{Name: "rename_test", Fields: []string{"test"}},
+				model.ActionDefinition{Name: "renameTest", Fields: []string{"test"}},
+			},
+		},
+	}}
+	_, err := Handlers{}.GraphQL(api)
+	assert.EqualError(t, err, `actions "rename-test" and "renameTest" have the same GraphQL name renameTest`)
+}
+
+func TestGraphQLName(t *testing.T) {
+	assert.Equal(t, "renameTest", graphQLName("rename-test"))
+	assert.Equal(t, "rename_test", graphQLName("rename_test"))
+	assert.Equal(t, "markAsRead", graphQLName("mark as read"))
+	assert.Equal(t, "publish", graphQLName("-publish"))
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	api := model.API{
+		Fields: []model.FieldDefinition{
+			model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+			model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+		},
+		Operations: &model.OperationDefinition{List: &model.ListDefinition{Filter: []string{"pageCount"}}},
+	}
+	h := Handlers{
+		API:           api,
+		Store:         store.NewMemoryStore(api, false),
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{model.APIKey{Hash: user.HashAPIKey("key"), Principal: "user"}}},
+	}
+	handler, err := h.GraphQL(api)
+	assert.NoError(t, err)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		req.Header.Set(user.APIKeyHeader, "key")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := serve(httptest.NewRequest("POST", "/graphql", strings.NewReader(
+		`{"query": "mutation($n: Int) { create(input: {title: \"a\", pageCount: $n}) { pageCount } }", "variables": {"n": 10}}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data": {"create": {"pageCount": 10}}}`, rr.Body.String())
+	serve(httptest.NewRequest("POST", "/graphql", strings.NewReader(
+		`{"query": "mutation { create(input: {title: \"b\", pageCount: 20}) { id } }"}`)))
+
+	query := url.Values{}
+	query.Set("query", `query($n: Int) { objects(filter: {pageCount: $n}) { items { title } } }`)
+	query.Set("variables", `{"n": 20}`)
+	rr = serve(httptest.NewRequest("GET", "/graphql?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data": {"objects": {"items": [{"title": "b"}]}}}`, rr.Body.String())
+
+	query = url.Values{}
+	query.Set("query", `mutation { create(input: {title: "c"}) { id } }`)
+	rr = serve(httptest.NewRequest("GET", "/graphql?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.Equal(t, http.MethodPost, rr.Header().Get("Allow"))
+
+	rr = serve(httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ objects { items { author } } }"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Nil(t, res["data"])
+	assert.Len(t, res["errors"], 1)
+
+	rr = serve(httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": 1}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
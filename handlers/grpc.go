@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/protoapi"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	errGRPCUnauthenticated = status.Error(codes.Unauthenticated, "unauthenticated")
+	errGRPCUnauthorized    = status.Error(codes.PermissionDenied, "unauthorized")
+	errGRPCNotFound        = status.Error(codes.NotFound, "not found")
+	errGRPCModified        = status.Error(codes.Aborted, "object has been modified")
+)
+
+// grpcServer serves the Objects service of an API.
+type grpcServer struct {
+	h Handlers
+	// object and listResponse are the messages of the service's responses
+	object       protoreflect.MessageDescriptor
+	listResponse protoreflect.MessageDescriptor
+}
+
+// grpcMethod handles the calls of a method of the Objects service, whose requests are messages of the method's input.
+type grpcMethod func(s grpcServer, ctx context.Context, req *dynamicpb.Message) (proto.Message, error)
+
+// GRPC returns the Objects service of the API described by file, which protoapi.New builds from the API definition.
+// Its methods use the same store, auth policies and custom logic as the REST handlers, and each update action has a
+// method of its own.
+func (h Handlers) GRPC(file protoreflect.FileDescriptor) *grpc.ServiceDesc {
+	s := grpcServer{h: h, object: file.Messages().ByName("Object"), listResponse: file.Messages().ByName("ListResponse")}
+	methods := map[string]grpcMethod{
+		protoapi.Create: grpcServer.create,
+		protoapi.Get:    grpcServer.get,
+		protoapi.List:   grpcServer.list,
+		protoapi.Delete: grpcServer.delete,
+	}
+	if ops := h.API.Operations; ops != nil && ops.Update != nil {
+		for _, action := range ops.Update.Actions {
+			methods[protoapi.MethodName(action.Name)] = updateMethod(action.Name)
+		}
+	}
+
+	service := file.Services().ByName(protoapi.ServiceName)
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(service.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    file.Path(),
+	}
+	for i := 0; i < service.Methods().Len(); i++ {
+		m := service.Methods().Get(i)
+		desc.Methods = append(desc.Methods, s.method(desc.ServiceName, m, methods[string(m.Name())]))
+	}
+	return desc
+}
+
+// updateMethod returns the method applying the update action.
+func updateMethod(actionName string) grpcMethod {
+	return func(s grpcServer, ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+		return s.update(ctx, actionName, req)
+	}
+}
+
+// method returns the description of a method whose calls fn handles, decoding their requests as the method's input.
+func (s grpcServer) method(service string, m protoreflect.MethodDescriptor, fn grpcMethod) grpc.MethodDesc {
+	info := &grpc.UnaryServerInfo{Server: s, FullMethod: "/" + service + "/" + string(m.Name())}
+	return grpc.MethodDesc{
+		MethodName: string(m.Name()),
+		Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := dynamicpb.NewMessage(m.Input())
+			err := dec(req)
+			if err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(s, ctx, req.(*dynamicpb.Message))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// grpcField returns the value of the field of the message with the given name.
+func grpcField(msg protoreflect.Message, name string) protoreflect.Value {
+	return msg.Get(msg.Descriptor().Fields().ByName(protoreflect.Name(name)))
+}
+
+// grpcCall is the state of a gRPC call shared by the methods of the Objects service.
+type grpcCall struct {
+	h      Handlers
+	u      *user.User
+	s      store.Store
+	object protoreflect.MessageDescriptor
+}
+
+// grpcHeader returns the metadata of the call as headers, as the REST handlers receive them.
+func grpcHeader(ctx context.Context) http.Header {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := http.Header{}
+	for k, values := range md {
+		for _, v := range values {
+			header.Add(k, v)
+		}
+	}
+	return header
+}
+
+// call authenticates the caller of a method invoking the operation from the metadata of the call, as the REST
+// handlers do from the request headers.
+func (s grpcServer) call(ctx context.Context, operation string) (*grpcCall, error) {
+	h := s.h.current()
+	md := grpcHeader(ctx)
+	u, err := h.Authenticator.GetUser(md)
+	if err != nil {
+		if errors.Is(err, user.ErrNoCredentials) || errors.Is(err, user.ErrInvalidCredentials) {
+			return nil, errGRPCUnauthenticated
+		}
+		panic(err)
+	}
+	metrics.SetTenant(ctx, u.TenantID)
+	if !u.CanInvoke(operation) {
+		return nil, errGRPCUnauthorized
+	}
+	consistency := store.Consistency(md.Get(consistencyParam))
+	if operation != metrics.READ && operation != metrics.LIST {
+		// the object is checked before it is written, as by the REST handlers
+		consistency = store.ConsistencyStrong
+	}
+	st := h.Store.WithCaller(store.Caller{
+		UserID:      u.ID,
+		TenantID:    u.TenantID,
+		RequestID:   md.Get(RequestIDHeader),
+		Consistency: consistency,
+	})
+	return &grpcCall{h: h, u: u, s: st, object: s.object}, nil
+}
+
+// GRPCRecover fails a call whose handler panics with the status Internal, logging the panic, so that the server keeps
+// serving other calls.
+func GRPCRecover(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic handling gRPC call %s: %v\n%s", info.FullMethod, p, debug.Stack())
+			res, err = nil, status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// output returns the object the user may see, passed through the after custom logic if it is defined. It fails if
+// the object has fields that the Object message does not.
+func (c *grpcCall) output(obj store.Record, customLogic *model.CustomLogic, operation string) (*dynamicpb.Message, error) {
+	output, err := c.h.afterCustomLogicOutput(c.u, obj, customLogic, operation)
+	if err != nil {
+		panic(err)
+	}
+	res := dynamicpb.NewMessage(c.object)
+	err = protojson.Unmarshal(output, res)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "object does not match the Object message: %v", err)
+	}
+	return res, nil
+}
+
+// before passes the fields of the message that are set through the before custom logic if it is defined. Fields with
+// the zero value are omitted, as they cannot be told apart from fields that are not set.
+func (c *grpcCall) before(msg protoreflect.Message, customLogic *model.CustomLogic, operation string) store.Record {
+	fields := map[string]interface{}{}
+	msg.Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields[f.JSONName()] = v.Interface()
+		return true
+	})
+	// these name the object and its expected version in update requests, and are maintained by the store otherwise
+	delete(fields, "id")
+	delete(fields, "version")
+	body, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+	res, err := c.h.applyBeforeCustomLogic(bytes.NewReader(body), customLogic, operation)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (s grpcServer) create(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+	c, err := s.call(ctx, metrics.CREATE)
+	if err != nil {
+		return nil, err
+	}
+	h := c.h
+	if !h.authorized(c.u, h.Auth.Create, metrics.CREATE, store.Record{"createdBy": c.u.ID}) {
+		return nil, errGRPCUnauthorized
+	}
+
+	obj := c.before(grpcField(req, "object").Message(), h.CustomLogic.Create, metrics.CREATE)
+	obj["createdBy"] = c.u.ID
+	res, err := c.s.CreateObject(obj)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.CREATE, c.u.TenantID).Inc()
+		panic(err)
+	}
+	return c.output(res, h.CustomLogic.Create, metrics.CREATE)
+}
+
+func (s grpcServer) get(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+	c, err := s.call(ctx, metrics.READ)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.s.GetObject(grpcField(req, "id").String())
+	if err != nil {
+		c.h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		panic(err)
+	}
+	if obj == nil {
+		return nil, errGRPCNotFound
+	}
+	if !c.h.authorized(c.u, c.h.Auth.Read, metrics.READ, obj) {
+		return nil, errGRPCUnauthorized
+	}
+	return c.output(obj, nil, metrics.READ)
+}
+
+func (s grpcServer) list(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+	c, err := s.call(ctx, metrics.LIST)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(grpcField(req, "page_size").Int())
+	if pageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	if pageSize == 0 {
+		pageSize = 100
+	}
+	var filter *store.Filter
+	var tooManyFilters bool
+	grpcField(req, "filter").Map().Range(func(field protoreflect.MapKey, value protoreflect.Value) bool {
+		tooManyFilters = filter != nil
+		filter = &store.Filter{Field: field.String(), Value: value.String()}
+		return !tooManyFilters
+	})
+	if tooManyFilters {
+		return nil, status.Error(codes.InvalidArgument, "at most one filter field may be set")
+	}
+
+	res, err := c.s.ListObjects(pageSize, filter, nil)
+	if err != nil {
+		c.h.metrics().DatabaseErrors.WithLabelValues(metrics.LIST, c.u.TenantID).Inc()
+		panic(err)
+	}
+	list := dynamicpb.NewMessage(s.listResponse)
+	objects := list.Mutable(s.listResponse.Fields().ByName("objects")).List()
+	for i := 0; i < len(res); i++ {
+		if !c.h.authorized(c.u, c.h.Auth.Read, metrics.READ, res[i]) {
+			continue
+		}
+		obj, err := c.output(res[i], nil, metrics.LIST)
+		if err != nil {
+			return nil, err
+		}
+		objects.Append(protoreflect.ValueOfMessage(obj))
+	}
+	return list, nil
+}
+
+// update applies the update action to the object named by the request, with the fields of the action that are set.
+func (s grpcServer) update(ctx context.Context, actionName string, req *dynamicpb.Message) (proto.Message, error) {
+	c, err := s.call(ctx, actionName)
+	if err != nil {
+		return nil, err
+	}
+	h := c.h
+	id := grpcField(req, "id").String()
+	version := grpcField(req, "version").Int()
+
+	// fetch object first, and enforce authz
+	res, err := c.s.GetObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		panic(err)
+	}
+	if res == nil {
+		return nil, errGRPCNotFound
+	}
+	if !h.authorized(c.u, h.Auth.Update[actionName], actionName, res) {
+		return nil, errGRPCUnauthorized
+	}
+	if version != store.AnyVersion && version != res.Version() {
+		return nil, errGRPCModified
+	}
+
+	obj := c.before(req, h.CustomLogic.Update[actionName], actionName)
+	obj["id"] = id
+	res, err = c.s.UpdateObject(obj, actionName, version)
+	if errors.Is(err, store.ErrVersionConflict) {
+		return nil, errGRPCModified
+	}
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(actionName, c.u.TenantID).Inc()
+		panic(err)
+	}
+	return c.output(res, h.CustomLogic.Update[actionName], actionName)
+}
+
+func (s grpcServer) delete(ctx context.Context, req *dynamicpb.Message) (proto.Message, error) {
+	c, err := s.call(ctx, metrics.DELETE)
+	if err != nil {
+		return nil, err
+	}
+	h := c.h
+	id := grpcField(req, "id").String()
+	version := grpcField(req, "version").Int()
+
+	// fetch object first, and enforce authz
+	obj, err := c.s.GetObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, c.u.TenantID).Inc()
+		panic(err)
+	}
+	if obj == nil {
+		return nil, errGRPCNotFound
+	}
+	if !h.authorized(c.u, h.Auth.Delete, metrics.DELETE, obj) {
+		return nil, errGRPCUnauthorized
+	}
+	if version != store.AnyVersion && version != obj.Version() {
+		return nil, errGRPCModified
+	}
+
+	_, err = h.applyBeforeCustomLogicTo(obj, h.CustomLogic.Delete, metrics.DELETE)
+	if err != nil {
+		panic(err)
+	}
+	err = c.s.DeleteObject(id, version)
+	if errors.Is(err, store.ErrVersionConflict) {
+		return nil, errGRPCModified
+	}
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.DELETE, c.u.TenantID).Inc()
+		panic(err)
+	}
+	return c.output(obj, h.CustomLogic.Delete, metrics.DELETE)
+}
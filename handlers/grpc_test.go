@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/protoapi"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcClient calls the methods of the Objects service of an API, with requests given as JSON.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	service protoreflect.ServiceDescriptor
+}
+
+// serveGRPC serves the Objects service of the API of h, and returns a client of the service.
+func serveGRPC(t *testing.T, h Handlers, name string) (grpcClient, func()) {
+	file, err := protoapi.New(h.API, name)
+	assert.NoError(t, err)
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(GRPCRequestID, GRPCRecover))
+	s.RegisterService(h.GRPC(file), nil)
+
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	client := grpcClient{conn: conn, service: file.Services().ByName(protoapi.ServiceName)}
+	return client, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func (c grpcClient) call(ctx context.Context, method string, req string, opts ...grpc.CallOption) (protoreflect.Message, error) {
+	m := c.service.Methods().ByName(protoreflect.Name(method))
+	in := dynamicpb.NewMessage(m.Input())
+	err := protojson.Unmarshal([]byte(req), in)
+	if err != nil {
+		panic(err)
+	}
+	out := dynamicpb.NewMessage(m.Output())
+	err = c.conn.Invoke(ctx, "/"+string(c.service.FullName())+"/"+method, in, out, opts...)
+	return out, err
+}
+
+func TestGRPC(t *testing.T) {
+	api := model.API{Operations: &model.OperationDefinition{
+		List: &model.ListDefinition{Filter: []string{"test"}},
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename-test", Fields: []string{"test"}}},
+		},
+	}}
+	h := Handlers{
+		API:   api,
+		Store: store.NewMemoryStore(api, false),
+		Auth:  model.Auth{Delete: &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{
+			model.APIKey{Hash: user.HashAPIKey("owner"), Principal: "owner"},
+			model.APIKey{Hash: user.HashAPIKey("other"), Principal: "other"},
+		}},
+	}
+	client, stop := serveGRPC(t, h, "")
+	defer stop()
+	owner := metadata.AppendToOutgoingContext(context.Background(), user.APIKeyHeader, "owner")
+	other := metadata.AppendToOutgoingContext(context.Background(), user.APIKeyHeader, "other")
+
+	_, err := client.call(context.Background(), "Get", `{"id": "1"}`)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	var header metadata.MD
+	created, err := client.call(owner, "Create", `{"object": {"test": "a"}}`, grpc.Header(&header))
+	assert.NoError(t, err)
+	id := grpcField(created, "id").String()
+	assert.Equal(t, "owner", grpcField(created, "created_by").String())
+	assert.Equal(t, int64(1), grpcField(created, "version").Int())
+	assert.Equal(t, "a", grpcField(created, "test").String())
+	assert.Len(t, header.Get(RequestIDHeader), 1)
+
+	obj, err := client.call(other, "Get", `{"id": "`+id+`"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", grpcField(obj, "test").String())
+
+	_, err = client.call(other, "Get", `{"id": "missing"}`)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.call(other, "List", `{"filter": {"test": "a"}}`)
+	assert.NoError(t, err)
+	objects := grpcField(list, "objects").List()
+	assert.Equal(t, 1, objects.Len())
+	assert.Equal(t, id, grpcField(objects.Get(0).Message(), "id").String())
+
+	_, err = client.call(other, "List", `{"filter": {"test": "a", "createdBy": "owner"}}`)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	update := `{"id": "` + id + `", "test": "b", "version": 1}`
+	updated, err := client.call(other, "RenameTest", update)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), grpcField(updated, "version").Int())
+	assert.Equal(t, "b", grpcField(updated, "test").String())
+
+	_, err = client.call(other, "RenameTest", update)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// only the declared actions have methods
+	get := client.service.Methods().ByName("Get")
+	err = client.conn.Invoke(other, "/widgetproxy.Objects/Archive", dynamicpb.NewMessage(get.Input()), dynamicpb.NewMessage(get.Output()))
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = client.call(other, "Delete", `{"id": "`+id+`"}`)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "unauthorized", status.Convert(err).Message())
+
+	deleted, err := client.call(owner, "Delete", `{"id": "`+id+`"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, id, grpcField(deleted, "id").String())
+
+	_, err = client.call(owner, "Get", `{"id": "`+id+`"}`)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// staticCustomLogic responds to every custom logic request with the same body.
+type staticCustomLogic string
+
+func (e staticCustomLogic) Execute(reader io.Reader, when string, operation string) (*http.Response, error) {
+	return &http.Response{Body: ioutil.NopCloser(strings.NewReader(string(e)))}, nil
+}
+
+func (e staticCustomLogic) ExecuteBatch(reader io.Reader, when string, operation string) (*http.Response, error) {
+	return e.Execute(reader, when, operation)
+}
+
+func TestGRPCDeclaredFields(t *testing.T) {
+	api := model.API{
+		Fields: []model.FieldDefinition{
+			model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+			model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+		},
+		Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{model.ActionDefinition{Name: "set-page-count", Fields: []string{"pageCount"}}},
+		}},
+	}
+	after := `{"id": "1", "title": "a", "unknown": true}`
+	h := Handlers{
+		API:                 api,
+		Store:               store.NewMemoryStore(api, false),
+		Authenticator:       user.APIKeyAuthenticator{Keys: []model.APIKey{model.APIKey{Hash: user.HashAPIKey("key"), Principal: "p"}}},
+		CustomLogicExecutor: staticCustomLogic(after),
+	}
+	client, stop := serveGRPC(t, h, "books")
+	defer stop()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), user.APIKeyHeader, "key")
+	assert.Equal(t, protoreflect.FullName("widgetproxy.books.Objects"), client.service.FullName())
+
+	created, err := client.call(ctx, "Create", `{"object": {"title": "a", "pageCount": 300}}`)
+	assert.NoError(t, err)
+	id := grpcField(created, "id").String()
+	assert.Equal(t, "a", grpcField(created, "title").String())
+	assert.Equal(t, int64(300), grpcField(created, "page_count").Int())
+
+	updated, err := client.call(ctx, "SetPageCount", `{"id": "`+id+`", "pageCount": 301}`)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(301), grpcField(updated, "page_count").Int())
+	assert.Equal(t, "a", grpcField(updated, "title").String())
+
+	// fields of the custom logic response that the Object message does not have fail the call instead of being dropped
+	afterLogic := "after"
+	h.CustomLogic = model.AllCustomLogic{Delete: &model.CustomLogic{After: &afterLogic}}
+	client, stop = serveGRPC(t, h, "books")
+	defer stop()
+	_, err = client.call(ctx, "Delete", `{"id": "`+id+`"}`)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "unknown")
+}
+
+func TestGRPCRecover(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/widgetproxy.Objects/Get"}
+	_, err := GRPCRecover(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		panic("store failed")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -10,7 +10,6 @@ import (
 	"strconv"
 
 	"github.com/gorilla/mux"
-	"github.com/gracew/widget-proxy/generated"
 	"github.com/gracew/widget-proxy/metrics"
 	"github.com/gracew/widget-proxy/model"
 	"github.com/gracew/widget-proxy/store"
@@ -19,123 +18,153 @@ import (
 )
 
 type Handlers struct {
+	// API is the API definition, which limits merge patches to the fields of the update action or of PATCH /{id}. It
+	// is not reloaded.
+	API model.API
+	// Schema is the schema request bodies are validated against. If nil, the schema of API is used.
+	Schema              *store.Schema
 	Store               store.Store
+	AuditLog            store.AuditLog
 	Auth                model.Auth
 	Authenticator       user.Authenticator
 	CustomLogic         model.AllCustomLogic
 	CustomLogicExecutor CustomLogicExecutor
+	// Definitions, if set, replaces Auth and CustomLogic with the current definitions at the start of each request.
+	Definitions *LiveDefinitions
+	// Metrics records the errors of the handlers. If nil, metrics.Default is used.
+	Metrics *metrics.Metrics
+	// MaxBulkItems is the most items a bulk request may hold. If 0, defaultMaxBulkItems is used.
+	MaxBulkItems int
+}
+
+func (h Handlers) metrics() *metrics.Metrics {
+	return metrics.Or(h.Metrics)
+}
+
+func (h Handlers) schema() *store.Schema {
+	if h.Schema != nil {
+		return h.Schema
+	}
+	return store.NewSchema(h.API)
 }
 
 func (h Handlers) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// get the userId
-	userID, err := h.Authenticator.GetUserId(r.Header)
-	if err != nil {
-		panic(err)
+	u := h.authenticate(w, r, metrics.CREATE)
+	if u == nil {
+		return
+	}
+	if !h.authorized(u, h.Auth.Create, metrics.CREATE, store.Record{"createdBy": u.ID}) {
+		h.unauthorizedResponse(w)
+		return
 	}
+	s := h.Store.WithCaller(writer(r, u))
 
-	obj, err := h.applyBeforeCustomLogic(r.Body, h.CustomLogic.Create, metrics.CREATE)
-	if err != nil {
-		panic(err)
+	obj, ok := h.decode(w, r.Body, h.CustomLogic.Create, metrics.CREATE)
+	if !ok {
+		return
 	}
 
 	// delegate to db
-	obj.CreatedBy = userID
-	res, err := h.Store.CreateObject(obj)
+	obj["createdBy"] = u.ID
+	res, err := s.CreateObject(obj)
 	if err != nil {
-		metrics.DatabaseErrors.WithLabelValues(metrics.CREATE).Inc()
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.CREATE, u.TenantID).Inc()
 		panic(err)
 	}
 
-	err = h.applyAfterCustomLogic(w, res, h.CustomLogic.Create, metrics.CREATE)
+	err = h.applyAfterCustomLogic(w, u, res, h.CustomLogic.Create, metrics.CREATE)
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (h Handlers) ReadHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// get the userId
-	userID, err := h.Authenticator.GetUserId(r.Header)
-	if err != nil {
-		panic(err)
+	u := h.authenticate(w, r, metrics.READ)
+	if u == nil {
+		return
 	}
+	s := h.Store.WithCaller(caller(r, u))
 
 	// delegate to db
 	vars := mux.Vars(r)
-	res, err := h.Store.GetObject(vars["id"])
+	res, err := s.GetObject(vars["id"])
 	if err != nil {
-		metrics.DatabaseErrors.WithLabelValues(metrics.READ).Inc()
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
 		panic(err)
 	}
+	if res == nil {
+		h.notFoundResponse(w)
+		return
+	}
 
-	if h.Auth.Read.Type == model.AuthPolicyTypeCreatedBy {
-		if userID != (*res).CreatedBy {
-			h.unauthorizedResponse(w)
-			return
-		}
+	if !h.authorized(u, h.Auth.Read, metrics.READ, res) {
+		h.unauthorizedResponse(w)
+		return
 	}
-	// TODO(gracew): support other authz policies
 
-	json.NewEncoder(w).Encode(&res)
+	w.Header().Set("ETag", etag(res.Version()))
+	json.NewEncoder(w).Encode(h.redact(u, res))
 }
 
 func (h Handlers) ListHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// get the userId
-	userID, err := h.Authenticator.GetUserId(r.Header)
-	if err != nil {
-		panic(err)
+	u := h.authenticate(w, r, metrics.LIST)
+	if u == nil {
+		return
 	}
+	s := h.Store.WithCaller(caller(r, u))
 
 	// delegate to db
 	query := r.URL.Query()
 	pageSizes, ok := query["pageSize"]
 	pageSize := 100
 	if ok && len(pageSizes[0]) >= 1 {
+		var err error
 		pageSize, err = strconv.Atoi(pageSizes[0])
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	res, err := h.Store.ListObjects(pageSize, filter(query))
+	res, err := s.ListObjects(pageSize, filter(query), nil)
 	if err != nil {
-		metrics.DatabaseErrors.WithLabelValues(metrics.LIST).Inc()
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.LIST, u.TenantID).Inc()
 		panic(err)
 	}
 
-	var filtered []generated.Object
-	if h.Auth.Read.Type == model.AuthPolicyTypeCreatedBy {
-		for i := 0; i < len(res); i++ {
-			if userID == res[i].CreatedBy {
-				filtered = append(filtered, res[i])
-			}
+	var filtered []store.Record
+	for _, obj := range res {
+		if h.authorized(u, h.Auth.Read, metrics.READ, obj) {
+			filtered = append(filtered, obj)
 		}
 	}
-	// TODO(gracew): support other authz policies
-
-	json.NewEncoder(w).Encode(filtered)
+	json.NewEncoder(w).Encode(h.redactAll(u, filtered))
 }
 
 func filter(query url.Values) *store.Filter {
 	for k, values := range query {
-		if k != "pageSize" {
+		if k != "pageSize" && k != consistencyParam {
 			return &store.Filter{Field: k, Value: values[0]}
 		}
 	}
@@ -143,112 +172,368 @@ func filter(query url.Values) *store.Filter {
 }
 
 func (h Handlers) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// get the userId
-	userID, err := h.Authenticator.GetUserId(r.Header)
+	vars := mux.Vars(r)
+	id := vars["id"]
+	actionName := vars["action"]
+	u := h.authenticate(w, r, actionName)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
+
+	// fetch object first, and enforce authz
+	res, err := s.GetObject(id)
 	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
 		panic(err)
 	}
+	if res == nil {
+		h.notFoundResponse(w)
+		return
+	}
+	if !h.authorized(u, h.Auth.Update[actionName], actionName, res) {
+		h.unauthorizedResponse(w)
+		return
+	}
+	version, ok := expectedVersion(r)
+	if !ok || (version != store.AnyVersion && version != res.Version()) {
+		h.preconditionFailedResponse(w)
+		return
+	}
 
-	// fetch object first, and enforce authz
-	vars := mux.Vars(r)
-	id := vars["id"]
-	actionName := vars["action"]
-	res, err := h.Store.GetObject(id)
-	if h.Auth.Update[actionName].Type == model.AuthPolicyTypeCreatedBy {
-		if userID != (*res).CreatedBy {
-			h.unauthorizedResponse(w)
+	// a merge patch updates only the fields of the action that it sets
+	var body io.Reader = r.Body
+	var fields []string
+	patch := isMergePatch(r)
+	if patch {
+		action := store.FindAction(h.API, actionName)
+		if action == nil {
+			h.notFoundResponse(w)
+			return
+		}
+		b, set, ok := h.readMergePatch(w, r)
+		if !ok {
 			return
 		}
+		for _, f := range action.Fields {
+			if set[f] {
+				fields = append(fields, f)
+			}
+		}
+		body = bytes.NewReader(b)
 	}
 
-	obj, err := h.applyBeforeCustomLogic(r.Body, h.CustomLogic.Update[actionName], actionName)
-	if err != nil {
-		panic(err)
+	obj, ok := h.decode(w, body, h.CustomLogic.Update[actionName], actionName)
+	if !ok {
+		return
 	}
 
 	// delegate to db
-	obj.ID = id
-	res, err = h.Store.UpdateObject(obj, actionName)
+	obj["id"] = id
+	if patch {
+		res, err = s.PatchObject(obj, actionName, fields, version)
+	} else {
+		res, err = s.UpdateObject(obj, actionName, version)
+	}
+	if errors.Is(err, store.ErrVersionConflict) {
+		h.preconditionFailedResponse(w)
+		return
+	}
 	if err != nil {
-		metrics.DatabaseErrors.WithLabelValues(actionName).Inc()
+		h.metrics().DatabaseErrors.WithLabelValues(actionName, u.TenantID).Inc()
 		panic(err)
 	}
 
-	err = h.applyAfterCustomLogic(w, res, h.CustomLogic.Update[actionName], actionName)
+	w.Header().Set("ETag", etag(res.Version()))
+
+	err = h.applyAfterCustomLogic(w, u, res, h.CustomLogic.Update[actionName], actionName)
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (h Handlers) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	// get the userId
-	userID, err := h.Authenticator.GetUserId(r.Header)
-	if err != nil {
-		panic(err)
+	u := h.authenticate(w, r, metrics.DELETE)
+	if u == nil {
+		return
 	}
+	s := h.Store.WithCaller(writer(r, u))
 
 	// fetch object first, and enforce authz
 	vars := mux.Vars(r)
-	obj, err := h.Store.GetObject(vars["id"])
-	if h.Auth.Delete.Type == model.AuthPolicyTypeCreatedBy {
-		if userID != (*obj).CreatedBy {
-			h.unauthorizedResponse(w)
-			return
-		}
+	obj, err := s.GetObject(vars["id"])
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
+		panic(err)
+	}
+	if obj == nil {
+		h.notFoundResponse(w)
+		return
+	}
+	if !h.authorized(u, h.Auth.Delete, metrics.DELETE, obj) {
+		h.unauthorizedResponse(w)
+		return
+	}
+	version, ok := expectedVersion(r)
+	if !ok || (version != store.AnyVersion && version != obj.Version()) {
+		h.preconditionFailedResponse(w)
+		return
 	}
 
-	objBytes, err := json.Marshal(obj)
+	_, err = h.applyBeforeCustomLogicTo(obj, h.CustomLogic.Delete, metrics.DELETE)
+	if err != nil {
+		panic(err)
+	}
+
+	err = s.DeleteObject(vars["id"], version)
+	if errors.Is(err, store.ErrVersionConflict) {
+		h.preconditionFailedResponse(w)
+		return
+	}
 	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.DELETE, u.TenantID).Inc()
 		panic(err)
 	}
 
-	_, err = h.applyBeforeCustomLogic(bytes.NewReader(objBytes), h.CustomLogic.Delete, metrics.DELETE)
+	err = h.applyAfterCustomLogic(w, u, obj, h.CustomLogic.Delete, metrics.DELETE)
 	if err != nil {
 		panic(err)
 	}
+}
+
+// RestoreHandler restores a soft-deleted object. The restore policy, or the delete policy if no restore policy is
+// defined, is evaluated against the deleted object.
+func (h Handlers) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	u := h.authenticate(w, r, metrics.RESTORE)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
 
-	err = h.Store.DeleteObject(vars["id"])
+	// fetch object first, and enforce authz
+	vars := mux.Vars(r)
+	obj, err := s.GetDeletedObject(vars["id"])
 	if err != nil {
-		metrics.DatabaseErrors.WithLabelValues(metrics.DELETE).Inc()
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
 		panic(err)
 	}
+	if obj == nil {
+		h.notFoundResponse(w)
+		return
+	}
+	policy := h.Auth.Restore
+	if policy == nil {
+		policy = h.Auth.Delete
+	}
+	if !h.authorized(u, policy, metrics.RESTORE, obj) {
+		h.unauthorizedResponse(w)
+		return
+	}
 
-	err = h.applyAfterCustomLogic(w, obj, h.CustomLogic.Delete, metrics.DELETE)
+	res, err := s.RestoreObject(vars["id"])
 	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.RESTORE, u.TenantID).Inc()
 		panic(err)
 	}
+	if res == nil {
+		h.notFoundResponse(w)
+		return
+	}
+
+	w.Header().Set("ETag", etag(res.Version()))
+	json.NewEncoder(w).Encode(h.redact(u, res))
+}
+
+// HistoryHandler returns the audit entries of an object to users allowed to read the object. The read policy of a
+// deleted object is evaluated against its state before deletion.
+func (h Handlers) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	u := h.authenticate(w, r, metrics.READ)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(caller(r, u))
+
+	vars := mux.Vars(r)
+	entries, err := h.AuditLog.History(vars["id"], u.TenantID)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.HISTORY, u.TenantID).Inc()
+		panic(err)
+	}
+	if len(entries) == 0 {
+		h.notFoundResponse(w)
+		return
+	}
+
+	obj, err := s.GetObject(vars["id"])
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
+		panic(err)
+	}
+	if obj == nil {
+		obj = stateBeforeDeletion(entries[len(entries)-1])
+		if obj == nil {
+			h.notFoundResponse(w)
+			return
+		}
+	}
+
+	if !h.authorized(u, h.Auth.Read, metrics.READ, obj) {
+		h.unauthorizedResponse(w)
+		return
+	}
+
+	for field, policy := range h.Auth.Fields {
+		if !h.authorized(u, policy, metrics.READ, obj) {
+			for _, entry := range entries {
+				delete(entry.Changes, field)
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// stateBeforeDeletion reconstructs a deleted object from the audit entry recording its deletion. It returns nil if
+// the entry does not record a deletion, such as when the object was removed without its deletion being recorded.
+func stateBeforeDeletion(entry store.AuditEntry) store.Record {
+	if entry.Operation != metrics.DELETE {
+		return nil
+	}
+	obj := store.Record{}
+	for field, change := range entry.Changes {
+		obj[field] = change.Before
+	}
+	return obj
 }
 
 type errorResponse struct {
 	Message string `json:"message"`
 }
 
+// authenticate returns the calling user. If the caller cannot be authenticated or may not invoke the operation, it
+// writes an error response and returns nil.
+func (h Handlers) authenticate(w http.ResponseWriter, r *http.Request, operation string) *user.User {
+	u, err := h.Authenticator.GetUser(r.Header)
+	if err != nil {
+		if errors.Is(err, user.ErrNoCredentials) || errors.Is(err, user.ErrInvalidCredentials) {
+			h.unauthenticatedResponse(w)
+			return nil
+		}
+		panic(err)
+	}
+	metrics.SetTenant(r.Context(), u.TenantID)
+	if !u.CanInvoke(operation) {
+		h.unauthorizedResponse(w)
+		return nil
+	}
+	return u
+}
+
+// consistencyParam is the query parameter with which callers may require strongly consistent reads.
+const consistencyParam = "consistency"
+
+// caller identifies the user and request for the Store.
+func caller(r *http.Request, u *user.User) store.Caller {
+	return store.Caller{
+		UserID:      u.ID,
+		TenantID:    u.TenantID,
+		RequestID:   r.Header.Get(RequestIDHeader),
+		Consistency: store.Consistency(r.URL.Query().Get(consistencyParam)),
+	}
+}
+
+// writer identifies the user and request for the Store of a write. The reads that check the object before it is
+// written, such as its authorization and expected version, are strongly consistent, as a replica may not have the
+// object or its latest version.
+func writer(r *http.Request, u *user.User) store.Caller {
+	c := caller(r, u)
+	c.Consistency = store.ConsistencyStrong
+	return c
+}
+
+func (h Handlers) preconditionFailedResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(&errorResponse{Message: "object has been modified"})
+}
+
+func (h Handlers) badRequestResponse(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(&errorResponse{Message: message})
+}
+
+func (h Handlers) notFoundResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(&errorResponse{Message: "not found"})
+}
+
+func (h Handlers) unauthenticatedResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(&errorResponse{Message: "unauthenticated"})
+}
+
 func (h Handlers) unauthorizedResponse(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusForbidden)
 	json.NewEncoder(w).Encode(&errorResponse{Message: "unauthorized"})
 
 }
 
-func (h Handlers) applyBeforeCustomLogic(reader io.Reader, customLogic *model.CustomLogic, operation string) (*generated.Object, error) {
-	var obj generated.Object
+// decode reads an object from the request body, passing it through the before custom logic if it is defined. If the
+// request body does not match the schema, it writes a 400 Bad Request response and returns false.
+func (h Handlers) decode(w http.ResponseWriter, body io.Reader, customLogic *model.CustomLogic, operation string) (store.Record, bool) {
+	obj, err := h.schema().Decode(body)
+	var invalid *store.ValidationError
+	if errors.As(err, &invalid) {
+		h.badRequestResponse(w, invalid.Error())
+		return nil, false
+	}
+	if err != nil {
+		panic(err)
+	}
 	if customLogic == nil || customLogic.Before == nil {
-		err := json.NewDecoder(reader).Decode(&obj)
-		if err != nil {
-			return nil, errors.Wrap(err, "could not read request body")
-		}
-		return &obj, nil
+		return obj, true
+	}
+
+	obj, err = h.applyBeforeCustomLogicTo(obj, customLogic, operation)
+	if err != nil {
+		panic(err)
+	}
+	return obj, true
+}
+
+// applyBeforeCustomLogic reads an object, passing it through the before custom logic if it is defined. A
+// *store.ValidationError is returned if the object does not match the schema.
+func (h Handlers) applyBeforeCustomLogic(reader io.Reader, customLogic *model.CustomLogic, operation string) (store.Record, error) {
+	if customLogic == nil || customLogic.Before == nil {
+		return h.schema().Decode(reader)
 	}
 
 	res, err := h.CustomLogicExecutor.Execute(reader, "before", operation)
@@ -256,15 +541,41 @@ func (h Handlers) applyBeforeCustomLogic(reader io.Reader, customLogic *model.Cu
 		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
 	}
 
-	err = json.NewDecoder(res.Body).Decode(&obj)
+	obj, err := h.schema().Decode(res.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read custom logic response body")
+		// the custom logic, rather than the request, is at fault
+		return nil, errors.Errorf("invalid custom logic response: %v", err)
 	}
+	return obj, nil
+}
 
-	return &obj, nil
+// applyBeforeCustomLogicTo passes the input, encoded as JSON, through the before custom logic if it is defined.
+func (h Handlers) applyBeforeCustomLogicTo(input interface{}, customLogic *model.CustomLogic, operation string) (store.Record, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal custom logic input")
+	}
+	return h.applyBeforeCustomLogic(bytes.NewReader(body), customLogic, operation)
 }
 
-func (h Handlers) applyAfterCustomLogic(w http.ResponseWriter, input *generated.Object, customLogic *model.CustomLogic, operation string) error {
+// afterCustomLogicOutput returns the JSON output of the operation for the object, passed through the after custom
+// logic if it is defined. Fields the user may not see are removed first.
+func (h Handlers) afterCustomLogicOutput(u *user.User, obj store.Record, customLogic *model.CustomLogic, operation string) ([]byte, error) {
+	redacted := h.redact(u, obj)
+	if customLogic == nil || customLogic.After == nil {
+		return json.Marshal(redacted)
+	}
+	return h.executeAfterCustomLogic(redacted, operation)
+}
+
+// applyAfterCustomLogic writes the response for the operation, passing the object through the after custom logic if
+// it is defined. Fields the user may not see are removed first.
+func (h Handlers) applyAfterCustomLogic(w http.ResponseWriter, u *user.User, obj store.Record, customLogic *model.CustomLogic, operation string) error {
+	return h.respond(w, h.redact(u, obj), customLogic, operation)
+}
+
+// respond writes the response for the operation, passing the input through the after custom logic if it is defined.
+func (h Handlers) respond(w http.ResponseWriter, input interface{}, customLogic *model.CustomLogic, operation string) error {
 	if customLogic == nil || customLogic.After == nil {
 		if operation == metrics.DELETE {
 			w.WriteHeader(http.StatusNoContent)
@@ -278,25 +589,34 @@ func (h Handlers) applyAfterCustomLogic(w http.ResponseWriter, input *generated.
 		return nil
 	}
 
-	inputBytes, err := json.Marshal(input)
+	resBytes, err := h.executeAfterCustomLogic(input, operation)
 	if err != nil {
-		return errors.Wrap(err, "could not marshal custom logic input")
+		return err
 	}
 
-	res, err := h.CustomLogicExecutor.Execute(bytes.NewReader(inputBytes), "after", operation)
+	_, err = w.Write(resBytes)
 	if err != nil {
-		errors.Wrap(err, "request to custom logic endpoint failed")
+		return errors.Wrap(err, "could not write response")
 	}
 
-	resBytes, err := ioutil.ReadAll(res.Body)
+	return nil
+}
+
+// executeAfterCustomLogic passes the input through the after custom logic of the operation, and returns its response.
+func (h Handlers) executeAfterCustomLogic(input interface{}, operation string) ([]byte, error) {
+	inputBytes, err := json.Marshal(input)
 	if err != nil {
-		return errors.Wrap(err, "could not read response from custom logic endpoint")
+		return nil, errors.Wrap(err, "could not marshal custom logic input")
 	}
 
-	_, err = w.Write(resBytes)
+	res, err := h.CustomLogicExecutor.Execute(bytes.NewReader(inputBytes), "after", operation)
 	if err != nil {
-		return errors.Wrap(err, "could not write response")
+		return nil, errors.Wrap(err, "request to custom logic endpoint failed")
 	}
 
-	return nil
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response from custom logic endpoint")
+	}
+	return resBytes, nil
 }
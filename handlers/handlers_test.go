@@ -1,3 +1,4 @@
+//go:build test
 // +build test
 
 package handlers
@@ -9,6 +10,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -18,6 +20,7 @@ import (
 	"github.com/gracew/widget-proxy/mocks"
 	"github.com/gracew/widget-proxy/model"
 	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -25,6 +28,7 @@ import (
 type HandlersTestSuite struct {
 	suite.Suite
 	store         *mocks.MockStore
+	auditLog      *mocks.MockAuditLog
 	executor      *mocks.MockCustomLogicExecutor
 	authenticator *mocks.MockAuthenticator
 }
@@ -35,11 +39,14 @@ func (suite *HandlersTestSuite) SetupTest() {
 	mockCtrl := gomock.NewController(suite.T())
 	defer mockCtrl.Finish()
 	suite.store = mocks.NewMockStore(mockCtrl)
+	suite.auditLog = mocks.NewMockAuditLog(mockCtrl)
 	suite.executor = mocks.NewMockCustomLogicExecutor(mockCtrl)
 	suite.authenticator = mocks.NewMockAuthenticator(mockCtrl)
-	suite.authenticator.EXPECT().GetUserId(gomock.Any()).Return("userID", nil).AnyTimes()
+	suite.authenticator.EXPECT().GetUser(gomock.Any()).Return(&user.User{ID: "userID"}, nil).AnyTimes()
+	suite.store.EXPECT().WithCaller(gomock.Any()).Return(suite.store).AnyTimes()
 	h = Handlers{
 		Store:               suite.store,
+		AuditLog:            suite.auditLog,
 		CustomLogic:         model.AllCustomLogic{},
 		CustomLogicExecutor: suite.executor,
 		Authenticator:       suite.authenticator,
@@ -54,12 +61,12 @@ func (suite *HandlersTestSuite) SetupTest() {
 }
 
 func (suite *HandlersTestSuite) TestCreate() {
-	input := generated.Object{ID: "1"}
-	storeInput := generated.Object{ID: "1", CreatedBy: "userID"}
+	input := generated.Object{ID: "1", Test: "test"}
+	storeInput := store.Record{"test": "test", "createdBy": "userID"}
 	storeOutput := generated.Object{ID: "2"}
 
 	suite.executor.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
-	suite.store.EXPECT().CreateObject(&storeInput).Return(&storeOutput, nil)
+	suite.store.EXPECT().CreateObject(storeInput).Return(record(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	h.CreateHandler(rr, suite.request(input))
@@ -67,20 +74,63 @@ func (suite *HandlersTestSuite) TestCreate() {
 	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
 }
 
+func (suite *HandlersTestSuite) TestCreateInvalid() {
+	suite.store.EXPECT().CreateObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", strings.NewReader(`{"test": 1}`))
+	assert.NoError(suite.T(), err)
+	h.CreateHandler(rr, req)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, rr.Result().StatusCode)
+	assert.Contains(suite.T(), rr.Body.String(), "test: expected a value of type STRING")
+}
+
+func (suite *HandlersTestSuite) TestCreateUnauthenticated() {
+	suite.authenticateAs(nil, user.ErrInvalidCredentials)
+	suite.store.EXPECT().CreateObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.CreateHandler(rr, suite.request(generated.Object{}))
+
+	assert.Equal(suite.T(), http.StatusUnauthorized, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestCreateOutOfScope() {
+	suite.authenticateAs(&user.User{ID: "service", Scopes: []string{metrics.READ}}, nil)
+	suite.store.EXPECT().CreateObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.CreateHandler(rr, suite.request(generated.Object{}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestCreateRoleUnauthorized() {
+	h.Auth.Create = &model.AuthPolicy{Type: model.AuthPolicyTypeRole}
+	h.Auth.Roles = map[string][]string{"editor": []string{metrics.CREATE}}
+	suite.store.EXPECT().CreateObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.CreateHandler(rr, suite.request(generated.Object{}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
 func (suite *HandlersTestSuite) TestCreateCustomLogic() {
 	customLogic := "something"
 	h.CustomLogic = model.AllCustomLogic{Create: &model.CustomLogic{Before: &customLogic, After: &customLogic}}
 
 	input := generated.Object{ID: "1"}
-	beforeCustomLogicOutput := generated.Object{ID: "2"}
-	storeInput := generated.Object{ID: "2", CreatedBy: "userID"}
+	beforeCustomLogicOutput := generated.Object{Test: "before"}
+	storeInput := store.Record{"test": "before", "createdBy": "userID"}
 	storeOutput := generated.Object{ID: "3"}
 	afterCustomLogicOutput := generated.Object{ID: "4"}
 
 	suite.executor.EXPECT().Execute(gomock.Any(), "before", metrics.CREATE).
 		Times(1).
 		Return(suite.response(beforeCustomLogicOutput), nil)
-	suite.store.EXPECT().CreateObject(&storeInput).Return(&storeOutput, nil)
+	suite.store.EXPECT().CreateObject(storeInput).Return(record(storeOutput), nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), "after", metrics.CREATE).
 		Times(1).
 		Return(suite.response(afterCustomLogicOutput), nil)
@@ -91,21 +141,46 @@ func (suite *HandlersTestSuite) TestCreateCustomLogic() {
 	assert.Equal(suite.T(), afterCustomLogicOutput, suite.decode(rr.Body))
 }
 
+func (suite *HandlersTestSuite) TestCreateCustomLogicRedactsFields() {
+	customLogic := "something"
+	h.CustomLogic = model.AllCustomLogic{Create: &model.CustomLogic{After: &customLogic}}
+	h.Auth.Fields = map[string]*model.AuthPolicy{
+		"test": &model.AuthPolicy{Type: model.AuthPolicyTypeRole, Roles: []string{"hr"}},
+	}
+
+	storeOutput := generated.Object{ID: "1", CreatedBy: "userID", Test: "secret"}
+	suite.store.EXPECT().CreateObject(gomock.Any()).Return(record(storeOutput), nil)
+	suite.executor.EXPECT().Execute(gomock.Any(), "after", metrics.CREATE).
+		DoAndReturn(func(reader io.Reader, when string, operation string) (*http.Response, error) {
+			var input map[string]interface{}
+			err := json.NewDecoder(reader).Decode(&input)
+			assert.NoError(suite.T(), err)
+			assert.NotContains(suite.T(), input, "test")
+			return suite.response(generated.Object{ID: "1"}), nil
+		})
+
+	rr := httptest.NewRecorder()
+	h.CreateHandler(rr, suite.request(generated.Object{Test: "secret"}))
+
+	assert.Equal(suite.T(), generated.Object{ID: "1"}, suite.decode(rr.Body))
+}
+
 func (suite *HandlersTestSuite) TestRead() {
 	storeOutput := generated.Object{ID: "1", CreatedBy: "userID"}
-	suite.store.EXPECT().GetObject("1").Return(&storeOutput, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
 	assert.NoError(suite.T(), err)
 	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
 
+	assert.Equal(suite.T(), `"0"`, rr.Result().Header.Get("ETag"))
 	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
 }
 
 func (suite *HandlersTestSuite) TestReadUnauthorized() {
 	storeOutput := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
-	suite.store.EXPECT().GetObject("1").Return(&storeOutput, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
@@ -115,9 +190,101 @@ func (suite *HandlersTestSuite) TestReadUnauthorized() {
 	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
 }
 
+func (suite *HandlersTestSuite) TestReadNotFound() {
+	suite.store.EXPECT().GetObject("1").Return(nil, nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusNotFound, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestReadTenant() {
+	suite.authenticateAs(&user.User{ID: "userID", TenantID: "tenantID"}, nil)
+	tenantStore := mocks.NewMockStore(gomock.NewController(suite.T()))
+	h.Store = tenantStore
+
+	storeOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+	tenantStore.EXPECT().WithCaller(store.Caller{UserID: "userID", TenantID: "tenantID"}).Return(suite.store)
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestReadRole() {
+	h.Auth.Roles = map[string][]string{"admin": []string{metrics.READ, metrics.DELETE}}
+	h.Auth.Read = &model.AuthPolicy{
+		Type: model.AuthPolicyTypeAnyOf,
+		Policies: []*model.AuthPolicy{
+			&model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy},
+			&model.AuthPolicy{Type: model.AuthPolicyTypeRole},
+		},
+	}
+	suite.authenticateAs(&user.User{ID: "adminID", Roles: []string{"admin"}}, nil)
+
+	storeOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestReadUnenforcedPolicy() {
+	attribute := "test"
+	h.Auth.Read = &model.AuthPolicy{
+		Type: model.AuthPolicyTypeAnyOf,
+		Policies: []*model.AuthPolicy{
+			&model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy},
+			&model.AuthPolicy{Type: model.AuthPolicyTypeAttributeMatch, UserAttribute: &attribute, ObjectAttribute: &attribute},
+		},
+	}
+
+	// the attribute match is not enforced, so only the creator may read the object
+	storeOutput := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestReadRedactsFields() {
+	h.Auth.Fields = map[string]*model.AuthPolicy{
+		"test": &model.AuthPolicy{Type: model.AuthPolicyTypeRole, Roles: []string{"hr"}},
+	}
+
+	storeOutput := generated.Object{ID: "1", CreatedBy: "userID", Test: "secret"}
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.ReadHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	var res map[string]interface{}
+	err = json.NewDecoder(rr.Body).Decode(&res)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "1", res["id"])
+	assert.NotContains(suite.T(), res, "test")
+}
+
 func (suite *HandlersTestSuite) TestListDefaultPageSize() {
 	storeOutput := []generated.Object{generated.Object{ID: "1", CreatedBy: "userID"}}
-	suite.store.EXPECT().ListObjects(100, nil).Return(storeOutput, nil)
+	suite.store.EXPECT().ListObjects(100, nil, nil).Return(records(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
@@ -132,7 +299,7 @@ func (suite *HandlersTestSuite) TestListDefaultPageSize() {
 
 func (suite *HandlersTestSuite) TestListPageSizeQuery() {
 	storeOutput := []generated.Object{generated.Object{ID: "1", CreatedBy: "userID"}}
-	suite.store.EXPECT().ListObjects(50, nil).Return(storeOutput, nil)
+	suite.store.EXPECT().ListObjects(50, nil, nil).Return(records(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
@@ -150,7 +317,7 @@ func (suite *HandlersTestSuite) TestListPageSizeQuery() {
 
 func (suite *HandlersTestSuite) TestListUnauthorized() {
 	storeOutput := []generated.Object{generated.Object{ID: "1", CreatedBy: "anotherUserID"}}
-	suite.store.EXPECT().ListObjects(100, nil).Return(storeOutput, nil)
+	suite.store.EXPECT().ListObjects(100, nil, nil).Return(records(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
@@ -166,7 +333,7 @@ func (suite *HandlersTestSuite) TestListUnauthorized() {
 
 func (suite *HandlersTestSuite) TestListFilter() {
 	storeOutput := []generated.Object{generated.Object{ID: "1", CreatedBy: "userID"}}
-	suite.store.EXPECT().ListObjects(100, &store.Filter{Field: "key", Value: "value"}).Return(storeOutput, nil)
+	suite.store.EXPECT().ListObjects(100, &store.Filter{Field: "key", Value: "value"}, nil).Return(records(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("GET", "", nil)
@@ -183,14 +350,26 @@ func (suite *HandlersTestSuite) TestListFilter() {
 	assert.Equal(suite.T(), storeOutput, res)
 }
 
+func (suite *HandlersTestSuite) TestListConsistency() {
+	suite.store.EXPECT().ListObjects(100, nil, nil).Return(nil, nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/?consistency=strong", nil)
+	assert.NoError(suite.T(), err)
+	h.ListHandler(rr, req)
+
+	assert.Equal(suite.T(), http.StatusOK, rr.Result().StatusCode)
+}
+
 func (suite *HandlersTestSuite) TestUpdate() {
 	input := generated.Object{ID: "1"}
 	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
 	storeOutput := generated.Object{ID: "2"}
 
-	suite.store.EXPECT().GetObject("1").Return(&getOutput, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
-	suite.store.EXPECT().UpdateObject(&input, "action").Return(&storeOutput, nil)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "1", "test": ""}, "action", store.AnyVersion).
+		Return(record(storeOutput), nil)
 
 	rr := httptest.NewRecorder()
 	h.UpdateHandler(rr, mux.SetURLVars(suite.request(input), map[string]string{"id": "1", "action": "action"}))
@@ -198,12 +377,62 @@ func (suite *HandlersTestSuite) TestUpdate() {
 	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
 }
 
+func (suite *HandlersTestSuite) TestUpdateIfMatch() {
+	input := generated.Object{ID: "1"}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID", Version: 2}
+	storeOutput := generated.Object{ID: "1", Version: 3}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "1", "test": ""}, "action", int64(2)).
+		Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req := suite.request(input)
+	req.Header.Set("If-Match", `"2"`)
+	h.UpdateHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1", "action": "action"}))
+
+	assert.Equal(suite.T(), `"3"`, rr.Result().Header.Get("ETag"))
+	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestUpdateIfMatchStale() {
+	input := generated.Object{ID: "1"}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID", Version: 2}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().UpdateObject(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req := suite.request(input)
+	req.Header.Set("If-Match", `"1"`)
+	h.UpdateHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1", "action": "action"}))
+
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, rr.Result().StatusCode)
+}
+
 func (suite *HandlersTestSuite) TestUpdateUnauthorized() {
 	input := generated.Object{ID: "1"}
 	getOutput := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
 
-	suite.store.EXPECT().GetObject("1").Return(&getOutput, nil)
-	suite.store.EXPECT().UpdateObject(gomock.Any(), gomock.Any()).Times(0)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().UpdateObject(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	h.UpdateHandler(rr, mux.SetURLVars(suite.request(input), map[string]string{"id": "1", "action": "action"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestUpdateRole() {
+	h.Auth.Roles = map[string][]string{"admin": []string{metrics.READ, metrics.DELETE}, "editor": []string{"action"}}
+	h.Auth.Update["action"] = &model.AuthPolicy{Type: model.AuthPolicyTypeRole}
+	suite.authenticateAs(&user.User{ID: "adminID", Roles: []string{"admin"}}, nil)
+
+	input := generated.Object{ID: "1"}
+	getOutput := generated.Object{ID: "1", CreatedBy: "adminID"}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().UpdateObject(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 	rr := httptest.NewRecorder()
 	h.UpdateHandler(rr, mux.SetURLVars(suite.request(input), map[string]string{"id": "1", "action": "action"}))
@@ -225,11 +454,12 @@ func (suite *HandlersTestSuite) TestUpdateCustomLogic() {
 	storeOutput := generated.Object{ID: "2"}
 	afterCustomLogicOutput := generated.Object{ID: "3"}
 
-	suite.store.EXPECT().GetObject("1").Return(&getOutput, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), "before", "action").
 		Times(1).
 		Return(suite.response(beforeCustomLogicOutput), nil)
-	suite.store.EXPECT().UpdateObject(&beforeCustomLogicOutput, "action").Return(&storeOutput, nil)
+	suite.store.EXPECT().UpdateObject(store.Record{"id": "1", "test": "test"}, "action", store.AnyVersion).
+		Return(record(storeOutput), nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), "after", "action").
 		Times(1).
 		Return(suite.response(afterCustomLogicOutput), nil)
@@ -240,16 +470,145 @@ func (suite *HandlersTestSuite) TestUpdateCustomLogic() {
 	assert.Equal(suite.T(), afterCustomLogicOutput, suite.decode(rr.Body))
 }
 
+func (suite *HandlersTestSuite) TestUpdateMergePatch() {
+	h.API = model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+		Actions: []model.ActionDefinition{model.ActionDefinition{Name: "action", Fields: []string{"test", "createdBy"}}},
+	}}}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+	storeOutput := generated.Object{ID: "1", Test: "test", Version: 2}
+
+	// createdBy is a field of the action, but is not in the patch
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().PatchObject(store.Record{"id": "1", "test": "test"}, "action", []string{"test"}, store.AnyVersion).
+		Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", strings.NewReader(`{"test": "test"}`))
+	assert.NoError(suite.T(), err)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	h.UpdateHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1", "action": "action"}))
+
+	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestUpdateMergePatchInvalid() {
+	h.API = model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+		Actions: []model.ActionDefinition{model.ActionDefinition{Name: "action", Fields: []string{"test"}}},
+	}}}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().PatchObject(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", strings.NewReader(`["test"]`))
+	assert.NoError(suite.T(), err)
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	h.UpdateHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1", "action": "action"}))
+
+	assert.Equal(suite.T(), http.StatusBadRequest, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestPatch() {
+	h.API = model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+		Patch: &model.PatchDefinition{Fields: []string{"test"}},
+	}}}
+	h.Auth.Update[metrics.PATCH] = &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID", Version: 2}
+	storeOutput := generated.Object{ID: "1", Version: 3}
+
+	// a null field is removed, and written as its zero value
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().PatchObject(store.Record{"id": "1", "test": ""}, metrics.PATCH, []string{"test"}, int64(2)).
+		Return(record(storeOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PATCH", "", strings.NewReader(`{"test": null}`))
+	assert.NoError(suite.T(), err)
+	req.Header.Set("If-Match", `"2"`)
+	h.PatchHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), `"3"`, rr.Result().Header.Get("ETag"))
+	assert.Equal(suite.T(), storeOutput, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestPatchUnknownField() {
+	h.API = model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+		Patch: &model.PatchDefinition{Fields: []string{"test"}},
+	}}}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().PatchObject(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PATCH", "", strings.NewReader(`{"test": "test", "createdBy": "spoofed", "version": 5}`))
+	assert.NoError(suite.T(), err)
+	h.PatchHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusBadRequest, rr.Result().StatusCode)
+	assert.Contains(suite.T(), rr.Body.String(), "fields cannot be patched: createdBy, version")
+}
+
+func (suite *HandlersTestSuite) TestPatchUnauthorized() {
+	h.Auth.Update[metrics.PATCH] = &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}
+	getOutput := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().PatchObject(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PATCH", "", strings.NewReader(`{"test": "test"}`))
+	assert.NoError(suite.T(), err)
+	h.PatchHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
 func (suite *HandlersTestSuite) TestDelete() {
 	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
 
 	suite.executor.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
-	suite.store.EXPECT().GetObject("1").Return(&getOutput, nil)
-	suite.store.EXPECT().DeleteObject("1").Return(nil)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().DeleteObject("1", store.AnyVersion).Return(nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", "", nil)
+	assert.NoError(suite.T(), err)
+	h.DeleteHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusNoContent, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestDeleteVersionConflict() {
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID", Version: 2}
+
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().DeleteObject("1", int64(2)).Return(store.ErrVersionConflict)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("DELETE", "", nil)
 	assert.NoError(suite.T(), err)
+	req.Header.Set("If-Match", `"2"`)
+	h.DeleteHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusPreconditionFailed, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestDeleteConsistency() {
+	strongStore := mocks.NewMockStore(gomock.NewController(suite.T()))
+	h.Store = strongStore
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+
+	// the object is checked on the primary, whatever consistency the request asks for
+	strongStore.EXPECT().WithCaller(store.Caller{UserID: "userID", Consistency: store.ConsistencyStrong}).
+		Return(suite.store)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+	suite.store.EXPECT().DeleteObject("1", store.AnyVersion).Return(nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("DELETE", "/?consistency=eventual", nil)
+	assert.NoError(suite.T(), err)
 	h.DeleteHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
 
 	assert.Equal(suite.T(), http.StatusNoContent, rr.Result().StatusCode)
@@ -257,8 +616,8 @@ func (suite *HandlersTestSuite) TestDelete() {
 
 func (suite *HandlersTestSuite) TestDeleteUnauthorized() {
 	storeOutput := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
-	suite.store.EXPECT().GetObject("1").Return(&storeOutput, nil)
-	suite.store.EXPECT().DeleteObject(gomock.Any).Times(0)
+	suite.store.EXPECT().GetObject("1").Return(record(storeOutput), nil)
+	suite.store.EXPECT().DeleteObject(gomock.Any(), gomock.Any()).Times(0)
 
 	rr := httptest.NewRecorder()
 	req, err := http.NewRequest("DELETE", "", nil)
@@ -275,11 +634,11 @@ func (suite *HandlersTestSuite) TestDeleteCustomLogic() {
 	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
 	afterCustomLogicOutput := generated.Object{ID: "2"}
 
-	suite.store.EXPECT().GetObject("1").Return(&getOutput, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), "before", metrics.DELETE).
 		Times(1).
 		Return(suite.response(getOutput), nil)
-	suite.store.EXPECT().DeleteObject("1").Return(nil)
+	suite.store.EXPECT().DeleteObject("1", store.AnyVersion).Return(nil)
 	suite.executor.EXPECT().Execute(gomock.Any(), "after", metrics.DELETE).
 		Times(1).
 		Return(suite.response(afterCustomLogicOutput), nil)
@@ -293,6 +652,131 @@ func (suite *HandlersTestSuite) TestDeleteCustomLogic() {
 	assert.Equal(suite.T(), afterCustomLogicOutput, suite.decode(rr.Body))
 }
 
+func (suite *HandlersTestSuite) TestRestore() {
+	deleted := generated.Object{ID: "1", CreatedBy: "userID", Version: 2}
+	restored := generated.Object{ID: "1", CreatedBy: "userID", Version: 3}
+	suite.store.EXPECT().GetDeletedObject("1").Return(record(deleted), nil)
+	suite.store.EXPECT().RestoreObject("1").Return(record(restored), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", nil)
+	assert.NoError(suite.T(), err)
+	h.RestoreHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), `"3"`, rr.Result().Header.Get("ETag"))
+	assert.Equal(suite.T(), restored, suite.decode(rr.Body))
+}
+
+func (suite *HandlersTestSuite) TestRestoreUnauthorized() {
+	deleted := generated.Object{ID: "1", CreatedBy: "anotherUserID"}
+	suite.store.EXPECT().GetDeletedObject("1").Return(record(deleted), nil)
+	suite.store.EXPECT().RestoreObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", nil)
+	assert.NoError(suite.T(), err)
+	h.RestoreHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestRestorePolicy() {
+	h.Auth.Restore = &model.AuthPolicy{Type: model.AuthPolicyTypeRole, Roles: []string{"admin"}}
+	deleted := generated.Object{ID: "1", CreatedBy: "userID"}
+	suite.store.EXPECT().GetDeletedObject("1").Return(record(deleted), nil)
+	suite.store.EXPECT().RestoreObject(gomock.Any()).Times(0)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", nil)
+	assert.NoError(suite.T(), err)
+	h.RestoreHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestRestoreNotFound() {
+	suite.store.EXPECT().GetDeletedObject("1").Return(nil, nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "", nil)
+	assert.NoError(suite.T(), err)
+	h.RestoreHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusNotFound, rr.Result().StatusCode)
+}
+
+// authenticateAs replaces the default authenticator with one returning the given user and error.
+func (suite *HandlersTestSuite) authenticateAs(u *user.User, err error) {
+	authenticator := mocks.NewMockAuthenticator(gomock.NewController(suite.T()))
+	authenticator.EXPECT().GetUser(gomock.Any()).Return(u, err).AnyTimes()
+	h.Authenticator = authenticator
+}
+
+func (suite *HandlersTestSuite) TestHistory() {
+	h.Auth.Fields = map[string]*model.AuthPolicy{
+		"test": &model.AuthPolicy{Type: model.AuthPolicyTypeRole, Roles: []string{"hr"}},
+	}
+	getOutput := generated.Object{ID: "1", CreatedBy: "userID"}
+	entries := []store.AuditEntry{
+		store.AuditEntry{ObjectID: "1", Operation: metrics.CREATE, Changes: map[string]store.FieldChange{
+			"id":   store.FieldChange{After: "1"},
+			"test": store.FieldChange{After: "secret"},
+		}},
+	}
+
+	suite.auditLog.EXPECT().History("1", "").Return(entries, nil)
+	suite.store.EXPECT().GetObject("1").Return(record(getOutput), nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.HistoryHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	var res []store.AuditEntry
+	err = json.NewDecoder(rr.Body).Decode(&res)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), res, 1)
+	assert.Equal(suite.T(), map[string]store.FieldChange{"id": store.FieldChange{After: "1"}}, res[0].Changes)
+}
+
+func (suite *HandlersTestSuite) TestHistoryDeletedUnauthorized() {
+	entries := []store.AuditEntry{
+		store.AuditEntry{ObjectID: "1", Operation: metrics.DELETE, Changes: map[string]store.FieldChange{
+			"id":        store.FieldChange{Before: "1"},
+			"createdBy": store.FieldChange{Before: "anotherUserID"},
+		}},
+	}
+
+	suite.auditLog.EXPECT().History("1", "").Return(entries, nil)
+	suite.store.EXPECT().GetObject("1").Return(nil, nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.HistoryHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusForbidden, rr.Result().StatusCode)
+}
+
+func (suite *HandlersTestSuite) TestHistoryNotDeleted() {
+	// the object is gone, but its last entry does not record its deletion
+	entries := []store.AuditEntry{
+		store.AuditEntry{ObjectID: "1", Operation: metrics.CREATE, Changes: map[string]store.FieldChange{
+			"id": store.FieldChange{After: "1"},
+		}},
+	}
+
+	suite.auditLog.EXPECT().History("1", "").Return(entries, nil)
+	suite.store.EXPECT().GetObject("1").Return(nil, nil)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "", nil)
+	assert.NoError(suite.T(), err)
+	h.HistoryHandler(rr, mux.SetURLVars(req, map[string]string{"id": "1"}))
+
+	assert.Equal(suite.T(), http.StatusNotFound, rr.Result().StatusCode)
+}
+
 func (suite *HandlersTestSuite) request(obj generated.Object) *http.Request {
 	req, err := http.NewRequest("POST", "", suite.encode(obj))
 	assert.NoError(suite.T(), err)
@@ -309,6 +793,31 @@ func (suite *HandlersTestSuite) encode(obj generated.Object) *bytes.Reader {
 	return bytes.NewReader(bs)
 }
 
+// record returns the object as the store returns it.
+func record(obj generated.Object) store.Record {
+	bs, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	var res store.Record
+	d := json.NewDecoder(bytes.NewReader(bs))
+	d.UseNumber()
+	err = d.Decode(&res)
+	if err != nil {
+		panic(err)
+	}
+	res["version"] = obj.Version
+	return res
+}
+
+func records(objs []generated.Object) []store.Record {
+	var res []store.Record
+	for _, obj := range objs {
+		res = append(res, record(obj))
+	}
+	return res
+}
+
 func (suite *HandlersTestSuite) decode(body io.Reader) generated.Object {
 	var res generated.Object
 	err := json.NewDecoder(body).Decode(&res)
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/gracew/widget-proxy/user"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryStoreLifecycle exercises the handlers against a MemoryStore rather than mock expectations.
+func TestMemoryStoreLifecycle(t *testing.T) {
+	api := model.API{Operations: &model.OperationDefinition{
+		Update: &model.UpdateDefinition{
+			Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename", Fields: []string{"test"}}},
+		},
+	}}
+	h := Handlers{
+		Store: store.NewMemoryStore(api, false),
+		Auth:  model.Auth{Delete: &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{
+			model.APIKey{Hash: user.HashAPIKey("owner"), Principal: "owner"},
+			model.APIKey{Hash: user.HashAPIKey("other"), Principal: "other"},
+		}},
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/", h.CreateHandler).Methods("POST")
+	r.HandleFunc("/{id}", h.ReadHandler).Methods("GET")
+	r.HandleFunc("/{id}/{action}", h.UpdateHandler).Methods("POST")
+	r.HandleFunc("/{id}", h.DeleteHandler).Methods("DELETE")
+
+	serve := func(method string, path string, key string, body string, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set(user.APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("POST", "/", "owner", `{"test": "a"}`, nil)
+	var created struct {
+		ID        string `json:"id"`
+		CreatedBy string `json:"createdBy"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	assert.Equal(t, "owner", created.CreatedBy)
+
+	rr = serve("GET", "/"+created.ID, "other", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+
+	rr = serve("POST", "/"+created.ID+"/rename", "other", `{"test": "b"}`, http.Header{"If-Match": []string{`"1"`}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+
+	rr = serve("POST", "/"+created.ID+"/rename", "other", `{"test": "c"}`, http.Header{"If-Match": []string{`"1"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+
+	rr = serve("DELETE", "/"+created.ID, "other", "", nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serve("DELETE", "/"+created.ID, "owner", "", nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = serve("GET", "/"+created.ID, "owner", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// TestMemoryStoreDeclaredFields exercises the handlers for an API with declared fields, whose request bodies are
+// validated against the schema.
+func TestMemoryStoreDeclaredFields(t *testing.T) {
+	api := model.API{
+		Fields: []model.FieldDefinition{
+			model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+			model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+			model.FieldDefinition{Name: "publishedAt", Type: model.FieldTypeTimestamp},
+		},
+		Operations: &model.OperationDefinition{
+			Update: &model.UpdateDefinition{
+				Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename", Fields: []string{"title"}}},
+			},
+		},
+	}
+	h := Handlers{
+		API:   api,
+		Store: store.NewMemoryStore(api, false),
+		Auth: model.Auth{
+			Delete: &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy},
+			Fields: map[string]*model.AuthPolicy{"pageCount": &model.AuthPolicy{Type: model.AuthPolicyTypeCreatedBy}},
+		},
+		Authenticator: user.APIKeyAuthenticator{Keys: []model.APIKey{
+			model.APIKey{Hash: user.HashAPIKey("owner"), Principal: "owner"},
+			model.APIKey{Hash: user.HashAPIKey("other"), Principal: "other"},
+		}},
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/", h.CreateHandler).Methods("POST")
+	r.HandleFunc("/{id}", h.ReadHandler).Methods("GET")
+	r.HandleFunc("/{id}/{action}", h.UpdateHandler).Methods("POST")
+	r.HandleFunc("/", h.ListHandler).Methods("GET")
+	r.HandleFunc("/{id}", h.DeleteHandler).Methods("DELETE")
+
+	serve := func(method string, path string, key string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set(user.APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) map[string]interface{} {
+		var res map[string]interface{}
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+		return res
+	}
+
+	rr := serve("POST", "/", "owner", `{"title": 1}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"message": "title: expected a value of type STRING"}`, rr.Body.String())
+	rr = serve("POST", "/", "owner", `{"author": "a"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve("POST", "/", "owner", `{"title": "a", "pageCount": 10, "publishedAt": "2020-01-02T03:04:05Z"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	created := decode(rr)
+	id, _ := created["id"].(string)
+	assert.Equal(t, "owner", created["createdBy"])
+	assert.Equal(t, float64(10), created["pageCount"])
+	assert.Equal(t, "2020-01-02T03:04:05Z", created["publishedAt"])
+
+	// pageCount is only visible to the creator
+	rr = serve("GET", "/"+id, "other", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
+	read := decode(rr)
+	assert.Equal(t, "a", read["title"])
+	assert.NotContains(t, read, "pageCount")
+	rr = serve("GET", "/", "other", "")
+	var list []map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+	assert.Len(t, list, 1)
+	assert.NotContains(t, list[0], "pageCount")
+
+	rr = serve("POST", "/"+id+"/rename", "other", `{"title": "b"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+	assert.Equal(t, "b", decode(rr)["title"])
+	rr = serve("POST", "/"+id+"/rename", "other", `{"title": false}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serve("DELETE", "/"+id, "other", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = serve("DELETE", "/"+id, "owner", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = serve("GET", "/"+id, "owner", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
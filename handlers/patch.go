@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/store"
+	"github.com/pkg/errors"
+)
+
+// mergePatchContentType is the media type of JSON Merge Patch (RFC 7396) documents. Update actions whose request body
+// has this type update only the fields present in the body.
+const mergePatchContentType = "application/merge-patch+json"
+
+// isMergePatch returns whether the request body is a JSON Merge Patch document.
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == mergePatchContentType
+}
+
+// readMergePatch reads the JSON Merge Patch document in the request body, and returns it along with the fields it sets.
+// A field set to null is removed, and so written as its zero value. If the document is not a JSON object, a bad request
+// response is written and false is returned.
+func (h Handlers) readMergePatch(w http.ResponseWriter, r *http.Request) ([]byte, map[string]bool, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(errors.Wrap(err, "could not read request body"))
+	}
+	var patch map[string]json.RawMessage
+	if json.Unmarshal(body, &patch) != nil || patch == nil {
+		h.badRequestResponse(w, "request body must be a JSON object")
+		return nil, nil, false
+	}
+	set := map[string]bool{}
+	for f := range patch {
+		set[f] = true
+	}
+	return body, set, true
+}
+
+// PatchHandler applies the JSON Merge Patch in the request body to an object, updating only the fields present in the
+// body. The fields must be among those the API definition allows to be patched. The auth policy and custom logic of
+// the patch are those of an update action named patch.
+func (h Handlers) PatchHandler(w http.ResponseWriter, r *http.Request) {
+	h = h.current()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	u := h.authenticate(w, r, metrics.PATCH)
+	if u == nil {
+		return
+	}
+	s := h.Store.WithCaller(writer(r, u))
+
+	// fetch object first, and enforce authz
+	res, err := s.GetObject(id)
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.READ, u.TenantID).Inc()
+		panic(err)
+	}
+	if res == nil {
+		h.notFoundResponse(w)
+		return
+	}
+	if !h.authorized(u, h.Auth.Update[metrics.PATCH], metrics.PATCH, res) {
+		h.unauthorizedResponse(w)
+		return
+	}
+	version, ok := expectedVersion(r)
+	if !ok || (version != store.AnyVersion && version != res.Version()) {
+		h.preconditionFailedResponse(w)
+		return
+	}
+
+	body, set, ok := h.readMergePatch(w, r)
+	if !ok {
+		return
+	}
+	var allowed []string
+	if h.API.Operations != nil && h.API.Operations.Update != nil && h.API.Operations.Update.Patch != nil {
+		allowed = h.API.Operations.Update.Patch.Fields
+	}
+	var fields []string
+	for _, f := range allowed {
+		if set[f] {
+			fields = append(fields, f)
+			delete(set, f)
+		}
+	}
+	if len(set) > 0 {
+		var unknown []string
+		for f := range set {
+			unknown = append(unknown, f)
+		}
+		sort.Strings(unknown)
+		h.badRequestResponse(w, "fields cannot be patched: "+strings.Join(unknown, ", "))
+		return
+	}
+
+	obj, ok := h.decode(w, bytes.NewReader(body), h.CustomLogic.Update[metrics.PATCH], metrics.PATCH)
+	if !ok {
+		return
+	}
+
+	// delegate to db
+	obj["id"] = id
+	res, err = s.PatchObject(obj, metrics.PATCH, fields, version)
+	if errors.Is(err, store.ErrVersionConflict) {
+		h.preconditionFailedResponse(w)
+		return
+	}
+	if err != nil {
+		h.metrics().DatabaseErrors.WithLabelValues(metrics.PATCH, u.TenantID).Inc()
+		panic(err)
+	}
+
+	w.Header().Set("ETag", etag(res.Version()))
+
+	err = h.applyAfterCustomLogic(w, u, res, h.CustomLogic.Update[metrics.PATCH], metrics.PATCH)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -7,43 +7,97 @@ import (
 )
 
 const (
-	CREATE = "create"
-	READ   = "read"
-	LIST   = "list"
-	DELETE = "delete"
+	CREATE  = "create"
+	READ    = "read"
+	LIST    = "list"
+	DELETE  = "delete"
+	HISTORY = "history"
+	RESTORE = "restore"
+	PATCH   = "patch"
+	PURGE   = "purge"
+	GRAPHQL = "graphql"
+	GRPC    = "grpc"
+
+	BULK_CREATE = "bulkcreate"
+	BULK_UPDATE = "bulkupdate"
+	BULK_DELETE = "bulkdelete"
+
+	// database targets
+	PRIMARY = "primary"
+	REPLICA = "replica"
 )
 
 var (
 	objectives        = map[float64]float64{0.5: 0.05, 0.75: .025, 0.9: 0.01, 0.95: .005, 0.99: 0.001}
 	customLogicLabels = []string{"method", "when"}
+	tenantLabels      = []string{"method", "tenant"}
 
-	RequestCounter = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: config.APIName,
-		Name:      "http_requests_total",
-	}, []string{"method"})
-	RequestSummary = promauto.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  config.APIName,
-		Name:       "http_request_duration_seconds",
-		Objectives: objectives,
-	}, []string{"method"})
-
-	CustomLogicSummary = promauto.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  config.APIName,
-		Name:       "custom_logic_duration_seconds",
-		Objectives: objectives,
-	}, customLogicLabels)
-	CustomLogicErrors = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: config.APIName,
-		Name:      "custom_logic_errors_total",
-	}, customLogicLabels)
-
-	DatabaseSummary = promauto.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  config.APIName,
-		Name:       "database_access_duration_seconds",
-		Objectives: objectives,
-	}, []string{"method"})
-	DatabaseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
-		Namespace: config.APIName,
-		Name:      "database_access_errors_total",
-	}, []string{"method"})
+	// Default are the metrics of the API named by API_NAME.
+	Default = New(config.APIName)
 )
+
+// Metrics are the metrics of an API, namespaced by the name of the API.
+type Metrics struct {
+	RequestCounter *prometheus.CounterVec
+	RequestSummary *prometheus.SummaryVec
+
+	CustomLogicSummary *prometheus.SummaryVec
+	CustomLogicErrors  *prometheus.CounterVec
+
+	// DatabaseSummary records the duration of store operations with an empty target, and the duration of each read
+	// routed between the primary and replicas with the target that served it.
+	DatabaseSummary *prometheus.SummaryVec
+	DatabaseErrors  *prometheus.CounterVec
+
+	// DefinitionReloadErrors counts reloads of the auth and custom logic definitions that failed, leaving the previous
+	// definitions in place.
+	DefinitionReloadErrors prometheus.Counter
+}
+
+// New registers the metrics of an API under the namespace.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		RequestCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+		}, tenantLabels),
+		RequestSummary: promauto.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  namespace,
+			Name:       "http_request_duration_seconds",
+			Objectives: objectives,
+		}, []string{"method"}),
+
+		CustomLogicSummary: promauto.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  namespace,
+			Name:       "custom_logic_duration_seconds",
+			Objectives: objectives,
+		}, customLogicLabels),
+		CustomLogicErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "custom_logic_errors_total",
+		}, customLogicLabels),
+
+		DatabaseSummary: promauto.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:  namespace,
+			Name:       "database_access_duration_seconds",
+			Objectives: objectives,
+		}, []string{"method", "target"}),
+		DatabaseErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "database_access_errors_total",
+		}, tenantLabels),
+
+		DefinitionReloadErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "definition_reload_errors_total",
+		}),
+	}
+}
+
+// Or returns m, or Default if m is nil.
+func Or(m *Metrics) *Metrics {
+	if m == nil {
+		return Default
+	}
+	return m
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"github.com/go-pg/pg"
+	"github.com/gracew/widget-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RegisterPoolStats exports the statistics of a database connection pool, labelled with the pool's target (PRIMARY or
+// REPLICA) and address. The numbers of connections are gauges, while the counts the pool accumulates since it was
+// opened are counters, so that rate() applies to them.
+func RegisterPoolStats(target string, addr string, stats func() *pg.PoolStats) {
+	labels := prometheus.Labels{"target": target, "addr": addr}
+	gauge := func(name string, value func(s *pg.PoolStats) uint32) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   config.APIName,
+			Name:        name,
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(value(stats()))
+		})
+	}
+	counter := func(name string, value func(s *pg.PoolStats) uint32) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   config.APIName,
+			Name:        name,
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(value(stats()))
+		})
+	}
+	gauge("database_pool_connections", func(s *pg.PoolStats) uint32 { return s.TotalConns })
+	gauge("database_pool_idle_connections", func(s *pg.PoolStats) uint32 { return s.IdleConns })
+	counter("database_pool_hits_total", func(s *pg.PoolStats) uint32 { return s.Hits })
+	counter("database_pool_misses_total", func(s *pg.PoolStats) uint32 { return s.Misses })
+	counter("database_pool_timeouts_total", func(s *pg.PoolStats) uint32 { return s.Timeouts })
+	counter("database_pool_stale_connections_total", func(s *pg.PoolStats) uint32 { return s.StaleConns })
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import "context"
+
+type tenantKey struct{}
+
+// TenantLabel carries the tenant of a request from the handler that authenticates it back to the request metrics,
+// which are recorded outside the handler.
+type TenantLabel struct {
+	ID string
+}
+
+// WithTenantLabel returns a copy of the context carrying the label.
+func WithTenantLabel(ctx context.Context, label *TenantLabel) context.Context {
+	return context.WithValue(ctx, tenantKey{}, label)
+}
+
+// SetTenant records the tenant on the label carried by the context, if any.
+func SetTenant(ctx context.Context, tenantID string) {
+	if label, ok := ctx.Value(tenantKey{}).(*TenantLabel); ok {
+		label.ID = tenantID
+	}
+}
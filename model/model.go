@@ -2,15 +2,66 @@
 
 package model
 
+import "time"
+
 type API struct {
 	ID         string               `json:"id"`
 	Name       string               `json:"name"`
 	Operations *OperationDefinition `json:"operations"`
+	// Fields declares the fields of the objects, which are then stored without generated.Object. If omitted, the
+	// fields are those of generated.Object.
+	Fields []FieldDefinition `json:"fields"`
+}
+
+type FieldDefinition struct {
+	Name string    `json:"name"`
+	Type FieldType `json:"type"`
+}
+
+type FieldType string
+
+const (
+	FieldTypeString    FieldType = "STRING"
+	FieldTypeInt       FieldType = "INT"
+	FieldTypeFloat     FieldType = "FLOAT"
+	FieldTypeBoolean   FieldType = "BOOLEAN"
+	FieldTypeTimestamp FieldType = "TIMESTAMP"
+)
+
+var AllFieldType = []FieldType{
+	FieldTypeString,
+	FieldTypeInt,
+	FieldTypeFloat,
+	FieldTypeBoolean,
+	FieldTypeTimestamp,
+}
+
+func (e FieldType) IsValid() bool {
+	switch e {
+	case FieldTypeString, FieldTypeInt, FieldTypeFloat, FieldTypeBoolean, FieldTypeTimestamp:
+		return true
+	}
+	return false
+}
+
+func (e FieldType) String() string {
+	return string(e)
+}
+
+// SystemFields are maintained by the server for every object whose fields are declared in the API definition.
+var SystemFields = []FieldDefinition{
+	FieldDefinition{Name: "id", Type: FieldTypeString},
+	FieldDefinition{Name: "createdBy", Type: FieldTypeString},
+	FieldDefinition{Name: "createdAt", Type: FieldTypeTimestamp},
+	FieldDefinition{Name: "version", Type: FieldTypeInt},
+	FieldDefinition{Name: "updatedAt", Type: FieldTypeTimestamp},
+	FieldDefinition{Name: "updatedBy", Type: FieldTypeString},
 }
 
 type OperationDefinition struct {
 	List   *ListDefinition   `json:"list"`
 	Update *UpdateDefinition `json:"update"`
+	Delete *DeleteDefinition `json:"delete"`
 }
 
 type ListDefinition struct {
@@ -50,6 +101,14 @@ func (e SortOrder) String() string {
 
 type UpdateDefinition struct {
 	Actions []ActionDefinition `json:"actions"`
+	// Patch enables PATCH /{id}, which updates only the fields present in the request body. If omitted, objects are
+	// only updated by actions.
+	Patch *PatchDefinition `json:"patch"`
+}
+
+type PatchDefinition struct {
+	// Fields are the fields that PATCH /{id} may update.
+	Fields []string `json:"fields"`
 }
 
 type ActionDefinition struct {
@@ -57,17 +116,38 @@ type ActionDefinition struct {
 	Fields []string `json:"fields"`
 }
 
+type DeleteDefinition struct {
+	// SoftDelete marks deleted objects with a deletion time instead of removing them, so that they can be restored.
+	SoftDelete bool `json:"softDelete"`
+	// RetentionDays is the number of days soft-deleted objects are kept before they are purged. If zero, they are kept
+	// indefinitely.
+	RetentionDays int `json:"retentionDays"`
+}
+
 type Auth struct {
 	APIID  string                 `json:"apiID"`
+	Create *AuthPolicy            `json:"create"`
 	Read   *AuthPolicy            `json:"read"`
 	Update map[string]*AuthPolicy `json:"update"`
 	Delete *AuthPolicy            `json:"delete"`
+	// Restore is the policy for restoring soft-deleted objects. If omitted, the delete policy applies.
+	Restore *AuthPolicy `json:"restore"`
+	// Fields maps object fields to the policy a user must satisfy to see the field. Fields without a policy are
+	// visible to any user allowed to read the object.
+	Fields map[string]*AuthPolicy `json:"fields"`
+	// Roles maps each role to the operations and update actions it is allowed to invoke.
+	Roles map[string][]string `json:"roles"`
 }
 
 type AuthPolicy struct {
 	Type            AuthPolicyType `json:"type"`
 	UserAttribute   *string        `json:"userAttribute"`
 	ObjectAttribute *string        `json:"objectAttribute"`
+	// Roles restricts the ROLE policy type to the listed roles. If omitted, the policy allows any role that may invoke
+	// the operation.
+	Roles []string `json:"roles"`
+	// Policies are the policies combined by the ANY_OF and ALL_OF policy types.
+	Policies []*AuthPolicy `json:"policies"`
 }
 
 type AuthPolicyType string
@@ -76,17 +156,24 @@ const (
 	AuthPolicyTypeCreatedBy      AuthPolicyType = "CREATED_BY"
 	AuthPolicyTypeAttributeMatch AuthPolicyType = "ATTRIBUTE_MATCH"
 	AuthPolicyTypeCustom         AuthPolicyType = "CUSTOM"
+	AuthPolicyTypeRole           AuthPolicyType = "ROLE"
+	AuthPolicyTypeAnyOf          AuthPolicyType = "ANY_OF"
+	AuthPolicyTypeAllOf          AuthPolicyType = "ALL_OF"
 )
 
 var AllAuthPolicyType = []AuthPolicyType{
 	AuthPolicyTypeCreatedBy,
 	AuthPolicyTypeAttributeMatch,
 	AuthPolicyTypeCustom,
+	AuthPolicyTypeRole,
+	AuthPolicyTypeAnyOf,
+	AuthPolicyTypeAllOf,
 }
 
 func (e AuthPolicyType) IsValid() bool {
 	switch e {
-	case AuthPolicyTypeCreatedBy, AuthPolicyTypeAttributeMatch, AuthPolicyTypeCustom:
+	case AuthPolicyTypeCreatedBy, AuthPolicyTypeAttributeMatch, AuthPolicyTypeCustom, AuthPolicyTypeRole,
+		AuthPolicyTypeAnyOf, AuthPolicyTypeAllOf:
 		return true
 	}
 	return false
@@ -107,3 +194,18 @@ type AllCustomLogic struct {
 	Update map[string]*CustomLogic `json:"update"`
 	Delete *CustomLogic            `json:"delete"`
 }
+
+type APIKeys struct {
+	Keys []APIKey `json:"keys"`
+}
+
+type APIKey struct {
+	// Hash is the hex-encoded SHA-256 hash of the key.
+	Hash      string   `json:"hash"`
+	Principal string   `json:"principal"`
+	Roles     []string `json:"roles"`
+	TenantID  string   `json:"tenantId"`
+	// Scopes lists the operations and update actions the key may invoke. If omitted, the key is not restricted.
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,146 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Handler serves the document as JSON. Browsers may fetch it from any origin, so that external tools can load it.
+func Handler(doc *Document) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// ExplorerHandler serves a page listing the operations of the document served next to it at openapi.json, from which
+// requests can be sent to the API. The page loads no external resources.
+func ExplorerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(explorerPage))
+}
+
+const explorerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: 0.5em 0; padding: 0.5em; }
+summary { cursor: pointer; }
+.method { display: inline-block; width: 4.5em; font-weight: bold; text-transform: uppercase; }
+.get { color: #0a6ebd; } .post { color: #2e8b57; } .delete { color: #c0392b; }
+label { display: block; margin-top: 0.5em; font-size: 0.9em; }
+input, textarea { width: 100%; box-sizing: border-box; font-family: monospace; }
+textarea { height: 8em; }
+pre { background: #f5f5f5; padding: 0.5em; overflow: auto; }
+#auth { margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h1 id="title">API explorer</h1>
+<div id="auth"></div>
+<div id="operations"></div>
+<script>
+(function () {
+  var specURL = "openapi.json";
+  var auth = {};
+
+  function el(tag, attrs, children) {
+    var e = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) { e.setAttribute(k, attrs[k]); });
+    (children || []).forEach(function (c) { e.appendChild(typeof c === "string" ? document.createTextNode(c) : c); });
+    return e;
+  }
+
+  function resolve(spec, obj) {
+    if (!obj || !obj.$ref) { return obj; }
+    return obj.$ref.replace(/^#\//, "").split("/").reduce(function (o, k) { return o[k]; }, spec);
+  }
+
+  function example(spec, schema) {
+    schema = resolve(spec, schema);
+    var body = {};
+    Object.keys(schema.properties || {}).sort().forEach(function (name) {
+      var p = schema.properties[name];
+      body[name] = { integer: 0, number: 0, boolean: false }[p.type];
+      if (body[name] === undefined) { body[name] = p.format === "date-time" ? new Date().toISOString() : ""; }
+    });
+    return JSON.stringify(body, null, 2);
+  }
+
+  function operation(spec, base, path, method, op) {
+    var params = (op.parameters || []).map(function (p) { return resolve(spec, p); });
+    var inputs = {};
+    var form = el("div");
+    params.forEach(function (p) {
+      inputs[p.name] = el("input", { placeholder: p.description || "" });
+      form.appendChild(el("label", {}, [p.name + " (" + p["in"] + (p.required ? ", required" : "") + ")", inputs[p.name]]));
+    });
+    var body;
+    if (op.requestBody) {
+      body = el("textarea");
+      body.value = example(spec, op.requestBody.content["application/json"].schema);
+      form.appendChild(el("label", {}, ["request body", body]));
+    }
+    var output = el("pre");
+    var send = el("button", {}, ["Send"]);
+    send.onclick = function () {
+      var url = path, query = [], headers = {};
+      params.forEach(function (p) {
+        var v = inputs[p.name].value;
+        if (p["in"] === "path") { url = url.replace("{" + p.name + "}", encodeURIComponent(v)); }
+        if (v === "") { return; }
+        if (p["in"] === "query") { query.push(encodeURIComponent(p.name) + "=" + encodeURIComponent(v)); }
+        if (p["in"] === "header") { headers[p.name] = v; }
+      });
+      Object.keys(auth).forEach(function (h) { if (auth[h].value) { headers[h] = auth[h].value; } });
+      if (body) { headers["Content-Type"] = "application/json"; }
+      url = base + url + (query.length ? "?" + query.join("&") : "");
+      fetch(url, { method: method.toUpperCase(), headers: headers, body: body ? body.value : undefined })
+        .then(function (res) {
+          return res.text().then(function (text) {
+            output.textContent = method.toUpperCase() + " " + url + "\n" + res.status + " " + res.statusText +
+              (res.headers.get("ETag") ? "\nETag: " + res.headers.get("ETag") : "") + "\n\n" + text;
+          });
+        })
+        .catch(function (err) { output.textContent = String(err); });
+    };
+    form.appendChild(send);
+    form.appendChild(output);
+    var summary = el("summary", {}, [el("span", { "class": "method " + method }, [method]), path + " ", el("em", {}, [op.summary])]);
+    var children = [summary];
+    if (op.description) { children.push(el("p", {}, [op.description])); }
+    children.push(form);
+    return el("details", {}, children);
+  }
+
+  fetch(specURL).then(function (res) { return res.json(); }).then(function (spec) {
+    document.title = spec.info.title;
+    document.getElementById("title").textContent = spec.info.title;
+    var base = (spec.servers && spec.servers.length) ? spec.servers[0].url : "";
+    var authDiv = document.getElementById("auth");
+    Object.keys(spec.components.securitySchemes).sort().forEach(function (name) {
+      var scheme = spec.components.securitySchemes[name];
+      auth[scheme.name] = el("input", { placeholder: scheme.description });
+      authDiv.appendChild(el("label", {}, [scheme.name, auth[scheme.name]]));
+    });
+    var ops = document.getElementById("operations");
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      ["post", "get", "delete"].forEach(function (method) {
+        var op = spec.paths[path][method];
+        if (op) { ops.appendChild(operation(spec, base, path, method, op)); }
+      });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,492 @@
+// Package openapi describes the routes the server registers for an API definition as an OpenAPI 3 document.
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gracew/widget-proxy/generated"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/user"
+)
+
+// Version is the version of the OpenAPI specification the documents conform to.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document, limited to the parts the server describes.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	// Ref refers to a parameter in the components, in which case the other fields are empty.
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	// Ref refers to a response in the components, in which case the other fields are empty.
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	// Ref refers to a schema in the components, in which case the other fields are empty.
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	Parameters      map[string]*Parameter      `json:"parameters"`
+	Responses       map[string]*Response       `json:"responses"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// schemaTypes are the JSON schema types and formats of the field types.
+var schemaTypes = map[model.FieldType]Schema{
+	model.FieldTypeString:    Schema{Type: "string"},
+	model.FieldTypeInt:       Schema{Type: "integer", Format: "int64"},
+	model.FieldTypeFloat:     Schema{Type: "number", Format: "double"},
+	model.FieldTypeBoolean:   Schema{Type: "boolean"},
+	model.FieldTypeTimestamp: Schema{Type: "string", Format: "date-time"},
+}
+
+// Fields returns the fields of the objects of an API: the system fields and those declared in the API definition, or
+// else the fields of generated.Object.
+func Fields(api model.API) []model.FieldDefinition {
+	if len(api.Fields) > 0 {
+		return append(append([]model.FieldDefinition{}, model.SystemFields...), api.Fields...)
+	}
+
+	system := map[string]model.FieldType{}
+	for _, f := range model.SystemFields {
+		system[f.Name] = f.Type
+	}
+	var fields []model.FieldDefinition
+	t := reflect.TypeOf(generated.Object{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldType, ok := system[name]
+		if !ok {
+			fieldType = goFieldType(t.Field(i).Type)
+		}
+		fields = append(fields, model.FieldDefinition{Name: name, Type: fieldType})
+	}
+	return fields
+}
+
+// goFieldType returns the field type of a field of generated.Object.
+func goFieldType(t reflect.Type) model.FieldType {
+	switch t.Kind() {
+	case reflect.Bool:
+		return model.FieldTypeBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return model.FieldTypeInt
+	case reflect.Float32, reflect.Float64:
+		return model.FieldTypeFloat
+	}
+	return model.FieldTypeString
+}
+
+// New returns the document describing the routes the server registers for the API. name is the name the API is
+// hosted under, or empty if the server hosts a single API at the root.
+func New(api model.API, name string) *Document {
+	fields := Fields(api)
+	system := map[string]bool{}
+	for _, f := range model.SystemFields {
+		system[f.Name] = true
+	}
+	// objects stored as generated.Object ignore unknown fields, while declared fields are checked
+	declared := len(api.Fields) > 0
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title(api, name), Version: "1"},
+		Paths:   map[string]*PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{"Error": errorSchema()},
+			Parameters:      parameters(),
+			Responses:       responses(),
+			SecuritySchemes: securitySchemes(),
+		},
+		Security: []map[string][]string{{"sessionToken": {}}, {"apiKey": {}}},
+	}
+	if name != "" {
+		doc.Servers = []Server{{URL: "/" + name}}
+	}
+
+	object := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	input := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: boolPtr(!declared)}
+	for _, f := range fields {
+		object.Properties[f.Name] = fieldSchema(f.Type, system[f.Name])
+		if !system[f.Name] {
+			input.Properties[f.Name] = fieldSchema(f.Type, false)
+		}
+	}
+	doc.Components.Schemas["Object"] = object
+	doc.Components.Schemas["ObjectInput"] = input
+
+	doc.Paths["/"] = &PathItem{
+		Post: &Operation{
+			OperationID: "create",
+			Summary:     "Create an object",
+			RequestBody: jsonBody(ref("ObjectInput")),
+			Responses: map[string]*Response{
+				"200": objectResponse("The created object.", false),
+				"400": responseRef("BadRequest"),
+				"401": responseRef("Unauthenticated"),
+				"403": responseRef("Unauthorized"),
+			},
+		},
+		Get: listOperation(api, fields),
+	}
+	doc.Paths["/{id}"] = &PathItem{
+		Get: &Operation{
+			OperationID: "read",
+			Summary:     "Read an object",
+			Parameters:  []*Parameter{parameterRef("id"), parameterRef("consistency")},
+			Responses: map[string]*Response{
+				"200": objectResponse("The object.", true),
+				"401": responseRef("Unauthenticated"),
+				"403": responseRef("Unauthorized"),
+				"404": responseRef("NotFound"),
+			},
+		},
+		Delete: &Operation{
+			OperationID: "delete",
+			Summary:     "Delete an object",
+			Description: "Responds with the deleted object instead if after custom logic is defined for delete.",
+			Parameters:  []*Parameter{parameterRef("id"), parameterRef("ifMatch")},
+			Responses: map[string]*Response{
+				"204": &Response{Description: "The object was deleted."},
+				"401": responseRef("Unauthenticated"),
+				"403": responseRef("Unauthorized"),
+				"404": responseRef("NotFound"),
+				"412": responseRef("PreconditionFailed"),
+			},
+		},
+	}
+
+	ops := api.Operations
+	if ops != nil && ops.Delete != nil && ops.Delete.SoftDelete {
+		doc.Paths["/{id}/restore"] = &PathItem{Post: &Operation{
+			OperationID: "restore",
+			Summary:     "Restore a deleted object",
+			Parameters:  []*Parameter{parameterRef("id")},
+			Responses: map[string]*Response{
+				"200": objectResponse("The restored object.", true),
+				"401": responseRef("Unauthenticated"),
+				"403": responseRef("Unauthorized"),
+				"404": responseRef("NotFound"),
+			},
+		}}
+	}
+	if ops != nil && ops.Update != nil {
+		types := map[string]model.FieldType{}
+		for _, f := range fields {
+			types[f.Name] = f.Type
+		}
+		for _, action := range ops.Update.Actions {
+			schemaName := "ActionInput_" + action.Name
+			body := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: boolPtr(!declared)}
+			for _, f := range action.Fields {
+				// system fields are set by the store, even if the action lists them
+				if !system[f] {
+					body.Properties[f] = fieldSchema(types[f], false)
+				}
+			}
+			doc.Components.Schemas[schemaName] = body
+			doc.Paths["/{id}/"+action.Name] = &PathItem{Post: &Operation{
+				OperationID: "update_" + action.Name,
+				Summary:     fmt.Sprintf("Apply the %s action to an object", action.Name),
+				Description: "Only the fields of the action are updated, or only those present in the body of a merge patch.",
+				Parameters:  []*Parameter{parameterRef("id"), parameterRef("ifMatch")},
+				RequestBody: mergePatchBody(ref(schemaName), true),
+				Responses: map[string]*Response{
+					"200": objectResponse("The updated object.", true),
+					"400": responseRef("BadRequest"),
+					"401": responseRef("Unauthenticated"),
+					"403": responseRef("Unauthorized"),
+					"404": responseRef("NotFound"),
+					"412": responseRef("PreconditionFailed"),
+				},
+			}}
+		}
+		if patch := ops.Update.Patch; patch != nil {
+			body := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: boolPtr(false)}
+			for _, f := range patch.Fields {
+				body.Properties[f] = fieldSchema(types[f], false)
+			}
+			doc.Components.Schemas["PatchInput"] = body
+			doc.Paths["/{id}"].Patch = &Operation{
+				OperationID: "patch",
+				Summary:     "Patch an object",
+				Description: "Only the fields present in the body are updated.",
+				Parameters:  []*Parameter{parameterRef("id"), parameterRef("ifMatch")},
+				RequestBody: mergePatchBody(ref("PatchInput"), false),
+				Responses: map[string]*Response{
+					"200": objectResponse("The updated object.", true),
+					"400": responseRef("BadRequest"),
+					"401": responseRef("Unauthenticated"),
+					"403": responseRef("Unauthorized"),
+					"404": responseRef("NotFound"),
+					"412": responseRef("PreconditionFailed"),
+				},
+			}
+		}
+	}
+	return doc
+}
+
+// listOperation describes the list route, with a query parameter for each declared filter.
+func listOperation(api model.API, fields []model.FieldDefinition) *Operation {
+	op := &Operation{
+		OperationID: "list",
+		Summary:     "List objects",
+		Description: "Objects are ordered by createdAt descending.",
+		Parameters: []*Parameter{
+			&Parameter{
+				Name:        "pageSize",
+				In:          "query",
+				Description: "The maximum number of objects to return.",
+				Schema:      &Schema{Type: "integer", Format: "int32"},
+			},
+			parameterRef("consistency"),
+		},
+		Responses: map[string]*Response{
+			"200": &Response{
+				Description: "The objects the user may read.",
+				Content:     map[string]*MediaType{"application/json": {Schema: &Schema{Type: "array", Items: ref("Object")}}},
+			},
+			"400": responseRef("BadRequest"),
+			"401": responseRef("Unauthenticated"),
+			"403": responseRef("Unauthorized"),
+		},
+	}
+	list := api.Operations
+	if list == nil || list.List == nil {
+		return op
+	}
+
+	if len(list.List.Sort) > 0 {
+		var orders []string
+		for _, s := range list.List.Sort {
+			orders = append(orders, fmt.Sprintf("%s %s", s.Field, strings.ToLower(string(s.Order))))
+		}
+		op.Description = fmt.Sprintf("Objects are ordered by %s.", strings.Join(orders, ", then "))
+	}
+	types := map[string]model.FieldType{}
+	for _, f := range fields {
+		types[f.Name] = f.Type
+	}
+	filters := append([]string{}, list.List.Filter...)
+	sort.Strings(filters)
+	for _, f := range filters {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        f,
+			In:          "query",
+			Description: fmt.Sprintf("Only list objects whose %s equals the value. At most one filter applies.", f),
+			Schema:      fieldSchema(types[f], false),
+		})
+	}
+	return op
+}
+
+func title(api model.API, name string) string {
+	switch {
+	case api.Name != "":
+		return api.Name
+	case name != "":
+		return name
+	case api.ID != "":
+		return api.ID
+	}
+	return "widget-proxy"
+}
+
+func fieldSchema(fieldType model.FieldType, readOnly bool) *Schema {
+	s := schemaTypes[fieldType]
+	if s.Type == "" {
+		s = schemaTypes[model.FieldTypeString]
+	}
+	s.ReadOnly = readOnly
+	return &s
+}
+
+func errorSchema() *Schema {
+	return &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{"message": &Schema{Type: "string"}},
+		Required:   []string{"message"},
+	}
+}
+
+func parameters() map[string]*Parameter {
+	return map[string]*Parameter{
+		"id": &Parameter{
+			Name:     "id",
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		},
+		"ifMatch": &Parameter{
+			Name:        "If-Match",
+			In:          "header",
+			Description: "The ETag of the version of the object the change applies to. If omitted, any version is changed.",
+			Schema:      &Schema{Type: "string"},
+		},
+		"consistency": &Parameter{
+			Name:        "consistency",
+			In:          "query",
+			Description: "Set to strong to read from the primary database rather than a replica.",
+			Schema:      &Schema{Type: "string", Enum: []string{"strong"}},
+		},
+	}
+}
+
+func responses() map[string]*Response {
+	errorResponse := func(description string) *Response {
+		return &Response{
+			Description: description,
+			Content:     map[string]*MediaType{"application/json": {Schema: ref("Error")}},
+		}
+	}
+	return map[string]*Response{
+		"BadRequest":         errorResponse("The request is invalid."),
+		"Unauthenticated":    errorResponse("The request carries no valid credentials."),
+		"Unauthorized":       errorResponse("The user may not perform the operation."),
+		"NotFound":           errorResponse("The object does not exist."),
+		"PreconditionFailed": errorResponse("The object has been modified since the version in If-Match."),
+	}
+}
+
+func securitySchemes() map[string]*SecurityScheme {
+	return map[string]*SecurityScheme{
+		"sessionToken": &SecurityScheme{
+			Type:        "apiKey",
+			In:          "header",
+			Name:        "X-Parse-Session-Token",
+			Description: "A Parse session token.",
+		},
+		"apiKey": &SecurityScheme{
+			Type:        "apiKey",
+			In:          "header",
+			Name:        user.APIKeyHeader,
+			Description: "An API key configured on the server.",
+		},
+	}
+}
+
+// objectResponse is a response with an object, and its version in the ETag header if withETag is set.
+func objectResponse(description string, withETag bool) *Response {
+	res := &Response{
+		Description: description,
+		Content:     map[string]*MediaType{"application/json": {Schema: ref("Object")}},
+	}
+	if withETag {
+		res.Headers = map[string]*Header{
+			"ETag": &Header{Description: "The version of the object.", Schema: &Schema{Type: "string"}},
+		}
+	}
+	return res
+}
+
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]*MediaType{"application/json": {Schema: schema}}}
+}
+
+// mergePatchBody is a JSON Merge Patch request body, which may also be sent as plain JSON if json is set.
+func mergePatchBody(schema *Schema, json bool) *RequestBody {
+	body := &RequestBody{Required: true, Content: map[string]*MediaType{"application/merge-patch+json": {Schema: schema}}}
+	if json {
+		body.Content["application/json"] = &MediaType{Schema: schema}
+	}
+	return body
+}
+
+func ref(schema string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + schema}
+}
+
+func parameterRef(name string) *Parameter {
+	return &Parameter{Ref: "#/components/parameters/" + name}
+}
+
+func responseRef(name string) *Response {
+	return &Response{Ref: "#/components/responses/" + name}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
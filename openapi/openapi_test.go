@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var books = model.API{
+	Name: "books",
+	Fields: []model.FieldDefinition{
+		model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+		model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+		model.FieldDefinition{Name: "published", Type: model.FieldTypeBoolean},
+	},
+	Operations: &model.OperationDefinition{
+		List: &model.ListDefinition{
+			Filter: []string{"published"},
+			Sort:   []model.SortDefinition{model.SortDefinition{Field: "title", Order: model.SortOrderAsc}},
+		},
+		Update: &model.UpdateDefinition{Actions: []model.ActionDefinition{
+			model.ActionDefinition{Name: "publish", Fields: []string{"published", "version"}},
+		}},
+		Delete: &model.DeleteDefinition{SoftDelete: true},
+	},
+}
+
+func TestNew(t *testing.T) {
+	doc := New(books, "library")
+	assert.Equal(t, "books", doc.Info.Title)
+	assert.Equal(t, []Server{{URL: "/library"}}, doc.Servers)
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	assert.ElementsMatch(t, []string{"/", "/{id}", "/{id}/restore", "/{id}/publish"}, paths)
+	assert.Equal(t, "create", doc.Paths["/"].Post.OperationID)
+	assert.Equal(t, "list", doc.Paths["/"].Get.OperationID)
+	assert.Equal(t, "read", doc.Paths["/{id}"].Get.OperationID)
+	assert.Equal(t, "delete", doc.Paths["/{id}"].Delete.OperationID)
+	assert.Equal(t, "restore", doc.Paths["/{id}/restore"].Post.OperationID)
+	assert.Equal(t, "update_publish", doc.Paths["/{id}/publish"].Post.OperationID)
+
+	object := doc.Components.Schemas["Object"]
+	assert.Equal(t, &Schema{Type: "integer", Format: "int64"}, object.Properties["pageCount"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time", ReadOnly: true}, object.Properties["createdAt"])
+	input := doc.Components.Schemas["ObjectInput"]
+	assert.Len(t, input.Properties, 3)
+	assert.Equal(t, false, *input.AdditionalProperties)
+
+	// the version is maintained by the server, even though the action lists it
+	action := doc.Components.Schemas["ActionInput_publish"]
+	assert.Equal(t, map[string]*Schema{"published": &Schema{Type: "boolean"}}, action.Properties)
+	assert.Equal(t, "#/components/schemas/ActionInput_publish",
+		doc.Paths["/{id}/publish"].Post.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Contains(t, doc.Paths["/{id}/publish"].Post.RequestBody.Content, "application/merge-patch+json")
+	assert.Equal(t, "#/components/responses/PreconditionFailed", doc.Paths["/{id}/publish"].Post.Responses["412"].Ref)
+
+	list := doc.Paths["/"].Get
+	assert.Equal(t, "Objects are ordered by title asc.", list.Description)
+	filter := list.Parameters[len(list.Parameters)-1]
+	assert.Equal(t, "published", filter.Name)
+	assert.Equal(t, "query", filter.In)
+	assert.Equal(t, &Schema{Type: "boolean"}, filter.Schema)
+
+	assert.Equal(t, "X-Parse-Session-Token", doc.Components.SecuritySchemes["sessionToken"].Name)
+	assert.Equal(t, "X-Api-Key", doc.Components.SecuritySchemes["apiKey"].Name)
+}
+
+func TestNewGeneratedObject(t *testing.T) {
+	doc := New(model.API{}, "")
+	assert.Equal(t, "widget-proxy", doc.Info.Title)
+	assert.Empty(t, doc.Servers)
+	assert.Len(t, doc.Paths, 2)
+
+	object := doc.Components.Schemas["Object"]
+	assert.Equal(t, &Schema{Type: "string"}, object.Properties["test"])
+	assert.Equal(t, &Schema{Type: "integer", Format: "int64", ReadOnly: true}, object.Properties["version"])
+	assert.NotContains(t, object.Properties, "tenantId")
+	input := doc.Components.Schemas["ObjectInput"]
+	assert.Equal(t, map[string]*Schema{"test": &Schema{Type: "string"}}, input.Properties)
+	assert.Equal(t, true, *input.AdditionalProperties)
+	assert.Equal(t, "Objects are ordered by createdAt descending.", doc.Paths["/"].Get.Description)
+	assert.Nil(t, doc.Paths["/{id}"].Patch)
+}
+
+func TestNewPatch(t *testing.T) {
+	doc := New(model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{
+		Actions: []model.ActionDefinition{model.ActionDefinition{Name: "rename", Fields: []string{"test"}}},
+		Patch:   &model.PatchDefinition{Fields: []string{"test"}},
+	}}}, "")
+
+	patch := doc.Paths["/{id}"].Patch
+	assert.Equal(t, "patch", patch.OperationID)
+	assert.Equal(t, "#/components/schemas/PatchInput", patch.RequestBody.Content["application/merge-patch+json"].Schema.Ref)
+	assert.NotContains(t, patch.RequestBody.Content, "application/json")
+	input := doc.Components.Schemas["PatchInput"]
+	assert.Equal(t, map[string]*Schema{"test": &Schema{Type: "string"}}, input.Properties)
+	assert.Equal(t, false, *input.AdditionalProperties)
+
+	// actions accept both their usual body and a merge patch
+	action := doc.Paths["/{id}/rename"].Post.RequestBody.Content
+	assert.Contains(t, action, "application/json")
+	assert.Contains(t, action, "application/merge-patch+json")
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler(New(books, ""))(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, Version, doc["openapi"])
+
+	w = httptest.NewRecorder()
+	ExplorerHandler(w, httptest.NewRequest("GET", "/docs", nil))
+	assert.True(t, strings.Contains(w.Body.String(), `"openapi.json"`))
+}
@@ -0,0 +1,90 @@
+package protoapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Handler serves the source of the file, from which clients can generate code for the service.
+func Handler(fd protoreflect.FileDescriptor) http.HandlerFunc {
+	body := []byte(Source(fd))
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(body)
+	}
+}
+
+// Source returns the file in the protobuf language. It supports the messages, map fields and unary methods that New
+// describes.
+func Source(fd protoreflect.FileDescriptor) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "syntax = %q;\n\npackage %s;\n", fd.Syntax(), fd.Package())
+	for i := 0; i < fd.Services().Len(); i++ {
+		service := fd.Services().Get(i)
+		fmt.Fprintf(&b, "\nservice %s {\n", service.Name())
+		for j := 0; j < service.Methods().Len(); j++ {
+			m := service.Methods().Get(j)
+			fmt.Fprintf(&b, "  rpc %s(%s) returns (%s);\n", m.Name(), m.Input().Name(), m.Output().Name())
+		}
+		b.WriteString("}\n")
+	}
+	for i := 0; i < fd.Messages().Len(); i++ {
+		writeMessage(&b, fd.Messages().Get(i), "")
+	}
+	return b.String()
+}
+
+func writeMessage(b *strings.Builder, m protoreflect.MessageDescriptor, indent string) {
+	fmt.Fprintf(b, "\n%smessage %s {\n", indent, m.Name())
+	for i := 0; i < m.Messages().Len(); i++ {
+		if nested := m.Messages().Get(i); !nested.IsMapEntry() {
+			writeMessage(b, nested, indent+"  ")
+		}
+	}
+	for i := 0; i < m.Fields().Len(); i++ {
+		f := m.Fields().Get(i)
+		fmt.Fprintf(b, "%s  %s %s = %d", indent, fieldTypeName(f), f.Name(), f.Number())
+		if f.JSONName() != defaultJSONName(string(f.Name())) {
+			fmt.Fprintf(b, " [json_name = %q]", f.JSONName())
+		}
+		b.WriteString(";\n")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// fieldTypeName returns the type of the field as it is declared, including its label.
+func fieldTypeName(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", fieldTypeName(f.MapKey()), fieldTypeName(f.MapValue()))
+	}
+	name := f.Kind().String()
+	if f.Message() != nil {
+		name = string(f.Message().Name())
+	}
+	if f.IsList() {
+		return "repeated " + name
+	}
+	return name
+}
+
+// defaultJSONName returns the JSON name protoc gives a field that does not declare one: its name in lower camel case.
+func defaultJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+// Package protoapi describes the gRPC service the server registers for an API definition as a protobuf file.
+package protoapi
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/openapi"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ServiceName is the name of the service of every API, within the package of the API.
+const ServiceName = "Objects"
+
+// The methods of the service besides those of the update actions.
+const (
+	Create = "Create"
+	Get    = "Get"
+	List   = "List"
+	Delete = "Delete"
+)
+
+// methodNamePattern matches the names protobuf allows, starting with an upper case letter as methods conventionally do.
+var methodNamePattern = regexp.MustCompile(`^[A-Z][_a-zA-Z0-9]*$`)
+
+// fieldTypes are the protobuf types of the field types. Timestamps are RFC 3339 strings, as are fields of unknown type.
+var fieldTypes = map[model.FieldType]descriptorpb.FieldDescriptorProto_Type{
+	model.FieldTypeString:    descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	model.FieldTypeInt:       descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	model.FieldTypeFloat:     descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	model.FieldTypeBoolean:   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	model.FieldTypeTimestamp: descriptorpb.FieldDescriptorProto_TYPE_STRING,
+}
+
+// Package returns the protobuf package of the service of an API. name is the name the API is hosted under, or empty
+// if the server hosts a single API at the root.
+func Package(name string) string {
+	if name == "" {
+		return "widgetproxy"
+	}
+	return "widgetproxy." + name
+}
+
+// MethodName returns the name of the method of an update action: the action name, with its first letter and each
+// letter or digit following a character that is not a letter or digit upper-cased in its place.
+func MethodName(action string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range action {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// New returns the file describing the service of the API. The Object message has a field for each field of the API
+// (see openapi.Fields), numbered in their order, so fields may be added to the end of the API definition without
+// breaking clients. Create, Get, List and Delete mirror the REST operations, and each update action has a method of its
+// own, named by MethodName, whose request holds the fields of the action. name is the name the API is hosted under, or
+// empty if the server hosts a single API at the root.
+func New(api model.API, name string) (protoreflect.FileDescriptor, error) {
+	system := map[string]bool{}
+	for _, f := range model.SystemFields {
+		system[f.Name] = true
+	}
+	types := map[string]model.FieldType{}
+	object := message("Object")
+	for i, f := range openapi.Fields(api) {
+		types[f.Name] = f.Type
+		object.Field = append(object.Field, field(f.Name, int32(i+1), fieldType(f.Type)))
+	}
+
+	pkg := Package(name)
+	typeName := func(message string) string {
+		return "." + pkg + "." + message
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(strings.ReplaceAll(pkg, ".", "/") + "/objects.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			object,
+			message("CreateRequest", messageField("object", 1, typeName("Object"))),
+			message("GetRequest", field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+			listRequest(typeName("ListRequest.FilterEntry")),
+			message("ListResponse", repeated(messageField("objects", 1, typeName("Object")))),
+			versionedRequest("DeleteRequest"),
+		},
+	}
+	method := func(name string, input string, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(typeName(input)),
+			OutputType: proto.String(typeName(output)),
+		}
+	}
+	service := &descriptorpb.ServiceDescriptorProto{
+		Name: proto.String(ServiceName),
+		Method: []*descriptorpb.MethodDescriptorProto{
+			method(Create, "CreateRequest", "Object"),
+			method(Get, "GetRequest", "Object"),
+			method(List, "ListRequest", "ListResponse"),
+		},
+	}
+
+	methods := map[string]string{Create: "", Get: "", List: "", Delete: ""}
+	if api.Operations != nil && api.Operations.Update != nil {
+		for _, action := range api.Operations.Update.Actions {
+			methodName := MethodName(action.Name)
+			if !methodNamePattern.MatchString(methodName) {
+				return nil, errors.Errorf("action %q has no valid gRPC method name", action.Name)
+			}
+			if other, ok := methods[methodName]; ok {
+				if other == "" {
+					return nil, errors.Errorf("action %q has the gRPC name of the %s method", action.Name, methodName)
+				}
+				return nil, errors.Errorf("actions %q and %q have the same gRPC method name %s", other, action.Name, methodName)
+			}
+			methods[methodName] = action.Name
+
+			request := versionedRequest(methodName + "Request")
+			for _, f := range action.Fields {
+				// system fields are set by the store, even if the action lists them
+				if !system[f] {
+					number := int32(len(request.Field) + 1)
+					request.Field = append(request.Field, field(f, number, fieldType(types[f])))
+				}
+			}
+			file.MessageType = append(file.MessageType, request)
+			service.Method = append(service.Method, method(methodName, methodName+"Request", "Object"))
+		}
+	}
+	service.Method = append(service.Method, method(Delete, "DeleteRequest", "Object"))
+	file.Service = []*descriptorpb.ServiceDescriptorProto{service}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build gRPC service")
+	}
+	return fd, nil
+}
+
+// listRequest returns the request of the List method, whose filter maps a field to the value listed objects have.
+// entryType is the full name of the map entry of the filter.
+func listRequest(entryType string) *descriptorpb.DescriptorProto {
+	entry := message("FilterEntry",
+		field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING))
+	entry.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+	res := message("ListRequest",
+		field("pageSize", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+		repeated(messageField("filter", 2, entryType)))
+	res.NestedType = []*descriptorpb.DescriptorProto{entry}
+	return res
+}
+
+// versionedRequest returns a request naming an object and the version it is expected to have, or 0 for any version.
+func versionedRequest(name string) *descriptorpb.DescriptorProto {
+	return message(name,
+		field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("version", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64))
+}
+
+func fieldType(t model.FieldType) descriptorpb.FieldDescriptorProto_Type {
+	if res, ok := fieldTypes[t]; ok {
+		return res
+	}
+	return descriptorpb.FieldDescriptorProto_TYPE_STRING
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+// field returns a scalar field. Its name is the snake case of the JSON name, as protobuf names conventionally are,
+// while the JSON name is kept, so that the fields of messages and objects have the same JSON names.
+func field(jsonName string, number int32, t descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(snakeCase(jsonName)),
+		JsonName: proto.String(jsonName),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     t.Enum(),
+	}
+}
+
+// messageField returns a field of the message with the full name typeName.
+func messageField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := field(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	f.TypeName = proto.String(typeName)
+	return f
+}
+
+func repeated(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
+
+// snakeCase returns the name with each upper case letter replaced by an underscore and its lower case.
+func snakeCase(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			b.WriteRune('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package protoapi
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var books = model.API{
+	Name: "books",
+	Fields: []model.FieldDefinition{
+		model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+		model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+		model.FieldDefinition{Name: "published", Type: model.FieldTypeBoolean},
+	},
+	Operations: &model.OperationDefinition{
+		Update: &model.UpdateDefinition{Actions: []model.ActionDefinition{
+			model.ActionDefinition{Name: "mark-published", Fields: []string{"published", "version"}},
+		}},
+	},
+}
+
+func TestNew(t *testing.T) {
+	fd, err := New(books, "library")
+	assert.NoError(t, err)
+	assert.Equal(t, protoreflect.FullName("widgetproxy.library"), fd.Package())
+
+	service := fd.Services().ByName(ServiceName)
+	var methods []string
+	for i := 0; i < service.Methods().Len(); i++ {
+		methods = append(methods, string(service.Methods().Get(i).Name()))
+	}
+	assert.Equal(t, []string{"Create", "Get", "List", "MarkPublished", "Delete"}, methods)
+
+	object := fd.Messages().ByName("Object")
+	pageCount := object.Fields().ByName("page_count")
+	assert.Equal(t, protoreflect.Int64Kind, pageCount.Kind())
+	assert.Equal(t, "pageCount", pageCount.JSONName())
+	assert.Equal(t, protoreflect.FieldNumber(8), pageCount.Number())
+	assert.Equal(t, protoreflect.StringKind, object.Fields().ByName("created_at").Kind())
+
+	// the version is maintained by the server, even though the action lists it
+	request := service.Methods().ByName("MarkPublished").Input()
+	assert.Equal(t, 3, request.Fields().Len())
+	assert.Equal(t, protoreflect.BoolKind, request.Fields().ByNumber(3).Kind())
+	assert.Equal(t, protoreflect.Name("published"), request.Fields().ByNumber(3).Name())
+}
+
+func TestNewGeneratedObject(t *testing.T) {
+	fd, err := New(model.API{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, protoreflect.FullName("widgetproxy"), fd.Package())
+	assert.NotNil(t, fd.Messages().ByName("Object").Fields().ByName("test"))
+	assert.Equal(t, 4, fd.Services().ByName(ServiceName).Methods().Len())
+}
+
+func TestNewInvalidAction(t *testing.T) {
+	api := model.API{Operations: &model.OperationDefinition{Update: &model.UpdateDefinition{Actions: []model.ActionDefinition{
+		model.ActionDefinition{Name: "get"},
+	}}}}
+	_, err := New(api, "")
+	assert.EqualError(t, err, `action "get" has the gRPC name of the Get method`)
+
+	api.Operations.Update.Actions = []model.ActionDefinition{
+		model.ActionDefinition{Name: "mark-read"},
+		model.ActionDefinition{Name: "mark.read"},
+	}
+	_, err = New(api, "")
+	assert.EqualError(t, err, `actions "mark-read" and "mark.read" have the same gRPC method name MarkRead`)
+
+	api.Operations.Update.Actions = []model.ActionDefinition{model.ActionDefinition{Name: "1st"}}
+	_, err = New(api, "")
+	assert.EqualError(t, err, `action "1st" has no valid gRPC method name`)
+}
+
+func TestMethodName(t *testing.T) {
+	assert.Equal(t, "MarkRead", MethodName("mark-read"))
+	assert.Equal(t, "Mark_read", MethodName("mark_read"))
+	assert.Equal(t, "Archive", MethodName("archive"))
+}
+
+func TestHandler(t *testing.T) {
+	fd, err := New(books, "")
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	Handler(fd)(rr, httptest.NewRequest("GET", "/objects.proto", nil))
+
+	assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "package widgetproxy;\n")
+	assert.Contains(t, rr.Body.String(), "  rpc MarkPublished(MarkPublishedRequest) returns (Object);\n")
+	assert.Contains(t, rr.Body.String(), "message Object {\n  string id = 1;\n")
+	assert.Contains(t, rr.Body.String(), "  int64 page_count = 8;\n")
+	assert.Contains(t, rr.Body.String(), "  map<string, string> filter = 2;\n")
+}
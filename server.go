@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-pg/pg"
@@ -11,72 +22,392 @@ import (
 	"github.com/gracew/widget-proxy/config"
 	"github.com/gracew/widget-proxy/handlers"
 	"github.com/gracew/widget-proxy/metrics"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/gracew/widget-proxy/openapi"
+	"github.com/gracew/widget-proxy/protoapi"
 	"github.com/gracew/widget-proxy/store"
 	"github.com/gracew/widget-proxy/user"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
-const defaultPort = "8080"
+const (
+	purgeInterval = time.Hour
+	// bounds of the backoff between attempts to reach the database at startup
+	minConnectBackoff = 250 * time.Millisecond
+	maxConnectBackoff = 8 * time.Second
+)
+
+var (
+	migrateDryRun = flag.Bool("migrate-dry-run", false, "print the planned schema migrations and exit")
+	printConfig   = flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	openAPIOut    = flag.String("openapi-out", "", "write the OpenAPI document of the API to the file, or of each API to "+
+		"{name}.json in the directory if several APIs are hosted, and exit")
+	configFlags = config.RegisterFlags(flag.CommandLine)
+)
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	flag.Parse()
+	cfg, err := config.Load(configFlags, os.Getenv)
+	if err != nil {
+		panic(err)
+	}
+	if *printConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			panic(err)
+		}
+		return
 	}
 
-	api, err := config.API(config.APIPath)
-	if err != nil || api == nil {
-		panic("could not read API file")
+	// SQLite tables are only extended with the columns they lack on startup, without planned migrations
+	if *migrateDryRun && cfg.SQLitePath != "" {
+		log.Fatal("--migrate-dry-run requires Postgres, and cannot be used with sqlite-path")
 	}
-	db := pg.Connect(&pg.Options{User: "postgres", Addr: config.PostgresAddress})
-	defer db.Close()
-	s := store.InstrumentedStore{Delegate: store.PgStore{DB: db, API: *api}}
-	s.CreateSchema()
 
-	customLogic, err := config.CustomLogic(config.CustomLogicPath)
+	apis, err := cfg.APIs()
 	if err != nil {
-		panic("could not read custom logic file")
+		log.Fatal(err)
 	}
-	auth, err := config.Auth(config.AuthPath)
-	if err != nil {
-		panic("could not read auth file")
+	if *openAPIOut != "" {
+		err = writeOpenAPI(*openAPIOut, apis)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// the database and its connection pools are shared by every API
+	var sqliteDB *sql.DB
+	var db *pg.DB
+	var replicas []*pg.DB
+	if cfg.SQLitePath != "" {
+		sqliteDB, err = sql.Open("sqlite", cfg.SQLitePath)
+		if err != nil {
+			panic(err)
+		}
+		defer sqliteDB.Close()
+		sqliteDB.SetMaxOpenConns(1)
+	} else {
+		opts, err := cfg.Database.Options("")
+		if err != nil {
+			panic(err)
+		}
+		db = pg.Connect(opts)
+		defer db.Close()
+		metrics.RegisterPoolStats(metrics.PRIMARY, opts.Addr, db.PoolStats)
+		err = waitForDatabase(db, cfg.Database.StartupTimeout)
+		if err != nil {
+			panic(err)
+		}
+		for _, addr := range cfg.ReplicaAddresses {
+			opts, err := cfg.Database.Options(addr)
+			if err != nil {
+				panic(err)
+			}
+			replica := pg.Connect(opts)
+			defer replica.Close()
+			metrics.RegisterPoolStats(metrics.REPLICA, opts.Addr, replica.PoolStats)
+			replicas = append(replicas, replica)
+		}
+		if *migrateDryRun {
+			for _, api := range apis {
+				migrations, err := newPgStore(cfg, db, replicas, api).PlanMigrations()
+				if err != nil {
+					panic(err)
+				}
+				if api.Name != "" {
+					fmt.Printf("-- api %s\n", api.Name)
+				}
+				for _, m := range migrations {
+					if m.Destructive {
+						fmt.Println("-- destructive")
+					}
+					fmt.Println(m.SQL + ";")
+				}
+			}
+			return
+		}
+	}
+
+	var authenticator user.Authenticator = user.ParseAuthenticator{URL: cfg.ParseURL}
+	if _, err := os.Stat(cfg.APIKeysPath); err == nil {
+		apiKeys, err := config.APIKeys(cfg.APIKeysPath)
+		if err != nil {
+			panic("could not read api keys file")
+		}
+		authenticator = user.ChainAuthenticator{
+			Authenticators: []user.Authenticator{user.APIKeyAuthenticator{Keys: apiKeys.Keys}, authenticator},
+		}
 	}
 
 	r := mux.NewRouter()
-	h := handlers.Handlers{
-		Store:               s,
-		Auth:                *auth,
-		Authenticator:       user.ParseAuthenticator{},
-		CustomLogic:         *customLogic,
-		CustomLogicExecutor: handlers.RemoteCustomLogicExecutor{URL: config.CustomLogicURL},
-	}
-	r.HandleFunc("/", instrumentedHandler(h.CreateHandler, metrics.CREATE)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/{id}", instrumentedHandler(h.ReadHandler, metrics.READ)).Methods("GET", "OPTIONS")
-	r.HandleFunc("/{id}/{action}", updateInstrumentedHandler(h.UpdateHandler)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/", instrumentedHandler(h.ListHandler, metrics.LIST)).Methods("GET", "OPTIONS")
-	r.HandleFunc("/{id}", instrumentedHandler(h.DeleteHandler, metrics.DELETE)).Methods("DELETE", "OPTIONS")
+	grpcServers := grpcAPIs{metrics: map[string]*metrics.Metrics{}}
+	for _, api := range apis {
+		// a single API is mounted at the root, under the metric namespace of API_NAME
+		router := r
+		m := metrics.Default
+		customLogicURL := cfg.CustomLogicURL
+		if api.Name != "" {
+			router = r.PathPrefix("/" + api.Name).Subrouter()
+			if api.Name != config.APIName {
+				m = metrics.New(api.Name)
+			}
+			customLogicURL += api.Name + "/"
+		}
+
+		definitions := handlers.NewLiveDefinitions(handlers.Definitions{Auth: api.Auth, CustomLogic: api.CustomLogic})
+		reloader := handlers.Reloader{
+			API:             api.API,
+			AuthPath:        api.AuthPath,
+			CustomLogicPath: api.CustomLogicPath,
+			Definitions:     definitions,
+			Metrics:         m,
+		}
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go reloader.Watch(cfg.ReloadInterval, hangups, nil)
+
+		h := handlers.Handlers{
+			API:                 api.API,
+			Schema:              store.NewSchema(api.API),
+			Authenticator:       authenticator,
+			CustomLogicExecutor: handlers.RemoteCustomLogicExecutor{URL: customLogicURL, Metrics: m},
+			Definitions:         definitions,
+			Metrics:             m,
+			MaxBulkItems:        cfg.MaxBulkItems,
+		}
+		var s store.Store
+		if sqliteDB != nil {
+			s = store.SQLiteStore{DB: sqliteDB, API: api.API, Schema: h.Schema, MultiTenant: cfg.MultiTenant, Table: table(api)}
+		} else {
+			pgStore := newPgStore(cfg, db, replicas, api)
+			pgStore.Schema = h.Schema
+			pgStore.Metrics = m
+			audited := store.AuditedStore{Delegate: pgStore, DB: db, Table: pgStore.Table}
+			s = audited
+			h.AuditLog = audited
+		}
+		s = store.InstrumentedStore{Delegate: s, Metrics: m}
+		err = s.CreateSchema()
+		if err != nil {
+			panic(err)
+		}
+		h.Store = s
+		// registered before the routes of the API, whose paths with an id would otherwise match
+		router.HandleFunc("/openapi.json", openapi.Handler(openapi.New(api.API, api.Name))).Methods("GET")
+		router.HandleFunc("/docs", openapi.ExplorerHandler).Methods("GET")
+		file, err := protoapi.New(api.API, api.Name)
+		if err != nil {
+			panic(err)
+		}
+		router.HandleFunc("/objects.proto", protoapi.Handler(file)).Methods("GET")
+		routes(router, h, api.API, m)
+		grpcServers.add(file, h.GRPC(file), m)
+		if ops := api.API.Operations; ops != nil && ops.Delete != nil && ops.Delete.SoftDelete && ops.Delete.RetentionDays > 0 {
+			go purgeDeleted(s.PurgeObjects, time.Duration(ops.Delete.RetentionDays)*24*time.Hour)
+		}
+		if api.Name != "" {
+			log.Printf("api %s ready at http://localhost:%s/%s/", api.Name, cfg.Port, api.Name)
+		}
+	}
+	r.Use(handlers.RequestID)
 	http.Handle("/", r)
 
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := grpc.NewServer(grpc.ChainUnaryInterceptor(handlers.GRPCRequestID, grpcServers.intercept, handlers.GRPCRecover))
+		for _, service := range grpcServers.services {
+			s.RegisterService(service, nil)
+		}
+		reflection.Register(s)
+		go func() {
+			log.Fatal(s.Serve(lis))
+		}()
+		log.Printf("grpc ready at localhost:%s", cfg.GRPCPort)
+	}
+
 	http.Handle("/metrics", promhttp.Handler())
 
-	log.Printf("api ready at http://localhost:%s/", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Printf("api ready at http://localhost:%s/", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
+}
+
+// grpcAPIs are the Objects services of the hosted APIs, each in the package of its API.
+type grpcAPIs struct {
+	services []*grpc.ServiceDesc
+	// metrics are the metrics of the APIs by the names of their services
+	metrics map[string]*metrics.Metrics
+}
+
+// add adds the service of an API, and registers the file describing it for the reflection service.
+func (a *grpcAPIs) add(file protoreflect.FileDescriptor, service *grpc.ServiceDesc, m *metrics.Metrics) {
+	err := protoregistry.GlobalFiles.RegisterFile(file)
+	if err != nil {
+		panic(err)
+	}
+	a.services = append(a.services, service)
+	a.metrics[service.ServiceName] = m
+}
+
+// intercept records the metrics of a call in those of its API.
+func (a grpcAPIs) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	m, ok := a.metrics[strings.Split(strings.TrimPrefix(info.FullMethod, "/"), "/")[0]]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	tenant := &metrics.TenantLabel{}
+	defer func() {
+		m.RequestCounter.WithLabelValues(metrics.GRPC, tenant.ID).Inc()
+	}()
+	start := time.Now()
+	res, err := handler(metrics.WithTenantLabel(ctx, tenant), req)
+	m.RequestSummary.WithLabelValues(metrics.GRPC).Observe(time.Since(start).Seconds())
+	return res, err
+}
+
+// routes registers the handlers of an API on the router.
+func routes(r *mux.Router, h handlers.Handlers, api model.API, m *metrics.Metrics) {
+	// registered before reads, which would otherwise match the GraphQL path
+	graphQL, err := h.GraphQL(api)
+	if err != nil {
+		panic(err)
+	}
+	r.HandleFunc("/graphql", instrumentedHandler(m, graphQL, metrics.GRAPHQL)).Methods("GET", "POST", "OPTIONS")
+	r.HandleFunc("/", instrumentedHandler(m, h.CreateHandler, metrics.CREATE)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/{id}", instrumentedHandler(m, h.ReadHandler, metrics.READ)).Methods("GET", "OPTIONS")
+	// registered before update actions, which would otherwise match the bulk create and delete paths
+	r.HandleFunc("/bulk/create", instrumentedHandler(m, h.BulkCreateHandler, metrics.BULK_CREATE)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/bulk/update/{action}", instrumentedHandler(m, h.BulkUpdateHandler, metrics.BULK_UPDATE)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/bulk/delete", instrumentedHandler(m, h.BulkDeleteHandler, metrics.BULK_DELETE)).Methods("POST", "OPTIONS")
+	if api.Operations != nil && api.Operations.Delete != nil && api.Operations.Delete.SoftDelete {
+		// registered before update actions, so that it takes precedence over an action named restore
+		r.HandleFunc("/{id}/restore", instrumentedHandler(m, h.RestoreHandler, metrics.RESTORE)).Methods("POST", "OPTIONS")
+	}
+	r.HandleFunc("/{id}/{action}", updateInstrumentedHandler(m, h.UpdateHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/", instrumentedHandler(m, h.ListHandler, metrics.LIST)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/{id}", instrumentedHandler(m, h.DeleteHandler, metrics.DELETE)).Methods("DELETE", "OPTIONS")
+	if api.Operations != nil && api.Operations.Update != nil && api.Operations.Update.Patch != nil {
+		r.HandleFunc("/{id}", instrumentedHandler(m, h.PatchHandler, metrics.PATCH)).Methods("PATCH", "OPTIONS")
+	}
+	if h.AuditLog != nil {
+		r.HandleFunc("/{id}/history", instrumentedHandler(m, h.HistoryHandler, metrics.HISTORY)).Methods("GET", "OPTIONS")
+	}
+}
+
+// newPgStore returns the store of an API, sharing the connection pools of the database and its replicas.
+func newPgStore(cfg *config.Config, db *pg.DB, replicas []*pg.DB, api config.APIDefinitions) store.PgStore {
+	s := store.PgStore{
+		DB:                         db,
+		API:                        api.API,
+		Table:                      table(api),
+		MultiTenant:                cfg.MultiTenant,
+		AllowDestructiveMigrations: cfg.AllowDestructiveMigrations,
+		Replicas:                   replicas,
+	}
+	if len(replicas) > 0 {
+		s.Sessions = store.NewWriteSessions(cfg.ReadYourWritesWindow)
+	}
+	return s
+}
+
+// table returns the table the objects of an API are stored in. Each named API has its own table.
+func table(api config.APIDefinitions) string {
+	if api.Name == "" {
+		return store.DefaultTable
+	}
+	return api.Name + "_" + store.DefaultTable
+}
+
+// writeOpenAPI writes the OpenAPI document of a single API to the file at path, or of each of several APIs to
+// {name}.json in the directory at path.
+func writeOpenAPI(path string, apis []config.APIDefinitions) error {
+	if len(apis) > 1 {
+		err := os.MkdirAll(path, 0755)
+		if err != nil {
+			return errors.Wrap(err, "failed to create openapi directory")
+		}
+	}
+	for _, api := range apis {
+		out := path
+		if len(apis) > 1 {
+			out = filepath.Join(path, api.Name+".json")
+		}
+		doc, err := json.MarshalIndent(openapi.New(api.API, api.Name), "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(out, append(doc, '\n'), 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write openapi document '%s'", out)
+		}
+	}
+	return nil
+}
+
+// waitForDatabase pings the database until it is reachable, backing off exponentially between attempts. It fails if the
+// database is still unreachable after the timeout.
+func waitForDatabase(db *pg.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := minConnectBackoff
+	for {
+		_, err := db.Exec("SELECT 1")
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.Wrap(err, "database is unreachable")
+		}
+		log.Printf("database is unreachable, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// purgeDeleted periodically removes objects that were soft-deleted longer ago than the retention period.
+func purgeDeleted(purge func(deletedBefore time.Time) (int, error), retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purged, err := purge(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("failed to purge deleted objects: %v", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("purged %d deleted objects", purged)
+		}
+	}
 }
 
 type handler = func(w http.ResponseWriter, r *http.Request)
 
-func instrumentedHandler(handler handler, label string) handler {
+func instrumentedHandler(m *metrics.Metrics, handler handler, label string) handler {
 	return func(w http.ResponseWriter, r *http.Request) {
-		metrics.RequestCounter.WithLabelValues(label).Inc()
+		tenant := &metrics.TenantLabel{}
+		defer func() {
+			m.RequestCounter.WithLabelValues(label, tenant.ID).Inc()
+		}()
 		start := time.Now()
-		handler(w, r)
+		handler(w, r.WithContext(metrics.WithTenantLabel(r.Context(), tenant)))
 		end := time.Now()
-		metrics.RequestSummary.WithLabelValues(label).Observe(end.Sub(start).Seconds())
+		m.RequestSummary.WithLabelValues(label).Observe(end.Sub(start).Seconds())
 	}
 }
 
-func updateInstrumentedHandler(handler handler) handler {
+func updateInstrumentedHandler(m *metrics.Metrics, handler handler) handler {
 	return func(w http.ResponseWriter, r *http.Request) {
-		instrumentedHandler(handler, mux.Vars(r)["action"])(w, r)
+		instrumentedHandler(m, handler, mux.Vars(r)["action"])(w, r)
 	}
 }
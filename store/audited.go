@@ -0,0 +1,387 @@
+package store
+
+//go:generate $GOPATH/bin/mockgen -source=$GOFILE -destination=$PWD/mocks/$GOFILE -package=mocks
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/gracew/widget-proxy/metrics"
+	"github.com/pkg/errors"
+)
+
+// AuditLog provides the recorded history of objects.
+type AuditLog interface {
+	History(objectID string, tenantID string) ([]AuditEntry, error)
+}
+
+// AuditEntry records a single mutation of an object.
+type AuditEntry struct {
+	tableName struct{} `sql:"audit_entries"`
+
+	ID int64 `json:"id"`
+	// Table is the table of the object, which distinguishes objects of different APIs that share an ID.
+	Table     string `json:"-" sql:"table,notnull"`
+	ObjectID  string `json:"objectId" sql:",notnull"`
+	Operation string `json:"operation" sql:",notnull"`
+	UserID    string `json:"userId"`
+	// TenantID is empty for single-tenant APIs. It is stored as '' rather than NULL so that History matches it.
+	TenantID  string `json:"-" sql:",notnull,default:''"`
+	RequestID string `json:"requestId"`
+	// Changes maps each field that changed to its values before and after the mutation. Fields of created objects
+	// change from null, and fields of deleted objects change to null.
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt string                 `json:"createdAt" sql:"default:now()"`
+}
+
+// FieldChange holds the values of a field before and after a mutation.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditedStore implements the Store interface and delegates to another Store instance, recording every mutation in an
+// append-only audit table. Each mutation and its audit entry are written in a single transaction, so that neither is
+// written without the other. The state of an object before an update or delete is read in the transaction with the
+// object locked, so that the recorded changes are those of the mutation even if the object is written concurrently.
+type AuditedStore struct {
+	Store
+	Delegate Transactional
+	DB       *pg.DB
+	// Table is the table of the delegate, whose objects' entries History returns. It defaults to DefaultTable.
+	Table  string
+	caller Caller
+}
+
+// CreateSchema creates the delegate's schema and the audit table if it does not exist.
+func (s AuditedStore) CreateSchema() error {
+	err := s.Delegate.CreateSchema()
+	if err != nil {
+		return err
+	}
+	err = s.DB.CreateTable((*AuditEntry)(nil), &orm.CreateTableOptions{IfNotExists: true})
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize audit schema")
+	}
+	// entries recorded before the table column was added were all of the default table
+	_, err = s.DB.Exec(`ALTER TABLE audit_entries ADD COLUMN IF NOT EXISTS "table" text NOT NULL DEFAULT ?`, DefaultTable)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize audit schema")
+	}
+	return nil
+}
+
+// CreateObject delegates to another Store instance and records the created object.
+func (s AuditedStore) CreateObject(obj Record) (Record, error) {
+	var res Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		var err error
+		res, err = d.CreateObject(obj)
+		if err != nil {
+			return err
+		}
+		return s.record(tx, res.ID(), metrics.CREATE, nil, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CreateObjects delegates to another Store instance and records the created objects.
+func (s AuditedStore) CreateObjects(objs []Record) ([]Record, error) {
+	var res []Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		var err error
+		res, err = d.CreateObjects(objs)
+		if err != nil {
+			return err
+		}
+		entries := make([]*AuditEntry, len(res))
+		for i, obj := range res {
+			entries[i], err = s.entry(obj.ID(), metrics.CREATE, nil, obj)
+			if err != nil {
+				return err
+			}
+		}
+		return s.recordAll(tx, entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetObject delegates to another Store instance.
+func (s AuditedStore) GetObject(objectID string) (Record, error) {
+	return s.Delegate.GetObject(objectID)
+}
+
+// ListObjects delegates to another Store instance.
+func (s AuditedStore) ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error) {
+	return s.Delegate.ListObjects(pageSize, filter, after)
+}
+
+// UpdateObject delegates to another Store instance and records the fields changed by the action.
+func (s AuditedStore) UpdateObject(obj Record, action string, expectedVersion int64) (Record, error) {
+	var res Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		before, err := d.LockObject(obj.ID())
+		if err != nil {
+			return err
+		}
+		res, err = d.UpdateObject(obj, action, expectedVersion)
+		if err != nil {
+			return err
+		}
+		return s.record(tx, res.ID(), action, before, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PatchObject delegates to another Store instance and records the fields changed by the operation.
+func (s AuditedStore) PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error) {
+	var res Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		before, err := d.LockObject(obj.ID())
+		if err != nil {
+			return err
+		}
+		res, err = d.PatchObject(obj, operation, fields, expectedVersion)
+		if err != nil {
+			return err
+		}
+		return s.record(tx, res.ID(), operation, before, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteObject delegates to another Store instance and records the deleted object.
+func (s AuditedStore) DeleteObject(objectID string, expectedVersion int64) error {
+	return s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		before, err := d.LockObject(objectID)
+		if err != nil {
+			return err
+		}
+		err = d.DeleteObject(objectID, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return s.record(tx, objectID, metrics.DELETE, before, nil)
+	})
+}
+
+// UpdateObjects delegates to another Store instance and records the fields changed by the action on each object.
+func (s AuditedStore) UpdateObjects(objs []Record, action string) ([]Record, error) {
+	var res []Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		befores, err := lockAll(d, objs)
+		if err != nil {
+			return err
+		}
+		res, err = d.UpdateObjects(objs, action)
+		if err != nil {
+			return err
+		}
+		entries := make([]*AuditEntry, len(res))
+		for i, obj := range res {
+			entries[i], err = s.entry(obj.ID(), action, befores[i], obj)
+			if err != nil {
+				return err
+			}
+		}
+		return s.recordAll(tx, entries)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteObjects delegates to another Store instance and records the deleted objects.
+func (s AuditedStore) DeleteObjects(objs []Record) error {
+	return s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		befores, err := lockAll(d, objs)
+		if err != nil {
+			return err
+		}
+		err = d.DeleteObjects(objs)
+		if err != nil {
+			return err
+		}
+		var entries []*AuditEntry
+		for i, before := range befores {
+			if before == nil {
+				continue
+			}
+			entry, err := s.entry(objs[i].ID(), metrics.DELETE, before, nil)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return s.recordAll(tx, entries)
+	})
+}
+
+// inTransaction calls fn with the delegate and the transaction its queries run in.
+func (s AuditedStore) inTransaction(fn func(d Transactional, tx *pg.Tx) error) error {
+	return s.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return fn(s.Delegate.InTransaction(tx), tx)
+	})
+}
+
+// lockAll locks each of the objects, and returns its current state.
+func lockAll(d Transactional, objs []Record) ([]Record, error) {
+	befores := make([]Record, len(objs))
+	for i, obj := range objs {
+		before, err := d.LockObject(obj.ID())
+		if err != nil {
+			return nil, err
+		}
+		befores[i] = before
+	}
+	return befores, nil
+}
+
+// GetDeletedObject delegates to another Store instance.
+func (s AuditedStore) GetDeletedObject(objectID string) (Record, error) {
+	return s.Delegate.GetDeletedObject(objectID)
+}
+
+// RestoreObject delegates to another Store instance and records the restored object.
+func (s AuditedStore) RestoreObject(objectID string) (Record, error) {
+	var res Record
+	err := s.inTransaction(func(d Transactional, tx *pg.Tx) error {
+		var err error
+		res, err = d.RestoreObject(objectID)
+		if err != nil || res == nil {
+			return err
+		}
+		return s.record(tx, objectID, metrics.RESTORE, nil, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PurgeObjects delegates to another Store instance. Purges are not recorded, as they only remove objects whose
+// deletion has already been recorded.
+func (s AuditedStore) PurgeObjects(deletedBefore time.Time) (int, error) {
+	return s.Delegate.PurgeObjects(deletedBefore)
+}
+
+// WithCaller returns an AuditedStore recording mutations on behalf of the caller.
+func (s AuditedStore) WithCaller(caller Caller) Store {
+	return AuditedStore{Delegate: s.Delegate.WithCaller(caller).(Transactional), DB: s.DB, Table: s.Table, caller: caller}
+}
+
+// History returns the audit entries for the object of the store's table in the order they were recorded.
+func (s AuditedStore) History(objectID string, tenantID string) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.DB.Model(&entries).
+		Where(`"table" = ?`, tableOrDefault(s.Table)).
+		Where("object_id = ?", objectID).
+		Where("tenant_id = ?", tenantID).
+		Order("id ASC").
+		Select()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read audit entries")
+	}
+	return entries, nil
+}
+
+func (s AuditedStore) record(db orm.DB, objectID string, operation string, before Record, after Record) error {
+	entry, err := s.entry(objectID, operation, before, after)
+	if err != nil {
+		return err
+	}
+	err = db.Insert(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert audit entry")
+	}
+	return nil
+}
+
+// recordAll inserts the entries with a single multi-row INSERT.
+func (s AuditedStore) recordAll(db orm.DB, entries []*AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	_, err := db.Model(&entries).Insert()
+	if err != nil {
+		return errors.Wrap(err, "failed to insert audit entries")
+	}
+	return nil
+}
+
+func (s AuditedStore) entry(objectID string, operation string, before Record, after Record) (*AuditEntry, error) {
+	changes, err := diff(before, after)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditEntry{
+		Table:     tableOrDefault(s.Table),
+		ObjectID:  objectID,
+		Operation: operation,
+		UserID:    s.caller.UserID,
+		TenantID:  s.caller.TenantID,
+		RequestID: s.caller.RequestID,
+		Changes:   changes,
+	}, nil
+}
+
+// diff returns the fields whose values differ between the two objects. Either object may be nil.
+func diff(before Record, after Record) (map[string]FieldChange, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]FieldChange{}
+	for k, v := range beforeFields {
+		if !reflect.DeepEqual(v, afterFields[k]) {
+			changes[k] = FieldChange{Before: v, After: afterFields[k]}
+		}
+	}
+	for k, v := range afterFields {
+		if _, ok := beforeFields[k]; !ok {
+			changes[k] = FieldChange{After: v}
+		}
+	}
+	return changes, nil
+}
+
+// fields returns the JSON fields of the object, or an empty map if the object is nil.
+func fields(obj Record) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	if obj == nil {
+		return res, nil
+	}
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal object")
+	}
+	err = json.Unmarshal(bytes, &res)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal object")
+	}
+	return res, nil
+}
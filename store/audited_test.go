@@ -0,0 +1,45 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := Record{"id": "1", "createdBy": "userID", "test": "test"}
+	after := Record{"id": "1", "createdBy": "userID", "test": "test2"}
+
+	changes, err := diff(before, after)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]FieldChange{"test": FieldChange{Before: "test", After: "test2"}}, changes)
+
+	changes, err = diff(nil, after)
+	assert.NoError(t, err)
+	assert.Equal(t, FieldChange{After: "1"}, changes["id"])
+	assert.Equal(t, FieldChange{After: "test2"}, changes["test"])
+
+	changes, err = diff(before, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, FieldChange{Before: "userID"}, changes["createdBy"])
+}
+
+func TestAuditEntrySingleTenant(t *testing.T) {
+	// single-tenant entries are written with an empty tenant, which History must be able to match
+	field := orm.GetTable(reflect.TypeOf(AuditEntry{})).FieldsMap["tenant_id"]
+	assert.True(t, field.HasFlag(orm.NotNullFlag))
+	assert.Equal(t, "''", string(field.AppendValue(nil, reflect.ValueOf(AuditEntry{}), 1)))
+	assert.Equal(t, "''", string(field.Default))
+}
+
+func TestAuditEntryTable(t *testing.T) {
+	entry, err := AuditedStore{}.entry("1", "create", nil, Record{"id": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTable, entry.Table)
+
+	entry, err = AuditedStore{Table: "widgets_objects"}.entry("1", "create", nil, Record{"id": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "widgets_objects", entry.Table)
+}
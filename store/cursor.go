@@ -0,0 +1,61 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCursor is returned by DecodeCursor for a cursor that EncodeCursor did not return for the API.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor returns an opaque cursor for the position of the record in lists of the API: the values of its sort
+// fields and id. Lists after the cursor continue with the objects following the record, even if objects have been
+// created or deleted since.
+func EncodeCursor(api model.API, record Record) string {
+	var values []interface{}
+	for _, sort := range listSort(api) {
+		values = append(values, record[sort.Field])
+	}
+	b, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor returns a record with the sort fields and id of a cursor returned by EncodeCursor, to be passed to
+// ListObjects as after.
+func DecodeCursor(api model.API, schema *Schema, cursor string) (Record, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var values []interface{}
+	if decoder.Decode(&values) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	sorts := listSort(api)
+	if len(values) != len(sorts) {
+		return nil, ErrInvalidCursor
+	}
+	res := Record{}
+	for i, sort := range sorts {
+		f, ok := schema.Field(sort.Field)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		value, err := convert(f.Type, values[i])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		if value == nil && !f.Nullable {
+			return nil, ErrInvalidCursor
+		}
+		res[sort.Field] = value
+	}
+	return res, nil
+}
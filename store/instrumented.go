@@ -3,7 +3,6 @@ package store
 import (
 	"time"
 
-	"github.com/gracew/widget-proxy/generated"
 	"github.com/gracew/widget-proxy/metrics"
 )
 
@@ -12,6 +11,8 @@ import (
 type InstrumentedStore struct {
 	Store
 	Delegate Store
+	// Metrics records the durations. If nil, metrics.Default is used.
+	Metrics *metrics.Metrics
 }
 
 // CreateSchema delegates to another Store instance. It does not record the duration of the operation.
@@ -20,47 +21,115 @@ func (s InstrumentedStore) CreateSchema() error {
 }
 
 // CreateObject delegates to another Store instance and records the duration of the operation.
-func (s InstrumentedStore) CreateObject(obj *generated.Object) (*generated.Object, error) {
+func (s InstrumentedStore) CreateObject(obj Record) (Record, error) {
 	start := time.Now()
 	res, err := s.Delegate.CreateObject(obj)
 	end := time.Now()
-	metrics.DatabaseSummary.WithLabelValues(metrics.CREATE).Observe(end.Sub(start).Seconds())
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.CREATE, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// CreateObjects delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) CreateObjects(objs []Record) ([]Record, error) {
+	start := time.Now()
+	res, err := s.Delegate.CreateObjects(objs)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.BULK_CREATE, "").Observe(end.Sub(start).Seconds())
 	return res, err
 }
 
 // GetObject delegates to another Store instance and records the duration of the operation.
-func (s InstrumentedStore) GetObject(objectID string) (*generated.Object, error) {
+func (s InstrumentedStore) GetObject(objectID string) (Record, error) {
 	start := time.Now()
 	res, err := s.Delegate.GetObject(objectID)
 	end := time.Now()
-	metrics.DatabaseSummary.WithLabelValues(metrics.READ).Observe(end.Sub(start).Seconds())
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.READ, "").Observe(end.Sub(start).Seconds())
 	return res, err
 }
 
 // ListObjects delegates to another Store instance and records the duration of the operation.
-func (s InstrumentedStore) ListObjects(pageSize int, filter *Filter) ([]generated.Object, error) {
+func (s InstrumentedStore) ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error) {
 	start := time.Now()
-	res, err := s.Delegate.ListObjects(pageSize, filter)
+	res, err := s.Delegate.ListObjects(pageSize, filter, after)
 	end := time.Now()
 	// TODO(gracew): include pageSize and filter info in metric labels
-	metrics.DatabaseSummary.WithLabelValues(metrics.LIST).Observe(end.Sub(start).Seconds())
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.LIST, "").Observe(end.Sub(start).Seconds())
 	return res, err
 }
 
 // UpdateObject delegates to another Store instance and records the duration of the operation.
-func (s InstrumentedStore) UpdateObject(obj *generated.Object, action string) (*generated.Object, error) {
+func (s InstrumentedStore) UpdateObject(obj Record, action string, expectedVersion int64) (Record, error) {
+	start := time.Now()
+	res, err := s.Delegate.UpdateObject(obj, action, expectedVersion)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(action, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// PatchObject delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error) {
 	start := time.Now()
-	res, err := s.Delegate.UpdateObject(obj, action)
+	res, err := s.Delegate.PatchObject(obj, operation, fields, expectedVersion)
 	end := time.Now()
-	metrics.DatabaseSummary.WithLabelValues(action).Observe(end.Sub(start).Seconds())
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(operation, "").Observe(end.Sub(start).Seconds())
 	return res, err
 }
 
 // DeleteObject delegates to another Store instance and records the duration of the operation.
-func (s InstrumentedStore) DeleteObject(objectID string) error {
+func (s InstrumentedStore) DeleteObject(objectID string, expectedVersion int64) error {
 	start := time.Now()
-	err := s.Delegate.DeleteObject(objectID)
+	err := s.Delegate.DeleteObject(objectID, expectedVersion)
 	end := time.Now()
-	metrics.DatabaseSummary.WithLabelValues(metrics.DELETE).Observe(end.Sub(start).Seconds())
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.DELETE, "").Observe(end.Sub(start).Seconds())
 	return err
 }
+
+// UpdateObjects delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) UpdateObjects(objs []Record, action string) ([]Record, error) {
+	start := time.Now()
+	res, err := s.Delegate.UpdateObjects(objs, action)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.BULK_UPDATE, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// DeleteObjects delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) DeleteObjects(objs []Record) error {
+	start := time.Now()
+	err := s.Delegate.DeleteObjects(objs)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.BULK_DELETE, "").Observe(end.Sub(start).Seconds())
+	return err
+}
+
+// GetDeletedObject delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) GetDeletedObject(objectID string) (Record, error) {
+	start := time.Now()
+	res, err := s.Delegate.GetDeletedObject(objectID)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.READ, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// RestoreObject delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) RestoreObject(objectID string) (Record, error) {
+	start := time.Now()
+	res, err := s.Delegate.RestoreObject(objectID)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.RESTORE, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// PurgeObjects delegates to another Store instance and records the duration of the operation.
+func (s InstrumentedStore) PurgeObjects(deletedBefore time.Time) (int, error) {
+	start := time.Now()
+	res, err := s.Delegate.PurgeObjects(deletedBefore)
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(metrics.PURGE, "").Observe(end.Sub(start).Seconds())
+	return res, err
+}
+
+// WithCaller returns an InstrumentedStore delegating to the delegate's Store for the caller.
+func (s InstrumentedStore) WithCaller(caller Caller) Store {
+	return InstrumentedStore{Delegate: s.Delegate.WithCaller(caller), Metrics: s.Metrics}
+}
@@ -0,0 +1,472 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+// MemoryStore implements the Store interface in memory, with the same semantics as PgStore. It is safe for concurrent
+// use, and stores returned by WithCaller share the objects of the store they were derived from.
+type MemoryStore struct {
+	Store
+	API model.API
+	// Schema is the schema of the objects. If nil, the schema of API is used.
+	Schema *Schema
+	// MultiTenant scopes every operation to TenantID. Operations fail with ErrNoTenant if TenantID is not set.
+	MultiTenant bool
+	TenantID    string
+	// UserID is recorded as the last modifier of the objects the store updates.
+	UserID  string
+	objects *memoryObjects
+}
+
+type memoryObjects struct {
+	sync.RWMutex
+	byID map[string]*memoryObject
+}
+
+// memoryObject is a stored object, along with the columns that are not fields of the object.
+type memoryObject struct {
+	record    Record
+	tenantID  string
+	deletedAt time.Time
+}
+
+func (o *memoryObject) copy() *memoryObject {
+	return &memoryObject{record: o.record.copy(), tenantID: o.tenantID, deletedAt: o.deletedAt}
+}
+
+// NewMemoryStore returns an empty MemoryStore for the API definition.
+func NewMemoryStore(api model.API, multiTenant bool) MemoryStore {
+	return MemoryStore{
+		API:         api,
+		MultiTenant: multiTenant,
+		objects:     &memoryObjects{byID: map[string]*memoryObject{}},
+	}
+}
+
+// CreateSchema does nothing, as a MemoryStore has no schema.
+func (s MemoryStore) CreateSchema() error {
+	return nil
+}
+
+// CreateObject stores the object.
+func (s MemoryStore) CreateObject(obj Record) (Record, error) {
+	res, err := s.CreateObjects([]Record{obj})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// CreateObjects stores the objects.
+func (s MemoryStore) CreateObjects(objs []Record) ([]Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+
+	schema := s.schema()
+	now := time.Now()
+	res := make([]Record, len(objs))
+	for i, obj := range objs {
+		stored := Record{}
+		for _, f := range schema.Fields {
+			if value, ok := obj[f.Name]; ok && !f.System {
+				stored[f.Name] = value
+			}
+		}
+		stored["id"] = uuid.New().String()
+		stored["createdBy"] = obj.CreatedBy()
+		stored["createdAt"] = schema.timestamp(now)
+		stored["version"] = int64(1)
+		stored["updatedAt"] = schema.timestamp(now)
+		stored["updatedBy"] = obj.CreatedBy()
+		s.objects.byID[stored.ID()] = &memoryObject{record: schema.complete(stored), tenantID: s.TenantID}
+		res[i] = stored.copy()
+	}
+	return res, nil
+}
+
+// GetObject gets an object by ID. It returns nil if the object is not found.
+func (s MemoryStore) GetObject(objectID string) (Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+
+	stored := s.find(objectID, false)
+	if stored == nil {
+		return nil, nil
+	}
+	return stored.record.copy(), nil
+}
+
+// ListObjects retrieves the specified number of objects following after, ordered by the declared sort order or by
+// created_at DESC if none is declared.
+func (s MemoryStore) ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error) {
+	if filter != nil && !validFilter(s.API, *filter) {
+		return nil, errors.New("invalid filter field: " + filter.Field)
+	}
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.RLock()
+	var records []Record
+	for _, stored := range s.objects.byID {
+		if !s.visible(stored, false) {
+			continue
+		}
+		if filter != nil && fmt.Sprint(stored.record[filter.Field]) != fmt.Sprint(filter.Value) {
+			continue
+		}
+		if after != nil && compareListed(s.API, stored.record, after) <= 0 {
+			continue
+		}
+		records = append(records, stored.record.copy())
+	}
+	s.objects.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return compareListed(s.API, records[i], records[j]) < 0
+	})
+
+	if len(records) > pageSize {
+		records = records[:pageSize]
+	}
+	return records, nil
+}
+
+// UpdateObject updates the specified object, increments its version and records the store's user as its last
+// modifier. If expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict is
+// returned.
+func (s MemoryStore) UpdateObject(obj Record, actionName string, expectedVersion int64) (Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+	return s.updateObject(obj, actionName, expectedVersion)
+}
+
+// UpdateObjects applies the action to the objects. The version of each object is the version it is expected to have,
+// or AnyVersion. If any object cannot be updated, no object is updated and an *ItemError is returned.
+func (s MemoryStore) UpdateObjects(objs []Record, actionName string) ([]Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+
+	snapshot := s.snapshot(objs)
+	res := make([]Record, len(objs))
+	for i, obj := range objs {
+		var err error
+		res[i], err = s.updateObject(obj, actionName, obj.Version())
+		if err != nil {
+			s.restore(snapshot)
+			return nil, &ItemError{Index: i, Err: err}
+		}
+	}
+	return res, nil
+}
+
+// PatchObject updates only the given fields of the specified object, increments its version and records the store's
+// user as its last modifier. If expectedVersion is not AnyVersion and does not match the object's version,
+// ErrVersionConflict is returned.
+func (s MemoryStore) PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+	return s.patchObject(obj, fields, expectedVersion)
+}
+
+func (s MemoryStore) updateObject(obj Record, actionName string, expectedVersion int64) (Record, error) {
+	// update only the fields specified by the action
+	action := FindAction(s.API, actionName)
+	if action == nil {
+		return nil, errors.New("unknown action " + actionName)
+	}
+	return s.patchObject(obj, action.Fields, expectedVersion)
+}
+
+func (s MemoryStore) patchObject(obj Record, fields []string, expectedVersion int64) (Record, error) {
+	schema := s.schema()
+	for _, name := range fields {
+		if _, ok := schema.Field(name); !ok {
+			return nil, errors.New("unknown field " + name)
+		}
+	}
+
+	stored := s.find(obj.ID(), false)
+	if stored == nil || (expectedVersion != AnyVersion && stored.record.Version() != expectedVersion) {
+		if expectedVersion != AnyVersion {
+			return nil, ErrVersionConflict
+		}
+		return nil, errors.New("failed to update object: object not found")
+	}
+
+	for _, name := range fields {
+		// system columns are set below, even if the action lists them
+		f, _ := schema.Field(name)
+		if systemColumns[f.Column] {
+			continue
+		}
+		value, ok := obj[name]
+		if !ok {
+			value = f.zero()
+		}
+		stored.record[name] = value
+	}
+	s.touch(stored)
+	return stored.record.copy(), nil
+}
+
+// DeleteObject deletes the specified object, or marks it as deleted if the API definition enables soft deletes. If
+// expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict is returned.
+func (s MemoryStore) DeleteObject(objectID string, expectedVersion int64) error {
+	if s.MultiTenant && s.TenantID == "" {
+		return ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+	return s.deleteObject(objectID, expectedVersion)
+}
+
+// DeleteObjects deletes the objects. The version of each object is the version it is expected to have, or
+// AnyVersion. If any object cannot be deleted, no object is deleted and an *ItemError is returned.
+func (s MemoryStore) DeleteObjects(objs []Record) error {
+	if s.MultiTenant && s.TenantID == "" {
+		return ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+
+	snapshot := s.snapshot(objs)
+	for i, obj := range objs {
+		err := s.deleteObject(obj.ID(), obj.Version())
+		if err != nil {
+			s.restore(snapshot)
+			return &ItemError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func (s MemoryStore) deleteObject(objectID string, expectedVersion int64) error {
+	stored := s.find(objectID, false)
+	if stored == nil || (expectedVersion != AnyVersion && stored.record.Version() != expectedVersion) {
+		if expectedVersion != AnyVersion {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+
+	if softDelete(s.API) {
+		stored.deletedAt = time.Now()
+		s.touch(stored)
+	} else {
+		delete(s.objects.byID, objectID)
+	}
+	return nil
+}
+
+// GetDeletedObject gets a soft-deleted object by ID. It returns nil if no such object is found.
+func (s MemoryStore) GetDeletedObject(objectID string) (Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.RLock()
+	defer s.objects.RUnlock()
+
+	stored := s.find(objectID, true)
+	if stored == nil {
+		return nil, nil
+	}
+	return stored.record.copy(), nil
+}
+
+// RestoreObject clears the deletion time of a soft-deleted object and increments its version. It returns nil if no
+// such object is found.
+func (s MemoryStore) RestoreObject(objectID string) (Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	s.objects.Lock()
+	defer s.objects.Unlock()
+
+	stored := s.find(objectID, true)
+	if stored == nil {
+		return nil, nil
+	}
+	stored.deletedAt = time.Time{}
+	s.touch(stored)
+	return stored.record.copy(), nil
+}
+
+// PurgeObjects permanently removes objects soft-deleted before the given time. It is not scoped to a tenant.
+func (s MemoryStore) PurgeObjects(deletedBefore time.Time) (int, error) {
+	s.objects.Lock()
+	defer s.objects.Unlock()
+
+	purged := 0
+	for id, stored := range s.objects.byID {
+		if !stored.deletedAt.IsZero() && stored.deletedAt.Before(deletedBefore) {
+			delete(s.objects.byID, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// WithCaller returns a MemoryStore acting on behalf of the caller, and scoped to the caller's tenant if the store is
+// multi-tenant.
+func (s MemoryStore) WithCaller(caller Caller) Store {
+	s.UserID = caller.UserID
+	if s.MultiTenant {
+		s.TenantID = caller.TenantID
+	}
+	return s
+}
+
+// schema returns the schema of the objects.
+func (s MemoryStore) schema() *Schema {
+	if s.Schema != nil {
+		return s.Schema
+	}
+	return NewSchema(s.API)
+}
+
+// touch increments the version of the stored object and records the store's user as its last modifier.
+func (s MemoryStore) touch(stored *memoryObject) {
+	stored.record["version"] = stored.record.Version() + 1
+	stored.record["updatedAt"] = s.schema().timestamp(time.Now())
+	stored.record["updatedBy"] = s.UserID
+}
+
+// find returns the stored object with the ID if it is visible to the store, or nil. The caller must hold the lock.
+func (s MemoryStore) find(objectID string, deleted bool) *memoryObject {
+	stored, ok := s.objects.byID[objectID]
+	if !ok || !s.visible(stored, deleted) {
+		return nil
+	}
+	return stored
+}
+
+// visible returns whether the object belongs to the store's tenant, and is soft-deleted or not as requested.
+func (s MemoryStore) visible(obj *memoryObject, deleted bool) bool {
+	if s.MultiTenant && obj.tenantID != s.TenantID {
+		return false
+	}
+	return !obj.deletedAt.IsZero() == deleted
+}
+
+// snapshot copies the stored state of the objects, so that a failed bulk operation can be undone. The caller must hold
+// the lock.
+func (s MemoryStore) snapshot(objs []Record) map[string]*memoryObject {
+	snapshot := map[string]*memoryObject{}
+	for _, obj := range objs {
+		stored, ok := s.objects.byID[obj.ID()]
+		if !ok {
+			continue
+		}
+		snapshot[obj.ID()] = stored.copy()
+	}
+	return snapshot
+}
+
+// restore puts back the stored state of the objects in the snapshot. The caller must hold the lock.
+func (s MemoryStore) restore(snapshot map[string]*memoryObject) {
+	for id, obj := range snapshot {
+		s.objects.byID[id] = obj
+	}
+}
+
+// compareListed orders two records in the order of lists, returning a negative number if a is listed first, zero or
+// a positive number.
+func compareListed(api model.API, a Record, b Record) int {
+	for _, sort := range listSort(api) {
+		c := compare(a[sort.Field], b[sort.Field])
+		if c != 0 {
+			if sort.Order == model.SortOrderDesc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// compare orders two field values of the same type, returning a negative number, zero or a positive number. nil
+// orders after any other value, as NULL does in Postgres.
+func compare(a interface{}, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	switch a := a.(type) {
+	case string:
+		b := b.(string)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case int64:
+		b := b.(int64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case time.Time:
+		b := b.(time.Time)
+		if a.Before(b) {
+			return -1
+		} else if a.After(b) {
+			return 1
+		}
+		return 0
+	}
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	if as < bs {
+		return -1
+	} else if as > bs {
+		return 1
+	}
+	return 0
+}
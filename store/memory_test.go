@@ -0,0 +1,34 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/suite"
+)
+
+type MemoryTestSuite struct {
+	StoreTestSuite
+}
+
+func (suite *MemoryTestSuite) SetupTest() {
+	var objects *memoryObjects
+	suite.newStore = func(api model.API, multiTenant bool) Store {
+		s := NewMemoryStore(api, multiTenant)
+		// stores created by a test share their objects, like stores sharing a database
+		if objects == nil {
+			objects = s.objects
+		}
+		s.objects = objects
+		return s
+	}
+	suite.setup()
+}
+
+func TestMemoryTestSuite(t *testing.T) {
+	suite.Run(t, new(MemoryTestSuite))
+}
+
+func TestMemoryDeclaredFieldsTestSuite(t *testing.T) {
+	suite.Run(t, &MemoryTestSuite{StoreTestSuite{fields: storeTestFields}})
+}
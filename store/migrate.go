@@ -0,0 +1,418 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/pkg/errors"
+)
+
+// Migration is a single change bringing the database schema in line with the schema of the objects.
+type Migration struct {
+	SQL string
+	// Destructive migrations may lose data, and are only applied if explicitly allowed.
+	Destructive bool
+	// Concurrent migrations build or drop indexes without locking the table, which cannot be done in a transaction.
+	Concurrent bool
+}
+
+// Index is an index the object table is expected to have.
+type Index struct {
+	Name string
+	// Columns are the keys of the index in SQL: quoted column names, followed by their order in the sort index.
+	Columns []string
+}
+
+// migrationLockID is the key of the Postgres advisory lock held while migrating, so that servers starting at the same
+// time migrate one after another.
+const migrationLockID int64 = 0x776964676574 // "widget"
+
+// maxIdentifierLength is the length in bytes Postgres truncates longer identifiers to.
+const maxIdentifierLength = 63
+
+// appliedMigration records a migration applied to the database.
+type appliedMigration struct {
+	tableName struct{} `sql:"schema_migrations"`
+
+	ID int64
+	// Table is the table the migration changed, and Version counts the runs of Migrate that changed it. Both are null
+	// for migrations applied before they were recorded.
+	Table     string
+	Version   int64
+	Statement string    `sql:",notnull"`
+	AppliedAt time.Time `sql:"default:now()"`
+}
+
+// column is a column the object table is expected to have.
+type column struct {
+	Name string
+	// Type is the SQL type of the column.
+	Type       string
+	Definition string
+	// NotNull columns without a default need one to be added to a table that has rows.
+	NotNull    bool
+	HasDefault bool
+}
+
+// tableColumns returns the columns of the table of a struct.
+func tableColumns(table *orm.Table) []column {
+	var columns []column
+	for _, f := range table.Fields {
+		columns = append(columns, column{
+			Name:       f.SQLName,
+			Type:       f.SQLType,
+			Definition: columnDefinition(f),
+			NotNull:    f.HasFlag(orm.NotNullFlag),
+			HasDefault: f.Default != "",
+		})
+	}
+	return columns
+}
+
+type existingColumn struct {
+	ColumnName string
+	UdtName    string
+}
+
+type existingIndex struct {
+	Name  string
+	Valid bool
+}
+
+// PlanMigrations compares the schema with the object table in the database, and returns the changes needed to bring
+// the table in line with the schema.
+func (s PgStore) PlanMigrations() ([]Migration, error) {
+	return s.planMigrations(s.DB)
+}
+
+func (s PgStore) planMigrations(db orm.DB) ([]Migration, error) {
+	return planMigrations(db, tableOrDefault(s.Table), s.schema().columns, []string{"id"}, s.indexes())
+}
+
+// planMigrations compares the expected columns and indexes of a table with the database, and returns the changes
+// needed to bring the table in line with them.
+func planMigrations(db orm.DB, name string, expected []column, primaryKey []string, expectedIndexes []Index) ([]Migration, error) {
+	var columns []existingColumn
+	_, err := db.Query(&columns, `SELECT column_name, udt_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?`, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read existing columns")
+	}
+
+	var migrations []Migration
+	if len(columns) == 0 {
+		migrations = append(migrations, Migration{SQL: createTableSQL(name, expected, primaryKey)})
+	} else {
+		migrations = append(migrations, columnMigrations(name, expected, columns)...)
+	}
+
+	// an index whose concurrent build failed is left invalid, and must be dropped before it is built again
+	var indexes []existingIndex
+	_, err = db.Query(&indexes, `SELECT c.relname AS name, i.indisvalid AS valid FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_class t ON t.oid = i.indrelid
+		WHERE t.relname = ? AND t.relnamespace = current_schema()::regnamespace`, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read existing indexes")
+	}
+	existing := map[string]bool{}
+	for _, index := range indexes {
+		existing[index.Name] = index.Valid
+	}
+	for _, index := range expectedIndexes {
+		valid, ok := existing[index.Name]
+		if ok && valid {
+			continue
+		}
+		if ok {
+			migrations = append(migrations, Migration{
+				SQL:        "DROP INDEX CONCURRENTLY IF EXISTS " + index.Name,
+				Concurrent: true,
+			})
+		}
+		migrations = append(migrations, Migration{SQL: createIndexSQL(name, index), Concurrent: true})
+	}
+
+	return migrations, nil
+}
+
+// Migrate applies the migrations, recording each in the schema_migrations table under the next version of the table.
+// If any migration is destructive and destructive migrations are not allowed, nothing is applied.
+//
+// Changes to the table are applied in a single transaction, so that a failure leaves the table as it was. Indexes are
+// built concurrently afterwards, which Postgres does not allow in a transaction; an index whose build fails is rebuilt
+// by the next migration. Servers migrating at the same time wait for each other on an advisory lock.
+func (s PgStore) Migrate(migrations []Migration) error {
+	return s.withMigrationLock(func(conn *pg.Conn) error {
+		return s.migrate(conn, migrations)
+	})
+}
+
+// planAndMigrate plans and applies the migrations while holding the migration lock, so that migrations applied by
+// another server in the meantime are not planned again.
+func (s PgStore) planAndMigrate() error {
+	return s.withMigrationLock(func(conn *pg.Conn) error {
+		migrations, err := s.planMigrations(conn)
+		if err != nil {
+			return errors.Wrap(err, "failed to plan migrations")
+		}
+		return s.migrate(conn, migrations)
+	})
+}
+
+// withMigrationLock calls fn with a connection holding the migration lock.
+func (s PgStore) withMigrationLock(fn func(conn *pg.Conn) error) error {
+	conn := s.DB.Conn()
+	defer conn.Close()
+	_, err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID)
+	if err != nil {
+		return errors.Wrap(err, "failed to acquire migration lock")
+	}
+	defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+	return fn(conn)
+}
+
+func (s PgStore) migrate(conn *pg.Conn, migrations []Migration) error {
+	if !s.AllowDestructiveMigrations {
+		var destructive []string
+		for _, m := range migrations {
+			if m.Destructive {
+				destructive = append(destructive, m.SQL)
+			}
+		}
+		if len(destructive) > 0 {
+			return errors.Errorf("refusing to apply destructive migrations: %s", strings.Join(destructive, "; "))
+		}
+	}
+
+	err := conn.CreateTable((*appliedMigration)(nil), &orm.CreateTableOptions{IfNotExists: true})
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrations table")
+	}
+	// the migrations table of earlier versions has neither column
+	_, err = conn.Exec(`ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS "table" text,
+		ADD COLUMN IF NOT EXISTS version bigint`)
+	if err != nil {
+		return errors.Wrap(err, "failed to upgrade migrations table")
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+
+	table := tableOrDefault(s.Table)
+	var version int64
+	_, err = conn.QueryOne(pg.Scan(&version), `SELECT coalesce(max(version), 0) + 1 FROM schema_migrations
+		WHERE "table" = ?`, table)
+	if err != nil {
+		return errors.Wrap(err, "failed to read schema version")
+	}
+	apply := func(db orm.DB, m Migration) error {
+		_, err := db.Exec(m.SQL)
+		if err != nil {
+			return errors.Wrapf(err, "failed to apply migration '%s'", m.SQL)
+		}
+		err = db.Insert(&appliedMigration{Table: table, Version: version, Statement: m.SQL})
+		if err != nil {
+			return errors.Wrapf(err, "failed to record migration '%s'", m.SQL)
+		}
+		return nil
+	}
+
+	var concurrent []Migration
+	err = conn.RunInTransaction(func(tx *pg.Tx) error {
+		for _, m := range migrations {
+			if m.Concurrent {
+				concurrent = append(concurrent, m)
+				continue
+			}
+			err := apply(tx, m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range concurrent {
+		err := apply(conn, m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexes returns the indexes the object table is expected to have: one for each declared filter field, and one
+// matching the declared sort order. In multi-tenant mode every query is scoped to a tenant, so each index leads with
+// tenant_id. Indexes are named after the table.
+func (s PgStore) indexes() []Index {
+	table := tableOrDefault(s.Table)
+	var prefix []string
+	var indexes []Index
+	if s.MultiTenant {
+		prefix = []string{quoteIdent("tenant_id")}
+		indexes = append(indexes, Index{Name: indexName(table + "_tenant_id_idx"), Columns: prefix})
+	}
+	if s.API.Operations == nil || s.API.Operations.List == nil {
+		return indexes
+	}
+
+	for _, f := range s.API.Operations.List.Filter {
+		column := underscore(f)
+		indexes = append(indexes, Index{
+			Name:    indexName(table + "_" + column + "_idx"),
+			Columns: append(append([]string{}, prefix...), quoteIdent(column)),
+		})
+	}
+
+	if len(s.API.Operations.List.Sort) > 0 {
+		name := table + "_sort"
+		columns := append([]string{}, prefix...)
+		for _, sort := range s.API.Operations.List.Sort {
+			column := underscore(sort.Field)
+			name += "_" + column + "_" + strings.ToLower(sort.Order.String())
+			columns = append(columns, quoteIdent(column)+" "+sort.Order.String())
+		}
+		indexes = append(indexes, Index{Name: indexName(name + "_idx"), Columns: columns})
+	}
+	return indexes
+}
+
+// indexName returns the name, or if it is longer than Postgres allows, the name shortened and suffixed with a hash of
+// the full name. Postgres would otherwise truncate it, so that the index would not be found under its name and be
+// planned again on every start, and indexes whose names only differ after the limit would collide.
+func indexName(name string) string {
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+	return name[:maxIdentifierLength-len(hash)-1] + "_" + hash
+}
+
+func columnMigrations(name string, expected []column, columns []existingColumn) []Migration {
+	existing := map[string]existingColumn{}
+	for _, c := range columns {
+		existing[c.ColumnName] = c
+	}
+
+	var migrations []Migration
+	declared := map[string]bool{}
+	for _, e := range expected {
+		declared[e.Name] = true
+		c, ok := existing[e.Name]
+		if !ok {
+			migrations = append(migrations, Migration{
+				SQL: "ALTER TABLE " + quoteIdent(name) + " ADD COLUMN " + addColumnDefinition(e),
+			})
+		} else if c.UdtName != udtName(e.Type) {
+			migrations = append(migrations, Migration{
+				SQL: "ALTER TABLE " + quoteIdent(name) + " ALTER COLUMN " + quoteIdent(e.Name) + " TYPE " +
+					e.Type + " USING " + quoteIdent(e.Name) + "::" + e.Type,
+				Destructive: true,
+			})
+		}
+	}
+	for _, c := range columns {
+		if !declared[c.ColumnName] {
+			migrations = append(migrations, Migration{
+				SQL:         "ALTER TABLE " + quoteIdent(name) + " DROP COLUMN " + quoteIdent(c.ColumnName),
+				Destructive: true,
+			})
+		}
+	}
+	return migrations
+}
+
+// addColumnDefinition returns the definition of a column added to an existing table. A NOT NULL column without a
+// default cannot be added to a table that has rows, so it defaults to the zero value of its type.
+func addColumnDefinition(c column) string {
+	if !c.NotNull || c.HasDefault {
+		return c.Definition
+	}
+	if zero := zeroLiteral(c.Type); zero != "" {
+		return c.Definition + " DEFAULT " + zero
+	}
+	return c.Definition
+}
+
+// zeroLiteral returns the SQL literal of the zero value of the Go type of a column of the type, or an empty string if
+// the type has none.
+func zeroLiteral(sqlType string) string {
+	switch udtName(sqlType) {
+	case "int2", "int4", "int8", "float4", "float8", "numeric":
+		return "0"
+	case "bool":
+		return "false"
+	case "text", "varchar":
+		return "''"
+	case "timestamptz", "timestamp":
+		return "'0001-01-01 00:00:00+00'"
+	case "jsonb", "json":
+		return "'null'"
+	}
+	return ""
+}
+
+func createTableSQL(name string, columns []column, primaryKey []string) string {
+	var definitions []string
+	for _, c := range columns {
+		definitions = append(definitions, c.Definition)
+	}
+	var pks []string
+	for _, pk := range primaryKey {
+		pks = append(pks, quoteIdent(pk))
+	}
+	definitions = append(definitions, "PRIMARY KEY ("+strings.Join(pks, ", ")+")")
+	return "CREATE TABLE " + quoteIdent(name) + " (" + strings.Join(definitions, ", ") + ")"
+}
+
+func createIndexSQL(table string, index Index) string {
+	return "CREATE INDEX CONCURRENTLY IF NOT EXISTS " + index.Name + " ON " + quoteIdent(table) +
+		" (" + strings.Join(index.Columns, ", ") + ")"
+}
+
+// quoteIdent quotes a table or column name for use in SQL.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func columnDefinition(f *orm.Field) string {
+	definition := string(f.Column) + " " + f.SQLType
+	if f.HasFlag(orm.NotNullFlag) {
+		definition += " NOT NULL"
+	}
+	if f.Default != "" {
+		definition += " DEFAULT " + string(f.Default)
+	}
+	return definition
+}
+
+// udtName returns the name information_schema uses for the SQL type.
+func udtName(sqlType string) string {
+	switch sqlType {
+	case "smallint":
+		return "int2"
+	case "integer":
+		return "int4"
+	case "bigint":
+		return "int8"
+	case "real":
+		return "float4"
+	case "double precision":
+		return "float8"
+	case "boolean":
+		return "bool"
+	case "timestamp with time zone":
+		return "timestamptz"
+	case "timestamp without time zone":
+		return "timestamp"
+	}
+	return sqlType
+}
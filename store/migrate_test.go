@@ -0,0 +1,77 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexes(t *testing.T) {
+	s := PgStore{
+		API: model.API{
+			Operations: &model.OperationDefinition{
+				List: &model.ListDefinition{
+					Filter: []string{"test", "createdBy"},
+					Sort: []model.SortDefinition{
+						model.SortDefinition{Field: "test", Order: model.SortOrderAsc},
+						model.SortDefinition{Field: "createdAt", Order: model.SortOrderDesc},
+					},
+				},
+			},
+		},
+		MultiTenant: true,
+	}
+	assert.Equal(t, []Index{
+		Index{Name: "objects_tenant_id_idx", Columns: []string{`"tenant_id"`}},
+		Index{Name: "objects_test_idx", Columns: []string{`"tenant_id"`, `"test"`}},
+		Index{Name: "objects_created_by_idx", Columns: []string{`"tenant_id"`, `"created_by"`}},
+		Index{Name: "objects_sort_test_asc_created_at_desc_idx", Columns: []string{`"tenant_id"`, `"test" ASC`, `"created_at" DESC`}},
+	}, s.indexes())
+	assert.Equal(t,
+		`CREATE INDEX CONCURRENTLY IF NOT EXISTS objects_test_idx ON "objects" ("tenant_id", "test")`,
+		createIndexSQL(DefaultTable, s.indexes()[1]))
+
+	s.Table = "widgets_objects"
+	assert.Equal(t, Index{Name: "widgets_objects_test_idx", Columns: []string{`"tenant_id"`, `"test"`}}, s.indexes()[1])
+}
+
+func TestIndexesReservedWord(t *testing.T) {
+	s := PgStore{API: model.API{
+		Fields: []model.FieldDefinition{model.FieldDefinition{Name: "order", Type: model.FieldTypeInt}},
+		Operations: &model.OperationDefinition{
+			List: &model.ListDefinition{
+				Filter: []string{"order"},
+				Sort:   []model.SortDefinition{model.SortDefinition{Field: "order", Order: model.SortOrderAsc}},
+			},
+		},
+	}}
+	indexes := s.indexes()
+	assert.Equal(t, `CREATE INDEX CONCURRENTLY IF NOT EXISTS objects_order_idx ON "objects" ("order")`,
+		createIndexSQL(DefaultTable, indexes[0]))
+	assert.Equal(t, `CREATE INDEX CONCURRENTLY IF NOT EXISTS objects_sort_order_asc_idx ON "objects" ("order" ASC)`,
+		createIndexSQL(DefaultTable, indexes[1]))
+}
+
+func TestIndexName(t *testing.T) {
+	assert.Equal(t, "objects_test_idx", indexName("objects_test_idx"))
+
+	long := "library_objects_sort_published_at_desc_page_count_asc_title_asc_idx"
+	name := indexName(long)
+	assert.Len(t, name, maxIdentifierLength)
+	assert.Equal(t, long[:54], name[:54])
+	assert.NotEqual(t, name, indexName(long+"_2"))
+	assert.Equal(t, name, indexName(long))
+}
+
+func TestAddColumnDefinition(t *testing.T) {
+	assert.Equal(t, `"count" bigint NOT NULL DEFAULT 0`,
+		addColumnDefinition(column{Type: "bigint", Definition: `"count" bigint NOT NULL`, NotNull: true}))
+	assert.Equal(t, `"version" bigint NOT NULL DEFAULT 1`, addColumnDefinition(column{
+		Type:       "bigint",
+		Definition: `"version" bigint NOT NULL DEFAULT 1`,
+		NotNull:    true,
+		HasDefault: true,
+	}))
+	assert.Equal(t, `"title" text`, addColumnDefinition(column{Type: "text", Definition: `"title" text`}))
+}
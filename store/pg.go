@@ -1,122 +1,560 @@
 package store
 
 import (
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
-	"github.com/gracew/widget-proxy/generated"
+	"github.com/go-pg/pg/types"
+	"github.com/gracew/widget-proxy/metrics"
 	"github.com/gracew/widget-proxy/model"
 	"github.com/pkg/errors"
 )
 
-// PgStore implements the Store interface using Postgres.
+// PgStore implements the Store interface using Postgres, building its columns and queries from the schema of the
+// API.
 type PgStore struct {
 	Store
 	API model.API
-	DB  *pg.DB
+	// Schema is the schema of the objects. If nil, the schema of API is used.
+	Schema *Schema
+	DB     *pg.DB
+	// Table is the table objects are stored in. If empty, DefaultTable is used. Stores serving different APIs from the
+	// same database must use different tables.
+	Table string
+	// MultiTenant scopes every query to TenantID. Queries fail with ErrNoTenant if TenantID is not set.
+	MultiTenant bool
+	TenantID    string
+	// UserID is recorded as the last modifier of the objects the store updates.
+	UserID string
+	// AllowDestructiveMigrations allows CreateSchema to apply migrations that may lose data, such as dropping columns.
+	AllowDestructiveMigrations bool
+	// Replicas are read-only copies of DB. If any are set, GetObject, ListObjects and GetDeletedObject read from a
+	// randomly chosen replica, falling back to DB if the replica fails. Writes always go to DB.
+	Replicas []*pg.DB
+	// Sessions, if set, routes a user's reads to DB for a while after the user writes, so that users read their own
+	// writes. It should be shared by every PgStore serving the same API.
+	Sessions *WriteSessions
+	// Consistency is the consistency required by the caller. ConsistencyStrong routes every read to DB.
+	Consistency Consistency
+	// Metrics records the latency of reads routed between DB and Replicas. If nil, metrics.Default is used.
+	Metrics *metrics.Metrics
+	// tx, if set, is the transaction every query of the store runs in.
+	tx *pg.Tx
 }
 
-// CreateSchema creates the object table if it does not exist.
+// CreateSchema creates the object table if it does not exist, and migrates it to match the schema.
 func (s PgStore) CreateSchema() error {
 	_, err := s.DB.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto")
 	if err != nil {
 		return errors.Wrap(err, "failed to create pgcrypto extension")
 	}
-	for _, model := range []interface{}{(*generated.Object)(nil)} {
-		err := s.DB.CreateTable(model, &orm.CreateTableOptions{
-			IfNotExists: true,
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to initialize schema")
-		}
+	err = s.planAndMigrate()
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize schema")
 	}
 	return nil
 }
 
 // CreateObject inserts the object into the database.
-func (s PgStore) CreateObject(obj *generated.Object) (*generated.Object, error) {
-	err := s.DB.Insert(obj)
+func (s PgStore) CreateObject(obj Record) (Record, error) {
+	res, err := s.insert([]Record{obj})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to insert into database")
+		return nil, err
 	}
+	s.Sessions.record(s.UserID)
 
-	return obj, nil
+	return res[0], nil
 }
 
-// GetObject gets an object by ID. It returns nil if the object is not found.
-func (s PgStore) GetObject(objectID string) (*generated.Object, error) {
-	object := &generated.Object{ID: objectID}
-	err := s.DB.Select(object)
+// CreateObjects inserts the objects into the database with a single multi-row INSERT.
+func (s PgStore) CreateObjects(objs []Record) ([]Record, error) {
+	if len(objs) == 0 {
+		return objs, nil
+	}
+	res, err := s.insert(objs)
 	if err != nil {
-		if errors.Is(err, pg.ErrNoRows) {
-			return nil, nil
-		}
 		return nil, err
 	}
+	s.Sessions.record(s.UserID)
 
-	return object, nil
+	return res, nil
 }
 
-// ListObjects retrieves the specified number of objects, ordered by created_at DESC.
-func (s PgStore) ListObjects(pageSize int, filter *Filter) ([]generated.Object, error) {
-	if filter != nil && !s.validFilter(*filter) {
-		return nil, errors.New("invalid filter field: " + filter.Field)
+// insert inserts the fields of the objects that are not maintained by the store, and returns the inserted objects.
+func (s PgStore) insert(objs []Record) ([]Record, error) {
+	schema := s.schema()
+	columns := []string{"created_by", "updated_by"}
+	if s.MultiTenant {
+		if s.TenantID == "" {
+			return nil, ErrNoTenant
+		}
+		columns = append(columns, "tenant_id")
+	}
+	for _, f := range schema.Fields {
+		if !f.System {
+			columns = append(columns, quoteIdent(f.Column))
+		}
+	}
+
+	var rows []string
+	var args []interface{}
+	for _, obj := range objs {
+		args = append(args, obj.CreatedBy(), obj.CreatedBy())
+		if s.MultiTenant {
+			args = append(args, s.TenantID)
+		}
+		for _, f := range schema.Fields {
+			if f.System {
+				continue
+			}
+			value, ok := obj[f.Name]
+			if !ok {
+				value = f.zero()
+			}
+			args = append(args, value)
+		}
+		rows = append(rows, "("+placeholders(len(columns))+")")
+	}
+
+	res := &recordModel{schema: schema}
+	_, err := s.db().Query(res, "INSERT INTO "+s.table()+" ("+strings.Join(columns, ", ")+") VALUES "+
+		strings.Join(rows, ", ")+" RETURNING "+schema.selectColumns(), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to insert into database")
 	}
+	return res.records, nil
+}
+
+// GetObject gets an object by ID. It returns nil if the object is not found.
+func (s PgStore) GetObject(objectID string) (Record, error) {
+	return s.selectObject(metrics.READ, objectID, "deleted_at IS NULL")
+}
 
-	var models []generated.Object
-	m := s.DB.Model(&models).Order("created_at DESC")
+// ListObjects retrieves the specified number of objects following after, ordered by the declared sort order or by
+// created_at DESC if none is declared.
+func (s PgStore) ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error) {
+	if filter != nil && !validFilter(s.API, *filter) {
+		return nil, errors.New("invalid filter field: " + filter.Field)
+	}
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
 	if filter != nil {
-		m.Where(underscore(filter.Field)+" = ?", filter.Value)
+		q.Where(quoteIdent(underscore(filter.Field))+" = ?", filter.Value)
+	}
+	q.Where("deleted_at IS NULL")
+	if after != nil {
+		q.after(s.API, after, nil)
 	}
-	err := m.Limit(pageSize).Select()
+
+	schema := s.schema()
+	res := &recordModel{schema: schema}
+	err = s.read(metrics.LIST, func(db orm.DB) error {
+		_, err := db.Query(res, "SELECT "+schema.selectColumns()+" FROM "+s.table()+q.String()+
+			" ORDER BY "+strings.Join(order(s.API), ", ")+" LIMIT ?", append(q.args, pageSize)...)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return models, nil
+	return res.records, nil
+}
+
+// UpdateObject updates the specified object in the database, increments its version and records the store's user as
+// its last modifier. If expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict
+// is returned.
+func (s PgStore) UpdateObject(obj Record, actionName string, expectedVersion int64) (Record, error) {
+	res, err := s.updateObject(s.db(), obj, actionName, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	s.Sessions.record(s.UserID)
+	return res, nil
 }
 
-func (s PgStore) validFilter(filter Filter) bool {
-	for _, f := range s.API.Operations.List.Filter {
-		if f == filter.Field {
-			return true
+// UpdateObjects applies the action to the objects in a single transaction. The version of each object is the version
+// it is expected to have, or AnyVersion. If any object cannot be updated, no object is updated and an *ItemError is
+// returned.
+func (s PgStore) UpdateObjects(objs []Record, actionName string) ([]Record, error) {
+	res := make([]Record, len(objs))
+	err := s.runInTransaction(func(tx *pg.Tx) error {
+		for i, obj := range objs {
+			var err error
+			res[i], err = s.updateObject(tx, obj, actionName, obj.Version())
+			if err != nil {
+				return &ItemError{Index: i, Err: err}
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Sessions.record(s.UserID)
+	return res, nil
+}
+
+// PatchObject updates only the given fields of the specified object in the database, increments its version and
+// records the store's user as its last modifier. If expectedVersion is not AnyVersion and does not match the object's
+// version, ErrVersionConflict is returned.
+func (s PgStore) PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error) {
+	res, err := s.patchObject(s.db(), obj, fields, expectedVersion)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	s.Sessions.record(s.UserID)
+	return res, nil
 }
 
-// UpdateObject updates the specified object in the database.
-func (s PgStore) UpdateObject(obj *generated.Object, actionName string) (*generated.Object, error) {
+func (s PgStore) updateObject(db orm.DB, obj Record, actionName string, expectedVersion int64) (Record, error) {
 	// update only the fields specified by the action
-	action := s.findAction(actionName)
+	action := FindAction(s.API, actionName)
 	if action == nil {
 		return nil, errors.New("unknown action " + actionName)
 	}
+	return s.patchObject(db, obj, action.Fields, expectedVersion)
+}
+
+func (s PgStore) patchObject(db orm.DB, obj Record, fields []string, expectedVersion int64) (Record, error) {
+	schema := s.schema()
+	var sets []string
+	var args []interface{}
+	for _, name := range fields {
+		f, ok := schema.Field(name)
+		if !ok {
+			return nil, errors.New("unknown field " + name)
+		}
+		// system columns are set below, even if the action lists them
+		if systemColumns[f.Column] {
+			continue
+		}
+		value, ok := obj[name]
+		if !ok {
+			value = f.zero()
+		}
+		sets = append(sets, quoteIdent(f.Column)+" = ?")
+		args = append(args, value)
+	}
+	sets = append(sets, "version = version + 1", "updated_at = now()", "updated_by = ?")
+	args = append(args, s.UserID)
 
-	m := s.DB.Model(obj)
-	for _, f := range action.Fields {
-		m.Column(underscore(f))
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
 	}
-	_, err := m.WherePK().Returning("*").Update()
+	q.Where("id = ?", obj.ID()).Where("deleted_at IS NULL")
+	if expectedVersion != AnyVersion {
+		q.Where("version = ?", expectedVersion)
+	}
+	res := &recordModel{schema: schema}
+	_, err = db.QueryOne(res, "UPDATE "+s.table()+" SET "+strings.Join(sets, ", ")+q.String()+
+		" RETURNING "+schema.selectColumns(), append(args, q.args...)...)
 	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) && expectedVersion != AnyVersion {
+			return nil, ErrVersionConflict
+		}
 		return nil, errors.Wrap(err, "failed to update object")
 	}
-	return obj, nil
+	return res.records[0], nil
 }
 
-func (s PgStore) findAction(actionName string) *model.ActionDefinition {
-	if s.API.Operations == nil || s.API.Operations.Update == nil {
+// DeleteObject deletes the specified object from the database, or marks it as deleted if the API definition enables
+// soft deletes. If expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict is
+// returned.
+func (s PgStore) DeleteObject(objectID string, expectedVersion int64) error {
+	err := s.deleteObject(s.db(), objectID, expectedVersion)
+	if err != nil {
+		return err
+	}
+	s.Sessions.record(s.UserID)
+	return nil
+}
+
+// DeleteObjects deletes the objects in a single transaction. The version of each object is the version it is expected
+// to have, or AnyVersion. If any object cannot be deleted, no object is deleted and an *ItemError is returned.
+func (s PgStore) DeleteObjects(objs []Record) error {
+	err := s.runInTransaction(func(tx *pg.Tx) error {
+		for i, obj := range objs {
+			err := s.deleteObject(tx, obj.ID(), obj.Version())
+			if err != nil {
+				return &ItemError{Index: i, Err: err}
+			}
+		}
 		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.Sessions.record(s.UserID)
+	return nil
+}
+
+func (s PgStore) deleteObject(db orm.DB, objectID string, expectedVersion int64) error {
+	q, err := s.scope()
+	if err != nil {
+		return err
+	}
+	q.Where("id = ?", objectID).Where("deleted_at IS NULL")
+	if expectedVersion != AnyVersion {
+		q.Where("version = ?", expectedVersion)
+	}
+
+	var res orm.Result
+	if softDelete(s.API) {
+		res, err = db.Exec("UPDATE "+s.table()+
+			" SET deleted_at = now(), version = version + 1, updated_at = now(), updated_by = ?"+q.String(),
+			append([]interface{}{s.UserID}, q.args...)...)
+	} else {
+		res, err = db.Exec("DELETE FROM "+s.table()+q.String(), q.args...)
+	}
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 && expectedVersion != AnyVersion {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// GetDeletedObject gets a soft-deleted object by ID. It returns nil if no such object is found.
+func (s PgStore) GetDeletedObject(objectID string) (Record, error) {
+	return s.selectObject(metrics.READ, objectID, "deleted_at IS NOT NULL")
+}
+
+// RestoreObject clears the deletion time of a soft-deleted object and increments its version. It returns nil if no
+// such object is found.
+func (s PgStore) RestoreObject(objectID string) (Record, error) {
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
 	}
-	for _, action := range s.API.Operations.Update.Actions {
-		if action.Name == actionName {
-			return &action
+	q.Where("id = ?", objectID).Where("deleted_at IS NOT NULL")
+
+	schema := s.schema()
+	res := &recordModel{schema: schema}
+	_, err = s.db().QueryOne(res, "UPDATE "+s.table()+
+		" SET deleted_at = NULL, version = version + 1, updated_at = now(), updated_by = ?"+q.String()+
+		" RETURNING "+schema.selectColumns(), append([]interface{}{s.UserID}, q.args...)...)
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
 		}
+		return nil, errors.Wrap(err, "failed to restore object")
+	}
+	s.Sessions.record(s.UserID)
+	return res.records[0], nil
+}
+
+// PurgeObjects permanently removes objects soft-deleted before the given time. It is not scoped to a tenant.
+func (s PgStore) PurgeObjects(deletedBefore time.Time) (int, error) {
+	res, err := s.DB.Exec("DELETE FROM "+s.table()+" WHERE deleted_at < ?", deletedBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted objects")
+	}
+	return res.RowsAffected(), nil
+}
+
+// selectObject returns the object with the ID matching the condition, or nil if there is none.
+func (s PgStore) selectObject(method string, objectID string, condition string) (Record, error) {
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	q.Where("id = ?", objectID).Where(condition)
+
+	schema := s.schema()
+	res := &recordModel{schema: schema}
+	err = s.read(method, func(db orm.DB) error {
+		_, err := db.Query(res, "SELECT "+schema.selectColumns()+" FROM "+s.table()+q.String(), q.args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.records) == 0 {
+		return nil, nil
+	}
+	return res.records[0], nil
+}
+
+// LockObject gets an object by ID like GetObject, and locks it until the transaction of the store ends, so that it
+// cannot change before the transaction writes it. It returns nil if the object is not found.
+func (s PgStore) LockObject(objectID string) (Record, error) {
+	if s.tx == nil {
+		return nil, errors.New("objects can only be locked in a transaction")
+	}
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	q.Where("id = ?", objectID).Where("deleted_at IS NULL")
+
+	schema := s.schema()
+	res := &recordModel{schema: schema}
+	_, err = s.tx.Query(res, "SELECT "+schema.selectColumns()+" FROM "+s.table()+q.String()+" FOR UPDATE", q.args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lock object")
+	}
+	if len(res.records) == 0 {
+		return nil, nil
+	}
+	return res.records[0], nil
+}
+
+// InTransaction returns a copy of the store whose queries run in the transaction.
+func (s PgStore) InTransaction(tx *pg.Tx) Transactional {
+	s.tx = tx
+	return s
+}
+
+// db returns the transaction of the store if it has one, and DB otherwise.
+func (s PgStore) db() orm.DB {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+// runInTransaction runs fn in the transaction of the store if it has one, and in a new transaction otherwise.
+func (s PgStore) runInTransaction(fn func(tx *pg.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
 	}
+	return s.DB.RunInTransaction(fn)
+}
+
+// read runs the query against a replica if the read may be served by one, and against DB otherwise or if the replica
+// fails. The latency of each attempt is recorded against its target. Reads in a transaction run in the transaction.
+func (s PgStore) read(method string, query func(db orm.DB) error) error {
+	if s.tx != nil {
+		return s.timed(method, metrics.PRIMARY, func() error {
+			return query(s.tx)
+		})
+	}
+	if s.readFromReplica() {
+		err := s.timed(method, metrics.REPLICA, func() error {
+			return query(s.Replicas[rand.Intn(len(s.Replicas))])
+		})
+		if err == nil || errors.Is(err, pg.ErrNoRows) || errors.Is(err, ErrNoTenant) {
+			return err
+		}
+		log.Printf("failed to read from replica, falling back to primary: %v", err)
+	}
+	return s.timed(method, metrics.PRIMARY, func() error {
+		return query(s.DB)
+	})
+}
+
+// schema returns the schema of the objects.
+func (s PgStore) schema() *Schema {
+	if s.Schema != nil {
+		return s.Schema
+	}
+	return NewSchema(s.API)
+}
+
+// table returns the quoted name of the store's table.
+func (s PgStore) table() string {
+	return quoteIdent(tableOrDefault(s.Table))
+}
+
+// readFromReplica returns whether reads may be served by a replica: the store must have replicas, and the caller must
+// neither require strong consistency nor have written recently.
+func (s PgStore) readFromReplica() bool {
+	return len(s.Replicas) > 0 && s.Consistency != ConsistencyStrong && !s.Sessions.recent(s.UserID)
+}
+
+// timed runs the query and records its latency against the target.
+func (s PgStore) timed(method string, target string, query func() error) error {
+	start := time.Now()
+	err := query()
+	end := time.Now()
+	metrics.Or(s.Metrics).DatabaseSummary.WithLabelValues(method, target).Observe(end.Sub(start).Seconds())
+	return err
+}
+
+// WithCaller returns a PgStore acting on behalf of the caller, and scoped to the caller's tenant if the store is
+// multi-tenant.
+func (s PgStore) WithCaller(caller Caller) Store {
+	s.UserID = caller.UserID
+	s.Consistency = caller.Consistency
+	if s.MultiTenant {
+		s.TenantID = caller.TenantID
+	}
+	return s
+}
+
+// scope returns a query restricted to the store's tenant if the store is multi-tenant.
+func (s PgStore) scope() (*sqlQuery, error) {
+	q := &sqlQuery{}
+	if !s.MultiTenant {
+		return q, nil
+	}
+	if s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+	return q.Where("tenant_id = ?", s.TenantID), nil
+}
+
+// placeholders returns n comma-separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// recordModel scans the rows returned by a query into records, converting each column to the type of its field.
+type recordModel struct {
+	schema  *Schema
+	records []Record
+	current Record
+}
+
+var _ orm.HooklessModel = (*recordModel)(nil)
+
+func (m *recordModel) Init() error {
+	m.records = nil
 	return nil
 }
 
-// DeleteObject deletes the specified object from the database.
-func (s PgStore) DeleteObject(objectID string) error {
-	object := &generated.Object{ID: objectID}
-	return s.DB.Delete(object)
+func (m *recordModel) NewModel() orm.ColumnScanner {
+	m.current = Record{}
+	return m
+}
+
+func (m *recordModel) AddModel(orm.ColumnScanner) error {
+	m.records = append(m.records, m.current)
+	return nil
+}
+
+func (m *recordModel) ScanColumn(colIdx int, colName string, rd types.Reader, n int) error {
+	f, ok := m.schema.byColumn[colName]
+	if !ok || f.Name == "" {
+		return errors.Errorf("unexpected column %s", colName)
+	}
+	// a length of -1 is NULL
+	if n == -1 {
+		m.current[f.Name] = f.zero()
+		return nil
+	}
+	var err error
+	switch f.Type {
+	case model.FieldTypeInt:
+		m.current[f.Name], err = types.ScanInt64(rd, n)
+	case model.FieldTypeFloat:
+		m.current[f.Name], err = types.ScanFloat64(rd, n)
+	case model.FieldTypeBoolean:
+		var b bool
+		err = types.Scan(&b, rd, n)
+		m.current[f.Name] = b
+	case model.FieldTypeTimestamp:
+		m.current[f.Name], err = types.ScanTime(rd, n)
+	default:
+		m.current[f.Name], err = types.ScanString(rd, n)
+	}
+	return err
 }
@@ -5,16 +5,13 @@ import (
 	"testing"
 
 	"github.com/go-pg/pg"
-	"github.com/google/uuid"
-	"github.com/gracew/widget-proxy/generated"
 	"github.com/gracew/widget-proxy/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
 type PgTestSuite struct {
-	suite.Suite
-	s PgStore
+	StoreTestSuite
 }
 
 var db *pg.DB
@@ -25,112 +22,113 @@ func (suite *PgTestSuite) SetupTest() {
 		port = "5432"
 	}
 	db = pg.Connect(&pg.Options{User: "postgres", Password: "postgres", Addr: "localhost:" + port})
-	suite.s = PgStore{
-		DB: db,
-		API: model.API{
-			Operations: &model.OperationDefinition{
-				List: &model.ListDefinition{
-					Filter: []string{"test"},
-				},
-				Update: &model.UpdateDefinition{
-					Actions: []model.ActionDefinition{
-						model.ActionDefinition{Name: "action", Fields: []string{"test"}},
-					},
-				},
-			},
-		},
+	suite.newStore = func(api model.API, multiTenant bool) Store {
+		return PgStore{DB: db, API: api, MultiTenant: multiTenant}
 	}
-	err := suite.s.CreateSchema()
-	assert.NoError(suite.T(), err)
+	suite.setup()
 }
 
 func (suite *PgTestSuite) TearDownTest() {
 	db.Close()
 }
 
-func (suite *PgTestSuite) TestCreateGet() {
-	obj := &generated.Object{Test: "test", CreatedBy: "userID"}
-	createRes, err := suite.s.CreateObject(obj)
+func (suite *PgTestSuite) TestAudit() {
+	a := AuditedStore{Delegate: suite.s.(Transactional), DB: db}
+	err := a.CreateSchema()
 	assert.NoError(suite.T(), err)
-	assert.Equal(suite.T(), obj.Test, createRes.Test)
-	assert.Equal(suite.T(), "userID", createRes.CreatedBy)
-	assert.NotEmpty(suite.T(), obj.ID)
-	assert.NotEmpty(suite.T(), obj.CreatedAt)
+	s := a.WithCaller(Caller{UserID: "userID", RequestID: "requestID"})
 
-	getRes, err := suite.s.GetObject(createRes.ID)
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := s.CreateObject(obj)
 	assert.NoError(suite.T(), err)
-	assert.Equal(suite.T(), createRes, getRes)
-}
 
-func (suite *PgTestSuite) TestGetUnknownID() {
-	res, err := suite.s.GetObject(uuid.New().String())
+	update := Record{"id": createRes.ID(), "test": "test2"}
+	_, err = s.UpdateObject(update, "action", AnyVersion)
 	assert.NoError(suite.T(), err)
-	assert.Nil(suite.T(), res)
-}
 
-func (suite *PgTestSuite) TestList() {
-	obj1 := &generated.Object{Test: "test", CreatedBy: "userID"}
-	_, err := suite.s.CreateObject(obj1)
+	err = s.DeleteObject(createRes.ID(), AnyVersion)
 	assert.NoError(suite.T(), err)
 
-	obj2 := &generated.Object{Test: "test", CreatedBy: "userID"}
-	_, err = suite.s.CreateObject(obj2)
+	entries, err := a.History(createRes.ID(), "")
 	assert.NoError(suite.T(), err)
-
-	res, err := suite.s.ListObjects(100, nil)
-	assert.NoError(suite.T(), err)
-	ids := []string{}
-	for _, o := range res {
-		ids = append(ids, o.ID)
-	}
-	assert.Contains(suite.T(), ids, obj1.ID)
-	assert.Contains(suite.T(), ids, obj2.ID)
+	assert.Len(suite.T(), entries, 3)
+	assert.Equal(suite.T(), "create", entries[0].Operation)
+	assert.Equal(suite.T(), "userID", entries[0].UserID)
+	assert.Equal(suite.T(), "requestID", entries[0].RequestID)
+	assert.Equal(suite.T(), "action", entries[1].Operation)
+	assert.Equal(suite.T(), FieldChange{Before: "test", After: "test2"}, entries[1].Changes["test"])
+	assert.Equal(suite.T(), FieldChange{Before: float64(1), After: float64(2)}, entries[1].Changes["version"])
+	assert.Equal(suite.T(), "delete", entries[2].Operation)
+	assert.Equal(suite.T(), "test2", entries[2].Changes["test"].Before)
 }
 
-func (suite *PgTestSuite) TestListFilter() {
-	obj1 := &generated.Object{Test: "test1", CreatedBy: "userID"}
-	_, err := suite.s.CreateObject(obj1)
+func (suite *PgTestSuite) TestAuditRollback() {
+	a := AuditedStore{Delegate: suite.s.(Transactional), DB: db}
+	err := a.CreateSchema()
+	assert.NoError(suite.T(), err)
+	s := a.WithCaller(Caller{UserID: "userID"})
+	createRes, err := s.CreateObject(Record{"test": "test", "createdBy": "userID"})
 	assert.NoError(suite.T(), err)
 
-	obj2 := &generated.Object{Test: "test2", CreatedBy: "userID"}
-	_, err = suite.s.CreateObject(obj2)
+	// a mutation whose audit entry cannot be written is not applied
+	_, err = db.Exec("ALTER TABLE audit_entries RENAME TO audit_entries_unavailable")
+	assert.NoError(suite.T(), err)
+	_, updateErr := s.UpdateObject(Record{"id": createRes.ID(), "test": "test2"}, "action", AnyVersion)
+	_, createErr := s.CreateObject(Record{"test": "test3", "createdBy": "userID"})
+	_, err = db.Exec("ALTER TABLE audit_entries_unavailable RENAME TO audit_entries")
 	assert.NoError(suite.T(), err)
+	assert.Error(suite.T(), updateErr)
+	assert.Error(suite.T(), createErr)
 
-	res, err := suite.s.ListObjects(100, &Filter{Field: "test", Value: "test1"})
+	getRes, err := s.GetObject(createRes.ID())
 	assert.NoError(suite.T(), err)
-	ids := []string{}
-	for _, o := range res {
-		ids = append(ids, o.ID)
-	}
-	assert.Contains(suite.T(), ids, obj1.ID)
-	assert.NotContains(suite.T(), ids, obj2.ID)
+	assert.Equal(suite.T(), createRes, getRes)
+	res, err := s.ListObjects(100, &Filter{Field: "test", Value: "test3"}, nil)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), res)
 }
 
-func (suite *PgTestSuite) TestUpdate() {
-	obj := &generated.Object{Test: "test", CreatedBy: "userID"}
-	createRes, err := suite.s.CreateObject(obj)
+func (suite *PgTestSuite) TestMigrations() {
+	s := PgStore{DB: db, API: suite.api}
+	migrations, err := s.PlanMigrations()
 	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), migrations)
 
-	update := &generated.Object{ID: obj.ID, Test: "test2", CreatedBy: "userID2"}
-	updateRes, err := suite.s.UpdateObject(update, "action")
+	_, err = db.Exec("ALTER TABLE objects DROP COLUMN test")
+	assert.NoError(suite.T(), err)
+	_, err = db.Exec("ALTER TABLE objects ADD COLUMN extra text")
 	assert.NoError(suite.T(), err)
-	assert.Equal(suite.T(), update.Test, updateRes.Test)
-	// CreatedBy is unchanged since it's not an action field
-	assert.Equal(suite.T(), createRes.CreatedBy, updateRes.CreatedBy)
-	assert.Equal(suite.T(), createRes.CreatedAt, updateRes.CreatedAt)
-}
 
-func (suite *PgTestSuite) TestDelete() {
-	obj := &generated.Object{Test: "test", CreatedBy: "userID"}
-	createRes, err := suite.s.CreateObject(obj)
+	migrations, err = s.PlanMigrations()
 	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []Migration{
+		Migration{SQL: `ALTER TABLE "objects" ADD COLUMN "test" text`},
+		Migration{SQL: `ALTER TABLE "objects" DROP COLUMN "extra"`, Destructive: true},
+		Migration{SQL: `CREATE INDEX CONCURRENTLY IF NOT EXISTS objects_test_idx ON "objects" ("test")`, Concurrent: true},
+	}, migrations)
 
-	err = suite.s.DeleteObject(createRes.ID)
+	err = s.Migrate(migrations)
+	assert.Error(suite.T(), err)
+
+	s.AllowDestructiveMigrations = true
+	err = s.Migrate(migrations)
+	assert.NoError(suite.T(), err)
+
+	migrations, err = s.PlanMigrations()
 	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), migrations)
 
-	nilRes, err := suite.s.GetObject(createRes.ID)
+	// the migrations applied together share the next version of the table
+	var versions []int64
+	_, err = db.Query(&versions, `SELECT DISTINCT version FROM schema_migrations WHERE "table" = 'objects'
+		ORDER BY version DESC`)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), len(versions) > 0)
+	var count int
+	_, err = db.QueryOne(pg.Scan(&count), `SELECT count(*) FROM schema_migrations WHERE "table" = 'objects'
+		AND version = ?`, versions[0])
 	assert.NoError(suite.T(), err)
-	assert.Nil(suite.T(), nilRes)
+	assert.Equal(suite.T(), 3, count)
 }
 
 func TestPgTestSuite(t *testing.T) {
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/orm"
+	"github.com/gracew/widget-proxy/generated"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+// Record is an object of an API, keyed by JSON field name. Values are strings, int64s, float64s, bools, time.Times or
+// nil, according to the types of the fields in the schema of the API.
+type Record map[string]interface{}
+
+// ID returns the id of the record.
+func (r Record) ID() string {
+	id, _ := r["id"].(string)
+	return id
+}
+
+// CreatedBy returns the user that created the record.
+func (r Record) CreatedBy() string {
+	createdBy, _ := r["createdBy"].(string)
+	return createdBy
+}
+
+// Version returns the version of the record.
+func (r Record) Version() int64 {
+	version, _ := r["version"].(int64)
+	return version
+}
+
+// copy returns a shallow copy of the record.
+func (r Record) copy() Record {
+	res := Record{}
+	for k, v := range r {
+		res[k] = v
+	}
+	return res
+}
+
+// Schema is the set of fields of the records of an API: the system fields followed by the fields declared in the API
+// definition, or the fields of generated.Object if none are declared.
+type Schema struct {
+	Fields []SchemaField
+	byName map[string]SchemaField
+	// byColumn includes the hidden columns
+	byColumn map[string]SchemaField
+	// hidden are the columns stored with every record that are not fields of the record, such as tenant_id
+	hidden []SchemaField
+	// columns are the Postgres columns of the table of the records
+	columns []column
+	// ignoreUnknown ignores fields that are not in the schema when decoding, as decoding a generated.Object does
+	ignoreUnknown bool
+}
+
+// SchemaField is a field of a Schema.
+type SchemaField struct {
+	Name   string
+	Column string
+	Type   model.FieldType
+	// System fields are maintained by the store.
+	System bool
+	// Nullable fields are nil if they have no value. Other fields, such as those of generated.Object, have the zero
+	// value of their type instead.
+	Nullable bool
+}
+
+// zero returns the value of the field when it has no value.
+func (f SchemaField) zero() interface{} {
+	if f.Nullable {
+		return nil
+	}
+	switch f.Type {
+	case model.FieldTypeInt:
+		return int64(0)
+	case model.FieldTypeFloat:
+		return float64(0)
+	case model.FieldTypeBoolean:
+		return false
+	case model.FieldTypeTimestamp:
+		return time.Time{}
+	}
+	return ""
+}
+
+// hiddenColumns are stored with every record, but are not fields of the record.
+var hiddenColumns = []SchemaField{
+	SchemaField{Column: "tenant_id", Type: model.FieldTypeString, System: true},
+	SchemaField{Column: "deleted_at", Type: model.FieldTypeTimestamp, System: true},
+}
+
+// NewSchema returns the schema of the fields declared in the API definition, which should have been validated with
+// config.ValidateDefinitions. If the definition declares no fields, the schema is that of generated.Object.
+func NewSchema(api model.API) *Schema {
+	if len(api.Fields) == 0 {
+		return objectSchema()
+	}
+
+	s := newSchema()
+	for _, f := range model.SystemFields {
+		s.add(SchemaField{Name: f.Name, Column: underscore(f.Name), Type: f.Type, System: true, Nullable: true})
+	}
+	for _, f := range api.Fields {
+		s.add(SchemaField{Name: f.Name, Column: underscore(f.Name), Type: f.Type, Nullable: true})
+	}
+	for _, f := range hiddenColumns {
+		s.byColumn[f.Column] = f
+		s.hidden = append(s.hidden, f)
+	}
+	s.columns = declaredColumns(append(append([]SchemaField{}, s.Fields...), s.hidden...))
+	return s
+}
+
+// objectSchema returns the schema of generated.Object. Its columns are those go-pg derives from the struct, so that
+// tables created before fields could be declared keep their columns.
+func objectSchema() *Schema {
+	system := map[string]bool{}
+	for _, f := range model.SystemFields {
+		system[f.Name] = true
+	}
+
+	s := newSchema()
+	s.ignoreUnknown = true
+	table := objectTable()
+	for _, f := range table.Fields {
+		name := strings.Split(f.Field.Tag.Get("json"), ",")[0]
+		field := SchemaField{
+			Name:     name,
+			Column:   f.SQLName,
+			Type:     goFieldType(f.Type),
+			System:   system[name],
+			Nullable: f.Type.Kind() == reflect.Ptr,
+		}
+		if name == "" || name == "-" {
+			field.Name = ""
+			field.System = true
+			s.byColumn[field.Column] = field
+			s.hidden = append(s.hidden, field)
+			continue
+		}
+		s.add(field)
+	}
+	s.columns = tableColumns(table)
+	return s
+}
+
+// objectTable returns the go-pg table of generated.Object.
+func objectTable() *orm.Table {
+	return orm.GetTable(reflect.TypeOf(generated.Object{}))
+}
+
+func newSchema() *Schema {
+	return &Schema{byName: map[string]SchemaField{}, byColumn: map[string]SchemaField{}}
+}
+
+func (s *Schema) add(f SchemaField) {
+	s.Fields = append(s.Fields, f)
+	s.byName[f.Name] = f
+	s.byColumn[f.Column] = f
+}
+
+// Field returns the field with the given JSON name.
+func (s *Schema) Field(name string) (SchemaField, bool) {
+	f, ok := s.byName[name]
+	return f, ok
+}
+
+// timestamp returns the value of a timestamp maintained by a store that generates timestamps itself: a time if the
+// timestamps of the schema are timestamps, and a string in timestampFormat otherwise, as for generated.Object.
+func (s *Schema) timestamp(t time.Time) interface{} {
+	if f, _ := s.Field("createdAt"); f.Type == model.FieldTypeTimestamp {
+		return t.UTC()
+	}
+	return t.UTC().Format(timestampFormat)
+}
+
+// goFieldType returns the field type of a field of generated.Object.
+func goFieldType(t reflect.Type) model.FieldType {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return model.FieldTypeTimestamp
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return model.FieldTypeBoolean
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return model.FieldTypeInt
+	case reflect.Float32, reflect.Float64:
+		return model.FieldTypeFloat
+	}
+	return model.FieldTypeString
+}
+
+// ValidationError is returned by Decode when a record does not match its schema.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Decode reads a record from a JSON object, converting each value to the type of its field. System fields are
+// ignored, as they are maintained by the store. A *ValidationError is returned if the JSON is not an object, has a
+// field that is not in the schema, or has a value of the wrong type. Unknown fields are ignored by the schema of
+// generated.Object.
+func (s *Schema) Decode(r io.Reader) (Record, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, &ValidationError{Message: "invalid JSON object: " + err.Error()}
+	}
+
+	record := Record{}
+	for name, value := range raw {
+		f, ok := s.byName[name]
+		if !ok && s.ignoreUnknown {
+			continue
+		}
+		if !ok {
+			return nil, &ValidationError{Field: name, Message: "unknown field"}
+		}
+		if f.System {
+			continue
+		}
+		converted, err := convert(f.Type, value)
+		if err != nil {
+			return nil, &ValidationError{Field: name, Message: err.Error()}
+		}
+		if converted == nil {
+			converted = f.zero()
+		}
+		record[name] = converted
+	}
+	return record, nil
+}
+
+// complete sets each field the record has no value for to the value of a field without a value.
+func (s *Schema) complete(record Record) Record {
+	for _, f := range s.Fields {
+		if _, ok := record[f.Name]; !ok {
+			record[f.Name] = f.zero()
+		}
+	}
+	return record
+}
+
+// convert converts a value decoded from JSON with UseNumber to the type of a field.
+func convert(t model.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch t {
+	case model.FieldTypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case model.FieldTypeInt:
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case model.FieldTypeFloat:
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case model.FieldTypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case model.FieldTypeTimestamp:
+		if s, ok := value.(string); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return ts, nil
+			}
+			return nil, errors.New("expected an RFC 3339 timestamp")
+		}
+	}
+	return nil, errors.Errorf("expected a value of type %s", t)
+}
+
+// sqlType returns the Postgres type of the column of a field.
+func sqlType(t model.FieldType) string {
+	switch t {
+	case model.FieldTypeInt:
+		return "bigint"
+	case model.FieldTypeFloat:
+		return "double precision"
+	case model.FieldTypeBoolean:
+		return "boolean"
+	case model.FieldTypeTimestamp:
+		return "timestamptz"
+	}
+	return "text"
+}
+
+// declaredColumns returns the columns of the table of records with the fields.
+func declaredColumns(fields []SchemaField) []column {
+	var columns []column
+	for _, f := range fields {
+		c := column{Name: f.Column, Type: sqlType(f.Type)}
+		var constraints string
+		switch f.Column {
+		case "id":
+			c.Type = "uuid"
+			constraints = " DEFAULT gen_random_uuid()"
+			c.HasDefault = true
+		case "version":
+			constraints = " NOT NULL DEFAULT 1"
+			c.NotNull = true
+			c.HasDefault = true
+		case "created_at", "updated_at":
+			constraints = " DEFAULT now()"
+			c.HasDefault = true
+		}
+		c.Definition = quoteIdent(c.Name) + " " + c.Type + constraints
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+// selectColumns returns the columns of the fields of the records, for a SELECT or RETURNING clause.
+func (s *Schema) selectColumns() string {
+	var columns []string
+	for _, f := range s.Fields {
+		columns = append(columns, quoteIdent(f.Column))
+	}
+	return strings.Join(columns, ", ")
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+var schemaAPI = model.API{Fields: []model.FieldDefinition{
+	model.FieldDefinition{Name: "title", Type: model.FieldTypeString},
+	model.FieldDefinition{Name: "pageCount", Type: model.FieldTypeInt},
+	model.FieldDefinition{Name: "rating", Type: model.FieldTypeFloat},
+	model.FieldDefinition{Name: "published", Type: model.FieldTypeBoolean},
+	model.FieldDefinition{Name: "publishedAt", Type: model.FieldTypeTimestamp},
+}}
+
+// storeTestFields are declared by the variants of StoreTestSuite for APIs with declared fields.
+var storeTestFields = append([]model.FieldDefinition{
+	model.FieldDefinition{Name: "test", Type: model.FieldTypeString},
+}, schemaAPI.Fields...)
+
+func TestSchemaDecode(t *testing.T) {
+	s := NewSchema(schemaAPI)
+
+	record, err := s.Decode(strings.NewReader(`{"id": "ignored", "title": "t", "pageCount": 3, "rating": 4.5,
+		"published": true, "publishedAt": "2020-01-02T03:04:05Z"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Record{
+		"title":       "t",
+		"pageCount":   int64(3),
+		"rating":      4.5,
+		"published":   true,
+		"publishedAt": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}, record)
+
+	record, err = s.Decode(strings.NewReader(`{"title": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Record{"title": nil}, record)
+
+	for body, message := range map[string]string{
+		`{"author": "a"}`:              "author: unknown field",
+		`{"title": 1}`:                 "title: expected a value of type STRING",
+		`{"pageCount": 1.5}`:           "pageCount: expected a value of type INT",
+		`{"publishedAt": "yesterday"}`: "publishedAt: expected an RFC 3339 timestamp",
+		`["title"]`:                    "invalid JSON object: json: cannot unmarshal array into Go value of type map[string]interface {}",
+		`{"published": "true"}`:        "published: expected a value of type BOOLEAN",
+		`{"rating": "4.5"}`:            "rating: expected a value of type FLOAT",
+	} {
+		_, err := s.Decode(strings.NewReader(body))
+		assert.Equal(t, message, err.Error(), body)
+		assert.IsType(t, &ValidationError{}, err)
+	}
+}
+
+func TestSchemaDecodeObject(t *testing.T) {
+	s := NewSchema(model.API{})
+
+	// as when decoding a generated.Object, unknown fields are ignored and null is the zero value
+	record, err := s.Decode(strings.NewReader(`{"test": null, "author": "a"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, Record{"test": ""}, record)
+
+	_, err = s.Decode(strings.NewReader(`{"test": 1}`))
+	assert.Equal(t, "test: expected a value of type STRING", err.Error())
+}
+
+func TestSchemaColumns(t *testing.T) {
+	s := NewSchema(schemaAPI)
+	assert.Equal(t, `"id", "created_by", "created_at", "version", "updated_at", "updated_by", "title", "page_count", `+
+		`"rating", "published", "published_at"`, s.selectColumns())
+	assert.Equal(t, `CREATE TABLE "books" ("id" uuid DEFAULT gen_random_uuid(), "created_by" text, `+
+		`"created_at" timestamptz DEFAULT now(), "version" bigint NOT NULL DEFAULT 1, "updated_at" timestamptz DEFAULT now(), `+
+		`"updated_by" text, "title" text, "page_count" bigint, "rating" double precision, "published" boolean, `+
+		`"published_at" timestamptz, "tenant_id" text, "deleted_at" timestamptz, PRIMARY KEY ("id"))`,
+		createTableSQL("books", s.columns, []string{"id"}))
+
+	migrations := columnMigrations("books", s.columns, []existingColumn{
+		existingColumn{ColumnName: "id", UdtName: "uuid"},
+		existingColumn{ColumnName: "title", UdtName: "text"},
+		existingColumn{ColumnName: "page_count", UdtName: "text"},
+		existingColumn{ColumnName: "author", UdtName: "text"},
+	})
+	assert.Contains(t, migrations, Migration{SQL: `ALTER TABLE "books" ADD COLUMN "rating" double precision`})
+	assert.Contains(t, migrations, Migration{
+		SQL:         `ALTER TABLE "books" ALTER COLUMN "page_count" TYPE bigint USING "page_count"::bigint`,
+		Destructive: true,
+	})
+	assert.Contains(t, migrations, Migration{SQL: `ALTER TABLE "books" DROP COLUMN "author"`, Destructive: true})
+	assert.NotContains(t, migrations, Migration{SQL: `ALTER TABLE "books" ADD COLUMN "title" text`})
+}
+
+func TestRecord(t *testing.T) {
+	r := Record{"id": "id", "createdBy": "user", "version": int64(2)}
+	assert.Equal(t, "id", r.ID())
+	assert.Equal(t, "user", r.CreatedBy())
+	assert.Equal(t, int64(2), r.Version())
+	assert.Equal(t, int64(0), Record{}.Version())
+}
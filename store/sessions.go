@@ -0,0 +1,54 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteSessions records when each user last wrote, so that a store serving reads from replicas can route the user's
+// reads to the primary until the replicas have caught up with the write. It is safe for concurrent use.
+type WriteSessions struct {
+	// Window is how long after a write the user's reads are routed to the primary.
+	Window time.Duration
+
+	mu        sync.Mutex
+	lastWrite map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewWriteSessions returns WriteSessions that route a user's reads to the primary for the window after each write.
+func NewWriteSessions(window time.Duration) *WriteSessions {
+	return &WriteSessions{Window: window, lastWrite: map[string]time.Time{}, now: time.Now}
+}
+
+// record notes that the user has just written.
+func (w *WriteSessions) record(userID string) {
+	if w == nil || userID == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.lastWrite[userID] = now
+	// forget users whose window has passed, at most once per window
+	if now.Sub(w.lastSweep) > w.Window {
+		for id, t := range w.lastWrite {
+			if now.Sub(t) > w.Window {
+				delete(w.lastWrite, id)
+			}
+		}
+		w.lastSweep = now
+	}
+}
+
+// recent returns whether the user has written within the window.
+func (w *WriteSessions) recent(userID string) bool {
+	if w == nil || userID == "" {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.lastWrite[userID]
+	return ok && w.now().Sub(t) <= w.Window
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSessions(t *testing.T) {
+	now := time.Now()
+	sessions := NewWriteSessions(time.Second)
+	sessions.now = func() time.Time { return now }
+
+	assert.False(t, sessions.recent("user"))
+	sessions.record("user")
+	assert.True(t, sessions.recent("user"))
+	assert.False(t, sessions.recent("another"))
+
+	now = now.Add(2 * time.Second)
+	assert.False(t, sessions.recent("user"))
+	sessions.record("another")
+	assert.NotContains(t, sessions.lastWrite, "user")
+}
+
+func TestReadFromReplica(t *testing.T) {
+	replica := pg.Connect(&pg.Options{})
+	defer replica.Close()
+	sessions := NewWriteSessions(time.Minute)
+	sessions.record("writer")
+
+	assert.False(t, PgStore{}.readFromReplica())
+	s := PgStore{Replicas: []*pg.DB{replica}, Sessions: sessions}
+	assert.True(t, s.WithCaller(Caller{UserID: "reader"}).(PgStore).readFromReplica())
+	assert.False(t, s.WithCaller(Caller{UserID: "reader", Consistency: ConsistencyStrong}).(PgStore).readFromReplica())
+	assert.False(t, s.WithCaller(Caller{UserID: "writer"}).(PgStore).readFromReplica())
+}
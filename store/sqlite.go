@@ -0,0 +1,547 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+
+	// registers the sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMaxVariables is the maximum number of parameters SQLite accepts in a single statement.
+const sqliteMaxVariables = 32766
+
+// SQLiteStore implements the Store interface using an embedded SQLite database, for local development and tests. The
+// table has the columns of the schema of the API like the Postgres table, and the store applies the same defaults
+// Postgres would.
+type SQLiteStore struct {
+	Store
+	API model.API
+	// Schema is the schema of the objects. If nil, the schema of API is used.
+	Schema *Schema
+	// DB should be limited to a single open connection, as SQLite allows only one writer at a time.
+	DB *sql.DB
+	// MultiTenant scopes every query to TenantID. Queries fail with ErrNoTenant if TenantID is not set.
+	MultiTenant bool
+	TenantID    string
+	// UserID is recorded as the last modifier of the objects the store updates.
+	UserID string
+	// Table is the table objects are stored in. If empty, DefaultTable is used.
+	Table string
+}
+
+// sqlDB is implemented by both *sql.DB and *sql.Tx.
+type sqlDB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateSchema creates the object table if it does not exist, and adds any columns it is missing.
+func (s SQLiteStore) CreateSchema() error {
+	var definitions []string
+	for _, f := range s.columns() {
+		definitions = append(definitions, sqliteColumnDefinition(f))
+	}
+	definitions = append(definitions, "PRIMARY KEY (id)")
+	_, err := s.DB.Exec("CREATE TABLE IF NOT EXISTS " + s.table() + " (" + strings.Join(definitions, ", ") + ")")
+	if err != nil {
+		return errors.Wrap(err, "failed to create table")
+	}
+
+	rows, err := s.DB.Query("SELECT name FROM pragma_table_info(?)", tableOrDefault(s.Table))
+	if err != nil {
+		return errors.Wrap(err, "failed to read existing columns")
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "failed to read existing columns")
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, f := range s.columns() {
+		if existing[f.Column] {
+			continue
+		}
+		_, err = s.DB.Exec("ALTER TABLE " + s.table() + " ADD COLUMN " + sqliteColumnDefinition(f))
+		if err != nil {
+			return errors.Wrapf(err, "failed to add column %s", f.Column)
+		}
+	}
+	return nil
+}
+
+// CreateObject inserts the object into the database.
+func (s SQLiteStore) CreateObject(obj Record) (Record, error) {
+	res, err := s.CreateObjects([]Record{obj})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// CreateObjects inserts the objects into the database with multi-row INSERTs in a single transaction.
+func (s SQLiteStore) CreateObjects(objs []Record) ([]Record, error) {
+	if s.MultiTenant && s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+
+	schema := s.schema()
+	columns := s.columns()
+	now := time.Now()
+	res := make([]Record, len(objs))
+	for i, obj := range objs {
+		created := Record{}
+		for _, f := range schema.Fields {
+			if value, ok := obj[f.Name]; ok && !f.System {
+				created[f.Name] = value
+			}
+		}
+		created["id"] = uuid.New().String()
+		created["createdBy"] = obj.CreatedBy()
+		created["createdAt"] = schema.timestamp(now)
+		created["version"] = int64(1)
+		created["updatedAt"] = schema.timestamp(now)
+		created["updatedBy"] = obj.CreatedBy()
+		res[i] = schema.complete(created)
+	}
+
+	batchSize := sqliteMaxVariables / len(columns)
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		for start := 0; start < len(res); start += batchSize {
+			end := start + batchSize
+			if end > len(res) {
+				end = len(res)
+			}
+			var rows []string
+			var args []interface{}
+			for _, obj := range res[start:end] {
+				for _, f := range columns {
+					var value interface{}
+					switch {
+					case f.Column == "tenant_id" && s.MultiTenant:
+						value = s.TenantID
+					case f.Name != "":
+						value = obj[f.Name]
+					}
+					args = append(args, sqliteValue(value))
+				}
+				rows = append(rows, "("+placeholders(len(columns))+")")
+			}
+			_, err := tx.Exec("INSERT INTO "+s.table()+" ("+sqliteColumns(columns)+") VALUES "+
+				strings.Join(rows, ", "), args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to insert into database")
+	}
+
+	return res, nil
+}
+
+// GetObject gets an object by ID. It returns nil if the object is not found.
+func (s SQLiteStore) GetObject(objectID string) (Record, error) {
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	return s.selectObject(q.Where("id = ?", objectID).Where("deleted_at IS NULL"))
+}
+
+// ListObjects retrieves the specified number of objects following after, ordered by the declared sort order or by
+// created_at DESC if none is declared.
+func (s SQLiteStore) ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error) {
+	if filter != nil && !validFilter(s.API, *filter) {
+		return nil, errors.New("invalid filter field: " + filter.Field)
+	}
+
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		q.Where(quoteIdent(underscore(filter.Field))+" = ?", filter.Value)
+	}
+	q.Where("deleted_at IS NULL")
+	if after != nil {
+		q.after(s.API, after, sqliteValue)
+	}
+
+	schema := s.schema()
+	rows, err := s.DB.Query("SELECT "+schema.selectColumns()+" FROM "+s.table()+q.String()+
+		" ORDER BY "+strings.Join(order(s.API), ", ")+" LIMIT ?", append(q.args, pageSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		record, err := scanRecord(schema, rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// UpdateObject updates the specified object in the database, increments its version and records the store's user as
+// its last modifier. If expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict
+// is returned.
+func (s SQLiteStore) UpdateObject(obj Record, actionName string, expectedVersion int64) (Record, error) {
+	return s.updateObject(s.DB, obj, actionName, expectedVersion)
+}
+
+// UpdateObjects applies the action to the objects in a single transaction. The version of each object is the version
+// it is expected to have, or AnyVersion. If any object cannot be updated, no object is updated and an *ItemError is
+// returned.
+func (s SQLiteStore) UpdateObjects(objs []Record, actionName string) ([]Record, error) {
+	res := make([]Record, len(objs))
+	err := s.inTransaction(func(tx *sql.Tx) error {
+		for i, obj := range objs {
+			var err error
+			res[i], err = s.updateObject(tx, obj, actionName, obj.Version())
+			if err != nil {
+				return &ItemError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PatchObject updates only the given fields of the specified object in the database, increments its version and
+// records the store's user as its last modifier. If expectedVersion is not AnyVersion and does not match the object's
+// version, ErrVersionConflict is returned.
+func (s SQLiteStore) PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error) {
+	return s.patchObject(s.DB, obj, fields, expectedVersion)
+}
+
+func (s SQLiteStore) updateObject(db sqlDB, obj Record, actionName string, expectedVersion int64) (Record, error) {
+	// update only the fields specified by the action
+	action := FindAction(s.API, actionName)
+	if action == nil {
+		return nil, errors.New("unknown action " + actionName)
+	}
+	return s.patchObject(db, obj, action.Fields, expectedVersion)
+}
+
+func (s SQLiteStore) patchObject(db sqlDB, obj Record, fields []string, expectedVersion int64) (Record, error) {
+	schema := s.schema()
+	var sets []string
+	var args []interface{}
+	for _, name := range fields {
+		f, ok := schema.Field(name)
+		if !ok {
+			return nil, errors.New("unknown field " + name)
+		}
+		// system columns are set below, even if the action lists them
+		if systemColumns[f.Column] {
+			continue
+		}
+		value, ok := obj[name]
+		if !ok {
+			value = f.zero()
+		}
+		sets = append(sets, quoteIdent(f.Column)+" = ?")
+		args = append(args, sqliteValue(value))
+	}
+	sets = append(sets, "version = version + 1", "updated_at = ?", "updated_by = ?")
+	args = append(args, timestamp(), s.UserID)
+
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	q.Where("id = ?", obj.ID()).Where("deleted_at IS NULL")
+	if expectedVersion != AnyVersion {
+		q.Where("version = ?", expectedVersion)
+	}
+	row := db.QueryRow("UPDATE "+s.table()+" SET "+strings.Join(sets, ", ")+q.String()+
+		" RETURNING "+schema.selectColumns(), append(args, q.args...)...)
+	res, err := scanRecord(schema, row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) && expectedVersion != AnyVersion {
+			return nil, ErrVersionConflict
+		}
+		return nil, errors.Wrap(err, "failed to update object")
+	}
+	return res, nil
+}
+
+// DeleteObject deletes the specified object from the database, or marks it as deleted if the API definition enables
+// soft deletes. If expectedVersion is not AnyVersion and does not match the object's version, ErrVersionConflict is
+// returned.
+func (s SQLiteStore) DeleteObject(objectID string, expectedVersion int64) error {
+	return s.deleteObject(s.DB, objectID, expectedVersion)
+}
+
+// DeleteObjects deletes the objects in a single transaction. The version of each object is the version it is expected
+// to have, or AnyVersion. If any object cannot be deleted, no object is deleted and an *ItemError is returned.
+func (s SQLiteStore) DeleteObjects(objs []Record) error {
+	return s.inTransaction(func(tx *sql.Tx) error {
+		for i, obj := range objs {
+			err := s.deleteObject(tx, obj.ID(), obj.Version())
+			if err != nil {
+				return &ItemError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+func (s SQLiteStore) deleteObject(db sqlDB, objectID string, expectedVersion int64) error {
+	q, err := s.scope()
+	if err != nil {
+		return err
+	}
+	q.Where("id = ?", objectID).Where("deleted_at IS NULL")
+	if expectedVersion != AnyVersion {
+		q.Where("version = ?", expectedVersion)
+	}
+
+	table := s.table()
+	var res sql.Result
+	if softDelete(s.API) {
+		now := timestamp()
+		res, err = db.Exec("UPDATE "+table+" SET deleted_at = ?, version = version + 1, updated_at = ?, updated_by = ?"+
+			q.String(), append([]interface{}{now, now, s.UserID}, q.args...)...)
+	} else {
+		res, err = db.Exec("DELETE FROM "+table+q.String(), q.args...)
+	}
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 && expectedVersion != AnyVersion {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// GetDeletedObject gets a soft-deleted object by ID. It returns nil if no such object is found.
+func (s SQLiteStore) GetDeletedObject(objectID string) (Record, error) {
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	return s.selectObject(q.Where("id = ?", objectID).Where("deleted_at IS NOT NULL"))
+}
+
+// RestoreObject clears the deletion time of a soft-deleted object and increments its version. It returns nil if no
+// such object is found.
+func (s SQLiteStore) RestoreObject(objectID string) (Record, error) {
+	q, err := s.scope()
+	if err != nil {
+		return nil, err
+	}
+	q.Where("id = ?", objectID).Where("deleted_at IS NOT NULL")
+
+	schema := s.schema()
+	row := s.DB.QueryRow("UPDATE "+s.table()+
+		" SET deleted_at = NULL, version = version + 1, updated_at = ?, updated_by = ?"+q.String()+
+		" RETURNING "+schema.selectColumns(), append([]interface{}{timestamp(), s.UserID}, q.args...)...)
+	res, err := scanRecord(schema, row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to restore object")
+	}
+	return res, nil
+}
+
+// PurgeObjects permanently removes objects soft-deleted before the given time. It is not scoped to a tenant.
+func (s SQLiteStore) PurgeObjects(deletedBefore time.Time) (int, error) {
+	res, err := s.DB.Exec("DELETE FROM "+s.table()+" WHERE deleted_at < ?",
+		deletedBefore.UTC().Format(timestampFormat))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted objects")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted objects")
+	}
+	return int(affected), nil
+}
+
+// WithCaller returns a SQLiteStore acting on behalf of the caller, and scoped to the caller's tenant if the store is
+// multi-tenant.
+func (s SQLiteStore) WithCaller(caller Caller) Store {
+	s.UserID = caller.UserID
+	if s.MultiTenant {
+		s.TenantID = caller.TenantID
+	}
+	return s
+}
+
+// scope returns a query restricted to the store's tenant if the store is multi-tenant.
+func (s SQLiteStore) scope() (*sqlQuery, error) {
+	q := &sqlQuery{}
+	if !s.MultiTenant {
+		return q, nil
+	}
+	if s.TenantID == "" {
+		return nil, ErrNoTenant
+	}
+	return q.Where("tenant_id = ?", s.TenantID), nil
+}
+
+// selectObject returns the object matching the query, or nil if there is none.
+func (s SQLiteStore) selectObject(q *sqlQuery) (Record, error) {
+	schema := s.schema()
+	row := s.DB.QueryRow("SELECT "+schema.selectColumns()+" FROM "+s.table()+q.String(), q.args...)
+	res, err := scanRecord(schema, row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s SQLiteStore) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// schema returns the schema of the objects.
+func (s SQLiteStore) schema() *Schema {
+	if s.Schema != nil {
+		return s.Schema
+	}
+	return NewSchema(s.API)
+}
+
+// columns returns the columns of the object table: the fields of the schema followed by the hidden columns.
+func (s SQLiteStore) columns() []SchemaField {
+	schema := s.schema()
+	return append(append([]SchemaField{}, schema.Fields...), schema.hidden...)
+}
+
+// table returns the quoted name of the store's table.
+func (s SQLiteStore) table() string {
+	return quoteIdent(tableOrDefault(s.Table))
+}
+
+// sqliteColumns returns the comma-separated quoted names of the columns.
+func sqliteColumns(columns []SchemaField) string {
+	var names []string
+	for _, f := range columns {
+		names = append(names, quoteIdent(f.Column))
+	}
+	return strings.Join(names, ", ")
+}
+
+func sqliteColumnDefinition(f SchemaField) string {
+	switch f.Type {
+	case model.FieldTypeInt, model.FieldTypeBoolean:
+		return quoteIdent(f.Column) + " INTEGER"
+	case model.FieldTypeFloat:
+		return quoteIdent(f.Column) + " REAL"
+	}
+	return quoteIdent(f.Column) + " TEXT"
+}
+
+// sqliteValue returns the value of a field as stored by SQLite: booleans as integers, and times as text in
+// timestampFormat.
+func sqliteValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case time.Time:
+		return v.UTC().Format(timestampFormat)
+	}
+	return value
+}
+
+// scanRecord scans a row holding the columns returned by selectColumns into a record, converting each column to the
+// type of its field.
+func scanRecord(schema *Schema, row interface{ Scan(...interface{}) error }) (Record, error) {
+	dest := make([]interface{}, len(schema.Fields))
+	for i := range dest {
+		dest[i] = new(interface{})
+	}
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	record := Record{}
+	for i, f := range schema.Fields {
+		record[f.Name], err = sqliteField(f, *(dest[i].(*interface{})))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not scan field %s", f.Name)
+		}
+	}
+	return record, nil
+}
+
+func sqliteField(f SchemaField, src interface{}) (interface{}, error) {
+	if src == nil {
+		return f.zero(), nil
+	}
+	if bytes, ok := src.([]byte); ok {
+		src = string(bytes)
+	}
+
+	switch f.Type {
+	case model.FieldTypeInt:
+		if n, ok := src.(int64); ok {
+			return n, nil
+		}
+	case model.FieldTypeFloat:
+		switch n := src.(type) {
+		case float64:
+			return n, nil
+		case int64:
+			return float64(n), nil
+		}
+	case model.FieldTypeBoolean:
+		if n, ok := src.(int64); ok {
+			return n != 0, nil
+		}
+	case model.FieldTypeTimestamp:
+		if s, ok := src.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	default:
+		return fmt.Sprint(src), nil
+	}
+	return nil, errors.Errorf("cannot scan %T into %s", src, f.Type)
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type SQLiteTestSuite struct {
+	StoreTestSuite
+	db *sql.DB
+}
+
+func (suite *SQLiteTestSuite) SetupTest() {
+	var err error
+	suite.db, err = sql.Open("sqlite", ":memory:")
+	assert.NoError(suite.T(), err)
+	// each connection to an in-memory database has its own database
+	suite.db.SetMaxOpenConns(1)
+	suite.newStore = func(api model.API, multiTenant bool) Store {
+		return SQLiteStore{DB: suite.db, API: api, MultiTenant: multiTenant}
+	}
+	suite.setup()
+}
+
+func (suite *SQLiteTestSuite) TearDownTest() {
+	suite.db.Close()
+}
+
+func TestSQLiteTestSuite(t *testing.T) {
+	suite.Run(t, new(SQLiteTestSuite))
+}
+
+func TestSQLiteDeclaredFieldsTestSuite(t *testing.T) {
+	suite.Run(t, &SQLiteTestSuite{StoreTestSuite: StoreTestSuite{fields: storeTestFields}})
+}
+
+func TestSQLiteTables(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	widgets := SQLiteStore{DB: db, Table: "widgets_objects"}
+	gadgets := SQLiteStore{DB: db, Table: "gadgets_objects"}
+	assert.NoError(t, widgets.CreateSchema())
+	assert.NoError(t, gadgets.CreateSchema())
+
+	created, err := widgets.CreateObject(Record{"test": "widget"})
+	assert.NoError(t, err)
+	res, err := gadgets.GetObject(created.ID())
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+	objects, err := widgets.ListObjects(10, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+	objects, err = gadgets.ListObjects(10, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+}
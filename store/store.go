@@ -3,19 +3,247 @@ package store
 //go:generate $GOPATH/bin/mockgen -source=$GOFILE -destination=$PWD/mocks/$GOFILE -package=mocks
 
 import (
-	"github.com/gracew/widget-proxy/generated"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoTenant is returned by a multi-tenant Store that has not been scoped to a tenant.
+	ErrNoTenant = errors.New("no tenant specified")
+	// ErrVersionConflict is returned when an object is modified with an expected version that is not its current
+	// version.
+	ErrVersionConflict = errors.New("object version does not match expected version")
 )
 
+// timestampFormat is used by stores that generate timestamps themselves. It has a fixed width, so that timestamps sort
+// lexically in time order.
+const timestampFormat = "2006-01-02T15:04:05.000000000Z"
+
+// AnyVersion may be passed as the expected version to modify an object regardless of its current version.
+const AnyVersion int64 = 0
+
+// DefaultTable is the table objects are stored in by a store that does not set a table.
+const DefaultTable = "objects"
+
+// tableOrDefault returns the table, or DefaultTable if it is empty.
+func tableOrDefault(table string) string {
+	if table == "" {
+		return DefaultTable
+	}
+	return table
+}
+
+// Store stores the records of an API. The fields of the records are those of the Schema of the API.
 type Store interface {
 	CreateSchema() error
-	CreateObject(obj *generated.Object) (*generated.Object, error)
-	GetObject(objectID string) (*generated.Object, error)
-	ListObjects(pageSize int, filter *Filter) ([]generated.Object, error)
-	UpdateObject(ob *generated.Object, action string) (*generated.Object, error)
-	DeleteObject(objectID string) error
+	CreateObject(obj Record) (Record, error)
+	// CreateObjects creates all of the objects, or none of them if an error is returned.
+	CreateObjects(objs []Record) ([]Record, error)
+	GetObject(objectID string) (Record, error)
+	// ListObjects lists objects in the order of the API's list sort. If after is not nil, the list starts with the object
+	// following after, which need only have the sort fields and id, whether or not that object still exists.
+	ListObjects(pageSize int, filter *Filter, after Record) ([]Record, error)
+	UpdateObject(obj Record, action string, expectedVersion int64) (Record, error)
+	// PatchObject updates only the given fields of the object, leaving its other fields unchanged. The operation, an
+	// update action or "patch", names the update in metrics and the audit log.
+	PatchObject(obj Record, operation string, fields []string, expectedVersion int64) (Record, error)
+	DeleteObject(objectID string, expectedVersion int64) error
+	// UpdateObjects applies the action to all of the objects, or to none of them if an error is returned. The version
+	// of each object is the version it is expected to have, or AnyVersion.
+	UpdateObjects(objs []Record, action string) ([]Record, error)
+	// DeleteObjects deletes all of the objects, or none of them if an error is returned. The version of each object is
+	// the version it is expected to have, or AnyVersion.
+	DeleteObjects(objs []Record) error
+	// GetDeletedObject gets a soft-deleted object by ID. It returns nil if no such object is found.
+	GetDeletedObject(objectID string) (Record, error)
+	// RestoreObject restores a soft-deleted object. It returns nil if no such object is found.
+	RestoreObject(objectID string) (Record, error)
+	// PurgeObjects permanently removes objects soft-deleted before the given time, in every tenant, and returns the
+	// number of objects removed.
+	PurgeObjects(deletedBefore time.Time) (int, error)
+	// WithCaller returns a Store acting on behalf of the caller. Multi-tenant stores are scoped to the caller's tenant.
+	WithCaller(caller Caller) Store
+}
+
+// Transactional is a Store whose queries can run in a Postgres transaction.
+type Transactional interface {
+	Store
+	// InTransaction returns a copy of the store whose queries run in the transaction.
+	InTransaction(tx *pg.Tx) Transactional
+	// LockObject gets an object by ID like GetObject, and locks it until the transaction of the store ends. It returns
+	// nil if the object is not found.
+	LockObject(objectID string) (Record, error)
+}
+
+// ItemError is returned by bulk operations when one of the objects cannot be written.
+type ItemError struct {
+	// Index is the position of the object in the bulk operation.
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
 }
 
+// Unwrap returns the error of the item.
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Caller identifies the user and request on whose behalf a Store is used.
+type Caller struct {
+	UserID    string
+	TenantID  string
+	RequestID string
+	// Consistency is the consistency the caller requires of reads.
+	Consistency Consistency
+}
+
+// Consistency is the consistency of reads from a Store that serves reads from replicas.
+type Consistency string
+
+const (
+	// ConsistencyEventual allows reads to be served by replicas, which may lag behind the primary.
+	ConsistencyEventual Consistency = ""
+	// ConsistencyStrong requires reads to be served by the primary.
+	ConsistencyStrong Consistency = "strong"
+)
+
 type Filter struct {
 	Field string
 	Value interface{}
 }
+
+// sqlQuery accumulates the conditions of a WHERE clause and their arguments, with the ? placeholders of both
+// database/sql drivers and go-pg.
+type sqlQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (q *sqlQuery) Where(condition string, args ...interface{}) *sqlQuery {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+	return q
+}
+
+func (q *sqlQuery) String() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// systemColumns are maintained by the store, and are never updated from the fields of an update action.
+var systemColumns = map[string]bool{"version": true, "updated_at": true, "updated_by": true}
+
+// validFilter returns whether the API definition declares the filter's field as a list filter.
+func validFilter(api model.API, filter Filter) bool {
+	if api.Operations == nil || api.Operations.List == nil {
+		return false
+	}
+	for _, f := range api.Operations.List.Filter {
+		if f == filter.Field {
+			return true
+		}
+	}
+	return false
+}
+
+// listSort returns the order of lists: the declared sort order, or createdAt DESC if none is declared, followed by id
+// so that objects with the same sort values are listed in the same order, and pages of a list neither repeat nor skip
+// them.
+func listSort(api model.API) []model.SortDefinition {
+	sorts := []model.SortDefinition{{Field: "createdAt", Order: model.SortOrderDesc}}
+	if api.Operations != nil && api.Operations.List != nil && len(api.Operations.List.Sort) > 0 {
+		sorts = api.Operations.List.Sort
+	}
+	return append(append([]model.SortDefinition{}, sorts...), model.SortDefinition{Field: "id", Order: model.SortOrderAsc})
+}
+
+// order returns the ORDER BY expressions for lists. NULL sorts after every other value, as it does by default in
+// Postgres, and as the memory store sorts nil.
+func order(api model.API) []string {
+	var res []string
+	for _, sort := range listSort(api) {
+		nulls := " NULLS LAST"
+		if sort.Order == model.SortOrderDesc {
+			nulls = " NULLS FIRST"
+		}
+		res = append(res, quoteIdent(underscore(sort.Field))+" "+sort.Order.String()+nulls)
+	}
+	return res
+}
+
+// after adds the condition that objects follow, in the order of lists, the object with the sort values of the record
+// after. value, if not nil, converts each sort value to its database representation.
+func (q *sqlQuery) after(api model.API, after Record, value func(interface{}) interface{}) *sqlQuery {
+	var alternatives, equal []string
+	var args, equalArgs []interface{}
+	for _, sort := range listSort(api) {
+		column := quoteIdent(underscore(sort.Field))
+		v := after[sort.Field]
+		if v != nil && value != nil {
+			v = value(v)
+		}
+
+		// the objects with the same values in the previous columns that follow in this column
+		var follows string
+		var followsArgs []interface{}
+		switch {
+		case v == nil && sort.Order == model.SortOrderDesc:
+			follows = column + " IS NOT NULL"
+		case v != nil && sort.Order == model.SortOrderAsc:
+			follows = "(" + column + " > ? OR " + column + " IS NULL)"
+			followsArgs = []interface{}{v}
+		case v != nil && sort.Order == model.SortOrderDesc:
+			follows = column + " < ?"
+			followsArgs = []interface{}{v}
+		}
+		if follows != "" {
+			conditions := append(append([]string{}, equal...), follows)
+			alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+			args = append(append(args, equalArgs...), followsArgs...)
+		}
+
+		if v == nil {
+			equal = append(equal, column+" IS NULL")
+		} else {
+			equal = append(equal, column+" = ?")
+			equalArgs = append(equalArgs, v)
+		}
+	}
+	if len(alternatives) == 0 {
+		return q.Where("1 = 0")
+	}
+	return q.Where("("+strings.Join(alternatives, " OR ")+")", args...)
+}
+
+// FindAction returns the update action with the given name, or nil if the API definition does not declare it.
+func FindAction(api model.API, actionName string) *model.ActionDefinition {
+	if api.Operations == nil || api.Operations.Update == nil {
+		return nil
+	}
+	for _, action := range api.Operations.Update.Actions {
+		if action.Name == actionName {
+			return &action
+		}
+	}
+	return nil
+}
+
+// softDelete returns whether the API definition enables soft deletes.
+func softDelete(api model.API) bool {
+	return api.Operations != nil && api.Operations.Delete != nil && api.Operations.Delete.SoftDelete
+}
+
+// timestamp returns the current time in timestampFormat.
+func timestamp() string {
+	return time.Now().UTC().Format(timestampFormat)
+}
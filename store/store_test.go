@@ -0,0 +1,449 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gracew/widget-proxy/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// StoreTestSuite is the conformance suite every Store implementation must pass. Suites for each implementation embed
+// it, and set newStore before calling setup.
+type StoreTestSuite struct {
+	suite.Suite
+	api model.API
+	// fields are declared in the API definition, if set
+	fields []model.FieldDefinition
+	// newStore returns the Store under test for the API definition.
+	newStore func(api model.API, multiTenant bool) Store
+	s        Store
+}
+
+// setup creates the Store under test and its schema.
+func (suite *StoreTestSuite) setup() {
+	suite.api = model.API{
+		Fields: suite.fields,
+		Operations: &model.OperationDefinition{
+			List: &model.ListDefinition{
+				Filter: []string{"test"},
+			},
+			Update: &model.UpdateDefinition{
+				Actions: []model.ActionDefinition{
+					model.ActionDefinition{Name: "action", Fields: []string{"test"}},
+				},
+			},
+		},
+	}
+	suite.s = suite.newStore(suite.api, false)
+	err := suite.s.CreateSchema()
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *StoreTestSuite) TestCreateGet() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), obj["test"], createRes["test"])
+	assert.Equal(suite.T(), "userID", createRes.CreatedBy())
+	assert.NotEmpty(suite.T(), createRes.ID())
+	assert.NotEmpty(suite.T(), createRes["createdAt"])
+
+	getRes, err := suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), createRes, getRes)
+}
+
+func (suite *StoreTestSuite) TestDeclaredFields() {
+	if len(suite.fields) == 0 {
+		suite.T().Skip("the API declares no fields")
+	}
+	publishedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	obj := Record{"test": "test", "createdBy": "userID", "pageCount": int64(3), "rating": 4.5, "published": true,
+		"publishedAt": publishedAt}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), createRes["title"])
+	assert.IsType(suite.T(), time.Time{}, createRes["createdAt"])
+
+	getRes, err := suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(3), getRes["pageCount"])
+	assert.Equal(suite.T(), 4.5, getRes["rating"])
+	assert.Equal(suite.T(), true, getRes["published"])
+	assert.True(suite.T(), publishedAt.Equal(getRes["publishedAt"].(time.Time)))
+	assert.Nil(suite.T(), getRes["title"])
+}
+
+func (suite *StoreTestSuite) TestGetUnknownID() {
+	res, err := suite.s.GetObject(uuid.New().String())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), res)
+}
+
+func (suite *StoreTestSuite) TestList() {
+	obj1 := Record{"test": "test", "createdBy": "userID"}
+	res1, err := suite.s.CreateObject(obj1)
+	assert.NoError(suite.T(), err)
+
+	obj2 := Record{"test": "test", "createdBy": "userID"}
+	res2, err := suite.s.CreateObject(obj2)
+	assert.NoError(suite.T(), err)
+
+	res, err := suite.s.ListObjects(100, nil, nil)
+	assert.NoError(suite.T(), err)
+	ids := []string{}
+	for _, o := range res {
+		ids = append(ids, o.ID())
+	}
+	assert.Contains(suite.T(), ids, res1.ID())
+	assert.Contains(suite.T(), ids, res2.ID())
+}
+
+func (suite *StoreTestSuite) TestListFilter() {
+	obj1 := Record{"test": "test1", "createdBy": "userID"}
+	res1, err := suite.s.CreateObject(obj1)
+	assert.NoError(suite.T(), err)
+
+	obj2 := Record{"test": "test2", "createdBy": "userID"}
+	res2, err := suite.s.CreateObject(obj2)
+	assert.NoError(suite.T(), err)
+
+	res, err := suite.s.ListObjects(100, &Filter{Field: "test", Value: "test1"}, nil)
+	assert.NoError(suite.T(), err)
+	ids := []string{}
+	for _, o := range res {
+		ids = append(ids, o.ID())
+	}
+	assert.Contains(suite.T(), ids, res1.ID())
+	assert.NotContains(suite.T(), ids, res2.ID())
+}
+
+func (suite *StoreTestSuite) TestUpdate() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	update := Record{"id": createRes.ID(), "test": "test2", "createdBy": "userID2"}
+	updateRes, err := suite.s.UpdateObject(update, "action", AnyVersion)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), update["test"], updateRes["test"])
+	// CreatedBy is unchanged since it's not an action field
+	assert.Equal(suite.T(), createRes.CreatedBy(), updateRes.CreatedBy())
+	assert.Equal(suite.T(), createRes["createdAt"], updateRes["createdAt"])
+}
+
+func (suite *StoreTestSuite) TestPatch() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	// no fields are given, so only the version changes
+	patchRes, err := suite.s.PatchObject(Record{"id": createRes.ID()}, "patch", nil, 1)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "test", patchRes["test"])
+	assert.Equal(suite.T(), int64(2), patchRes.Version())
+
+	patch := Record{"id": createRes.ID(), "test": "test2", "createdBy": "userID2"}
+	patchRes, err = suite.s.PatchObject(patch, "patch", []string{"test"}, AnyVersion)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "test2", patchRes["test"])
+	assert.Equal(suite.T(), createRes.CreatedBy(), patchRes.CreatedBy())
+
+	_, err = suite.s.PatchObject(Record{"id": createRes.ID()}, "patch", []string{"test"}, 1)
+	assert.Equal(suite.T(), ErrVersionConflict, err)
+}
+
+func (suite *StoreTestSuite) TestUpdatedAtBy() {
+	suite.api.Operations.Update.Actions = append(suite.api.Operations.Update.Actions,
+		model.ActionDefinition{Name: "touch", Fields: []string{"updatedBy"}})
+	suite.s = suite.newStore(suite.api, false)
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "userID", createRes["updatedBy"])
+	assert.NotEmpty(suite.T(), createRes["updatedAt"])
+
+	s := suite.s.WithCaller(Caller{UserID: "userID2"})
+	update := Record{"id": createRes.ID(), "updatedBy": "spoofed"}
+	updateRes, err := s.UpdateObject(update, "touch", AnyVersion)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "userID2", updateRes["updatedBy"])
+	assert.NotEqual(suite.T(), createRes["updatedAt"], updateRes["updatedAt"])
+	assert.Equal(suite.T(), createRes["createdAt"], updateRes["createdAt"])
+}
+
+func (suite *StoreTestSuite) TestUpdateVersion() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), createRes.Version())
+
+	update := Record{"id": createRes.ID(), "test": "test2"}
+	updateRes, err := suite.s.UpdateObject(update, "action", 1)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), updateRes.Version())
+
+	_, err = suite.s.UpdateObject(Record{"id": createRes.ID(), "test": "test3"}, "action", 1)
+	assert.Equal(suite.T(), ErrVersionConflict, err)
+
+	err = suite.s.DeleteObject(createRes.ID(), 1)
+	assert.Equal(suite.T(), ErrVersionConflict, err)
+
+	err = suite.s.DeleteObject(createRes.ID(), 2)
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *StoreTestSuite) TestConcurrentUpdates() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	// of the updates expecting the initial version, exactly one is applied
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := suite.s.UpdateObject(Record{"id": createRes.ID(), "test": "test2"}, "action", 1)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	applied := 0
+	for err := range errs {
+		if err == nil {
+			applied++
+		} else {
+			assert.Equal(suite.T(), ErrVersionConflict, err)
+		}
+	}
+	assert.Equal(suite.T(), 1, applied)
+
+	getRes, err := suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), getRes.Version())
+}
+
+func (suite *StoreTestSuite) TestListSort() {
+	suite.api.Operations.List.Sort = []model.SortDefinition{
+		model.SortDefinition{Field: "test", Order: model.SortOrderAsc},
+	}
+	suite.s = suite.newStore(suite.api, true).WithCaller(Caller{TenantID: uuid.New().String()})
+
+	for _, test := range []string{"b", "c", "a"} {
+		_, err := suite.s.CreateObject(Record{"test": test, "createdBy": "userID"})
+		assert.NoError(suite.T(), err)
+	}
+
+	res, err := suite.s.ListObjects(2, nil, nil)
+	assert.NoError(suite.T(), err)
+	var tests []string
+	for _, o := range res {
+		tests = append(tests, o["test"].(string))
+	}
+	assert.Equal(suite.T(), []string{"a", "b"}, tests)
+}
+
+func (suite *StoreTestSuite) TestListAfter() {
+	suite.api.Operations.List.Sort = []model.SortDefinition{
+		model.SortDefinition{Field: "test", Order: model.SortOrderDesc},
+	}
+	objs := []Record{Record{"test": "a"}, Record{"test": "b"}, Record{"test": "b"}, Record{"test": "c"}}
+	if len(suite.fields) > 0 {
+		// null sorts after every other value
+		suite.api.Operations.List.Sort = append(suite.api.Operations.List.Sort,
+			model.SortDefinition{Field: "pageCount", Order: model.SortOrderAsc})
+		objs[1]["pageCount"] = int64(1)
+		objs[3]["pageCount"] = int64(2)
+	}
+	suite.s = suite.newStore(suite.api, true).WithCaller(Caller{TenantID: uuid.New().String()})
+	for _, obj := range objs {
+		_, err := suite.s.CreateObject(obj)
+		assert.NoError(suite.T(), err)
+	}
+
+	all, err := suite.s.ListObjects(100, nil, nil)
+	assert.NoError(suite.T(), err)
+	var tests []string
+	for _, o := range all {
+		tests = append(tests, o["test"].(string))
+	}
+	assert.Equal(suite.T(), []string{"c", "b", "b", "a"}, tests)
+	if len(suite.fields) > 0 {
+		assert.Equal(suite.T(), int64(1), all[1]["pageCount"])
+	}
+
+	// pages of one object each, continuing from a cursor of the previous object
+	var pages []Record
+	var after Record
+	for len(pages) <= len(objs) {
+		res, err := suite.s.ListObjects(1, nil, after)
+		assert.NoError(suite.T(), err)
+		if len(res) == 0 {
+			break
+		}
+		pages = append(pages, res...)
+		after, err = DecodeCursor(suite.api, NewSchema(suite.api), EncodeCursor(suite.api, res[0]))
+		assert.NoError(suite.T(), err)
+	}
+	assert.Equal(suite.T(), all, pages)
+}
+
+func (suite *StoreTestSuite) TestListInvalidFilter() {
+	_, err := suite.s.ListObjects(100, &Filter{Field: "createdBy", Value: "userID"}, nil)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *StoreTestSuite) TestUpdateUnknownAction() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	_, err = suite.s.UpdateObject(Record{"id": createRes.ID(), "test": "test2"}, "unknown", AnyVersion)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *StoreTestSuite) TestDelete() {
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	err = suite.s.DeleteObject(createRes.ID(), AnyVersion)
+	assert.NoError(suite.T(), err)
+
+	nilRes, err := suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), nilRes)
+}
+
+func (suite *StoreTestSuite) TestSoftDeleteRestore() {
+	suite.api.Operations.Delete = &model.DeleteDefinition{SoftDelete: true}
+	suite.s = suite.newStore(suite.api, false)
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	err = suite.s.DeleteObject(createRes.ID(), AnyVersion)
+	assert.NoError(suite.T(), err)
+
+	getRes, err := suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), getRes)
+	listRes, err := suite.s.ListObjects(100, nil, nil)
+	assert.NoError(suite.T(), err)
+	for _, o := range listRes {
+		assert.NotEqual(suite.T(), createRes.ID(), o.ID())
+	}
+
+	deletedRes, err := suite.s.GetDeletedObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), createRes.ID(), deletedRes.ID())
+
+	restoreRes, err := suite.s.RestoreObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(3), restoreRes.Version())
+
+	getRes, err = suite.s.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), restoreRes, getRes)
+
+	restoreRes, err = suite.s.RestoreObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), restoreRes)
+}
+
+func (suite *StoreTestSuite) TestPurge() {
+	suite.api.Operations.Delete = &model.DeleteDefinition{SoftDelete: true, RetentionDays: 30}
+	suite.s = suite.newStore(suite.api, false)
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := suite.s.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+	err = suite.s.DeleteObject(createRes.ID(), AnyVersion)
+	assert.NoError(suite.T(), err)
+
+	purged, err := suite.s.PurgeObjects(time.Now().Add(-time.Hour))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, purged)
+
+	purged, err = suite.s.PurgeObjects(time.Now().Add(time.Hour))
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, purged)
+
+	deletedRes, err := suite.s.GetDeletedObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), deletedRes)
+}
+
+func (suite *StoreTestSuite) TestBulk() {
+	objs := []Record{
+		Record{"test": "a", "createdBy": "userID"},
+		Record{"test": "b", "createdBy": "userID"},
+	}
+	createRes, err := suite.s.CreateObjects(objs)
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), createRes, 2)
+	assert.NotEmpty(suite.T(), createRes[0].ID())
+	assert.NotEqual(suite.T(), createRes[0].ID(), createRes[1].ID())
+
+	updates := []Record{
+		Record{"id": createRes[0].ID(), "test": "c", "version": int64(1)},
+		Record{"id": createRes[1].ID(), "test": "d", "version": int64(2)},
+	}
+	_, err = suite.s.UpdateObjects(updates, "action")
+	var itemErr *ItemError
+	assert.True(suite.T(), errors.As(err, &itemErr))
+	assert.Equal(suite.T(), 1, itemErr.Index)
+	assert.True(suite.T(), errors.Is(err, ErrVersionConflict))
+	getRes, err := suite.s.GetObject(createRes[0].ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "a", getRes["test"])
+
+	updates[0]["version"] = int64(1)
+	updates[1]["version"] = AnyVersion
+	updateRes, err := suite.s.UpdateObjects(updates, "action")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "c", updateRes[0]["test"])
+	assert.Equal(suite.T(), int64(2), updateRes[0].Version())
+
+	err = suite.s.DeleteObjects([]Record{Record{"id": createRes[0].ID()}, Record{"id": createRes[1].ID()}})
+	assert.NoError(suite.T(), err)
+	getRes, err = suite.s.GetObject(createRes[1].ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), getRes)
+}
+
+func (suite *StoreTestSuite) TestTenantIsolation() {
+	suite.s = suite.newStore(suite.api, true)
+	tenant1 := suite.s.WithCaller(Caller{UserID: "userID", TenantID: "tenant1"})
+	tenant2 := suite.s.WithCaller(Caller{UserID: "userID", TenantID: "tenant2"})
+
+	obj := Record{"test": "test", "createdBy": "userID"}
+	createRes, err := tenant1.CreateObject(obj)
+	assert.NoError(suite.T(), err)
+
+	getRes, err := tenant2.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), getRes)
+
+	listRes, err := tenant2.ListObjects(100, nil, nil)
+	assert.NoError(suite.T(), err)
+	for _, o := range listRes {
+		assert.NotEqual(suite.T(), createRes.ID(), o.ID())
+	}
+
+	err = tenant2.DeleteObject(createRes.ID(), AnyVersion)
+	assert.NoError(suite.T(), err)
+	getRes, err = tenant1.GetObject(createRes.ID())
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), createRes, getRes)
+
+	_, err = suite.s.GetObject(createRes.ID())
+	assert.Equal(suite.T(), ErrNoTenant, err)
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gracew/widget-proxy/model"
+)
+
+// APIKeyHeader is the request header carrying an API key.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKeyAuthenticator authenticates service-to-service callers by API key. Keys are stored as hex-encoded SHA-256
+// hashes, so the configuration never contains a usable key.
+type APIKeyAuthenticator struct {
+	Keys []model.APIKey
+}
+
+func (a APIKeyAuthenticator) GetUser(header http.Header) (*User, error) {
+	key := header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	for _, k := range a.Keys {
+		expected, err := hex.DecodeString(k.Hash)
+		if err != nil || subtle.ConstantTimeCompare(hash[:], expected) != 1 {
+			continue
+		}
+		if k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt) {
+			return nil, ErrInvalidCredentials
+		}
+		return &User{ID: k.Principal, Roles: k.Roles, TenantID: k.TenantID, Scopes: k.Scopes}, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of the key, as stored in the API key file.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
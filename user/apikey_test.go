@@ -0,0 +1,57 @@
+package user
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gracew/widget-proxy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	a := APIKeyAuthenticator{Keys: []model.APIKey{
+		model.APIKey{Hash: HashAPIKey("key1"), Principal: "billing", TenantID: "tenant1", Scopes: []string{"read"}},
+		model.APIKey{Hash: HashAPIKey("key2"), Principal: "reports", ExpiresAt: &past},
+	}}
+
+	u, err := a.GetUser(apiKeyHeader("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, &User{ID: "billing", TenantID: "tenant1", Scopes: []string{"read"}}, u)
+	assert.True(t, u.CanInvoke("read"))
+	assert.False(t, u.CanInvoke("delete"))
+
+	_, err = a.GetUser(apiKeyHeader("key2"))
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.GetUser(apiKeyHeader("unknown"))
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.GetUser(http.Header{})
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	a := ChainAuthenticator{Authenticators: []Authenticator{
+		APIKeyAuthenticator{Keys: []model.APIKey{model.APIKey{Hash: HashAPIKey("key1"), Principal: "billing"}}},
+		ParseAuthenticator{},
+	}}
+
+	u, err := a.GetUser(apiKeyHeader("key1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", u.ID)
+	assert.True(t, u.CanInvoke("delete"))
+
+	_, err = a.GetUser(apiKeyHeader("unknown"))
+	assert.Equal(t, ErrInvalidCredentials, err)
+
+	_, err = a.GetUser(http.Header{})
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func apiKeyHeader(key string) http.Header {
+	header := http.Header{}
+	header.Set(APIKeyHeader, key)
+	return header
+}
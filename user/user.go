@@ -6,51 +6,174 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strings"
 
-	"github.com/gracew/widget-proxy/config"
 	"github.com/pkg/errors"
 )
 
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request does not carry its type of credential.
+	ErrNoCredentials = errors.New("no credentials provided")
+	// ErrInvalidCredentials is returned by an Authenticator when the request carries a credential that is not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// User is the identity of an authenticated caller.
+type User struct {
+	ID    string
+	Roles []string
+	// TenantID identifies the tenant the user belongs to when the proxy runs in multi-tenant mode.
+	TenantID string
+	// Scopes restricts the operations and update actions the user may invoke. A nil value means the user is not
+	// restricted.
+	Scopes []string
+}
+
+// CanInvoke returns whether the user's scopes permit the given operation or update action.
+func (u User) CanInvoke(operation string) bool {
+	if u.Scopes == nil {
+		return true
+	}
+	for _, scope := range u.Scopes {
+		if scope == operation {
+			return true
+		}
+	}
+	return false
+}
+
 type Authenticator interface {
-	GetUserId(header http.Header) (string, error)
+	GetUser(header http.Header) (*User, error)
 }
 
-type ParseAuthenticator struct{}
+// ChainAuthenticator tries each of its Authenticators in order and returns the first user found. Authenticators that
+// find no credentials of their type are skipped.
+type ChainAuthenticator struct {
+	Authenticators []Authenticator
+}
+
+func (a ChainAuthenticator) GetUser(header http.Header) (*User, error) {
+	for _, authenticator := range a.Authenticators {
+		u, err := authenticator.GetUser(header)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return u, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// TenantRolePrefix prefixes the name of the Parse role whose members belong to a tenant, e.g. tenant_acme. Unlike the
+// fields of a Parse user, which the user may edit, roles can only be changed by the holders of the master key or of the
+// role's write permission.
+const TenantRolePrefix = "tenant_"
+
+// ParseAuthenticator authenticates users by their Parse session token. A user's tenant is given by the Parse role named
+// with TenantRolePrefix that they are a member of.
+type ParseAuthenticator struct {
+	// URL is the URL of the Parse server.
+	URL string
+}
 
 type CreateRes struct {
 	CreatedAt string `json:"createdAt"`
 	ObjectID  string `json:"objectId"`
 }
 
-func (a ParseAuthenticator) GetUserId(header http.Header) (string, error) {
-	parseToken := header["X-Parse-Session-Token"][0]
-	parseURL, err := parseURL("users/me")
+type rolesRes struct {
+	Results []struct {
+		Name string `json:"name"`
+	} `json:"results"`
+}
+
+func (a ParseAuthenticator) GetUser(header http.Header) (*User, error) {
+	parseToken := header.Get("X-Parse-Session-Token")
+	if parseToken == "" {
+		return nil, ErrNoCredentials
+	}
+	parseURL, err := a.parseURL("users/me")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req, err := http.NewRequest("GET", parseURL, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req.Header.Add("X-Parse-Application-Id", "appId")
 	req.Header.Add("X-Parse-Session-Token", parseToken)
 	client := &http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to fetch information for current user")
+		return nil, errors.Wrap(err, "failed to fetch information for current user")
 	}
+	defer res.Body.Close()
 	var parseRes CreateRes
 	err = json.NewDecoder(res.Body).Decode(&parseRes)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to json decode response")
+		return nil, errors.Wrap(err, "failed to json decode response")
+	}
+	if parseRes.ObjectID == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	roles, err := a.getRoles(parseToken, parseRes.ObjectID)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{ID: parseRes.ObjectID}
+	for _, role := range roles {
+		if !strings.HasPrefix(role, TenantRolePrefix) {
+			u.Roles = append(u.Roles, role)
+			continue
+		}
+		if u.TenantID != "" {
+			return nil, errors.Errorf("user %s is a member of more than one tenant role", u.ID)
+		}
+		u.TenantID = strings.TrimPrefix(role, TenantRolePrefix)
+	}
+	return u, nil
+}
+
+// getRoles returns the names of the Parse roles that directly contain the user.
+func (a ParseAuthenticator) getRoles(parseToken string, userID string) ([]string, error) {
+	where, err := json.Marshal(map[string]interface{}{
+		"users": map[string]string{"__type": "Pointer", "className": "_User", "objectId": userID},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal roles query")
+	}
+	parseURL, err := a.parseURL("roles?where=" + url.QueryEscape(string(where)))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", parseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Parse-Application-Id", "appId")
+	req.Header.Add("X-Parse-Session-Token", parseToken)
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch roles for current user")
+	}
+	defer res.Body.Close()
+	var parseRes rolesRes
+	err = json.NewDecoder(res.Body).Decode(&parseRes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to json decode response")
+	}
+	var roles []string
+	for _, role := range parseRes.Results {
+		roles = append(roles, role.Name)
 	}
-	return parseRes.ObjectID, nil
+	return roles, nil
 }
 
-func parseURL(path string) (string, error) {
-	parseURL, err := url.Parse(config.ParseURL)
+func (a ParseAuthenticator) parseURL(path string) (string, error) {
+	parseURL, err := url.Parse(a.URL)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not parse PARSE_URL as URL: %s", config.ParseURL)
+		return "", errors.Wrapf(err, "could not parse PARSE_URL as URL: %s", a.URL)
 	}
 	pathURL, err := url.Parse(path)
 	if err != nil {
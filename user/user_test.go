@@ -0,0 +1,45 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAuthenticatorTenant(t *testing.T) {
+	roles := []string{"hr", "tenant_acme"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/me" {
+			// tenantId is a field of the user, which the user may set to any value
+			json.NewEncoder(w).Encode(map[string]string{"objectId": "userID", "tenantId": "anotherTenant"})
+			return
+		}
+		var res rolesRes
+		for _, role := range roles {
+			res.Results = append(res.Results, struct {
+				Name string `json:"name"`
+			}{Name: role})
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer server.Close()
+	a := ParseAuthenticator{URL: server.URL}
+	header := http.Header{}
+	header.Set("X-Parse-Session-Token", "token")
+
+	u, err := a.GetUser(header)
+	assert.NoError(t, err)
+	assert.Equal(t, &User{ID: "userID", Roles: []string{"hr"}, TenantID: "acme"}, u)
+
+	roles = []string{"hr"}
+	u, err = a.GetUser(header)
+	assert.NoError(t, err)
+	assert.Equal(t, "", u.TenantID)
+
+	roles = []string{"tenant_acme", "tenant_other"}
+	_, err = a.GetUser(header)
+	assert.Error(t, err)
+}